@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"parking_lot/services"
+	"parking_lot/storage"
+
+	"github.com/gorilla/mux"
+)
+
+var errUnauthorized = &storage.ParkingError{
+	Code:    storage.CodeUnauthorized,
+	Status:  http.StatusUnauthorized,
+	Message: "admin key required",
+}
+
+var errOutsideOrganization = &storage.ParkingError{
+	Code:    storage.CodeForbidden,
+	Status:  http.StatusForbidden,
+	Message: "organization key does not grant access to this organization",
+}
+
+var errLotOutsideOrganization = &storage.ParkingError{
+	Code:    storage.CodeForbidden,
+	Status:  http.StatusForbidden,
+	Message: "organization key does not grant access to this parking lot",
+}
+
+type orgIDKey struct{}
+
+// adminOnly rejects requests whose X-Admin-Key header does not match adminKey.
+// When no admin key is configured every request is rejected.
+func adminOnly(adminKey string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Admin-Key")
+		if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			writeError(w, errUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// orgKeyScope resolves the organization of requests carrying an X-Org-Key header, so
+// handlers can restrict them to that organization's lots. Unknown keys are rejected.
+func orgKeyScope(service *services.ParkingLotService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-Org-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			org, err := service.GetOrganizationByKey(r.Context(), key)
+			if err != nil {
+				if errors.Is(err, storage.ErrOrganizationNotFound) {
+					err = &storage.ParkingError{Code: storage.CodeUnauthorized, Status: http.StatusUnauthorized, Message: "unknown organization key"}
+				}
+				writeError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), orgIDKey{}, org.ID)))
+		})
+	}
+}
+
+// scopedOrgID returns the organization a request is restricted to by its key, or
+// requested when it carries none. Requesting another organization than the key's fails.
+func scopedOrgID(r *http.Request, requested int) (int, error) {
+	keyOrgID, ok := r.Context().Value(orgIDKey{}).(int)
+	if !ok {
+		return requested, nil
+	}
+	if requested != 0 && requested != keyOrgID {
+		return 0, errOutsideOrganization
+	}
+	return keyOrgID, nil
+}
+
+// lotScope rejects requests restricted to an organization by its key that name a parking
+// lot of another organization, in the path, the query or the JSON body. Lots that do not
+// exist are left for the handler to report.
+func lotScope(lotOrgID func(ctx context.Context, parkingLotID int) (int, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyOrgID, ok := r.Context().Value(orgIDKey{}).(int)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			lotIDs, err := requestedLotIDs(r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			for _, lotID := range lotIDs {
+				orgID, err := lotOrgID(r.Context(), lotID)
+				if errors.Is(err, storage.ErrLotNotFound) {
+					continue
+				}
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				if orgID != keyOrgID {
+					writeError(w, errLotOutsideOrganization)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestedLotIDs returns the parking lots a request names, leaving out zero IDs. A JSON body is read and put
+// back for the handler.
+func requestedLotIDs(r *http.Request) ([]int, error) {
+	var lotIDs []int
+	if id, err := strconv.Atoi(mux.Vars(r)["id"]); err == nil {
+		lotIDs = append(lotIDs, id)
+	}
+	for _, name := range []string{"parkingLotID", "overflowLotID"} {
+		if id, err := strconv.Atoi(r.URL.Query().Get(name)); err == nil {
+			lotIDs = append(lotIDs, id)
+		}
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return lotIDs, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errInvalidBody
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var request struct {
+		ParkingLotID  int   `json:"parkingLotID"`
+		ParkingLotIDs []int `json:"parkingLotIDs"`
+		OverflowLotID int   `json:"overflowLotID"`
+	}
+	// Malformed bodies are left for the handler to reject.
+	if json.Unmarshal(body, &request) == nil {
+		lotIDs = append(lotIDs, request.ParkingLotID, request.OverflowLotID)
+		lotIDs = append(lotIDs, request.ParkingLotIDs...)
+	}
+
+	named := lotIDs[:0]
+	for _, id := range lotIDs {
+		if id != 0 {
+			named = append(named, id)
+		}
+	}
+	return named, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"parking_lot/storage"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLotScopeRejectsOtherOrganizationsLots(t *testing.T) {
+	// Lot 1 belongs to organization 1, lot 2 to organization 2 and lot 3 to none.
+	lotOrgs := map[int]int{1: 1, 2: 2, 3: 0}
+	lotOrgID := func(ctx context.Context, parkingLotID int) (int, error) {
+		orgID, ok := lotOrgs[parkingLotID]
+		if !ok {
+			return 0, storage.ErrLotNotFound
+		}
+		return orgID, nil
+	}
+
+	var handlerBody string
+	router := mux.NewRouter()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		handlerBody = string(body)
+	}
+	router.HandleFunc("/parkingLot/{id}", handler).Methods("GET")
+	router.HandleFunc("/viewParkingLotStatus", handler).Methods("GET")
+	router.HandleFunc("/unparkVehicle", handler).Methods("POST")
+	router.HandleFunc("/getTotalStatsMulti", handler).Methods("POST")
+	router.Use(lotScope(lotOrgID))
+
+	tests := []struct {
+		name       string
+		keyOrgID   int
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"own lot in path", 1, "GET", "/parkingLot/1", "", http.StatusOK},
+		{"other lot in path", 1, "GET", "/parkingLot/2", "", http.StatusForbidden},
+		{"other lot in query", 1, "GET", "/viewParkingLotStatus?parkingLotID=2", "", http.StatusForbidden},
+		{"lot without organization", 1, "GET", "/viewParkingLotStatus?parkingLotID=3", "", http.StatusForbidden},
+		{"own lot in body", 1, "POST", "/unparkVehicle", `{"parkingLotID": 1, "licensePlate": "ABC123"}`, http.StatusOK},
+		{"other lot in body", 1, "POST", "/unparkVehicle", `{"parkingLotID": 2, "licensePlate": "ABC123"}`, http.StatusForbidden},
+		{"other lot among several", 1, "POST", "/getTotalStatsMulti", `{"parkingLotIDs": [1, 2]}`, http.StatusForbidden},
+		{"unknown lot", 1, "GET", "/parkingLot/9", "", http.StatusOK},
+		{"malformed body", 1, "POST", "/unparkVehicle", `{"parkingLotID": `, http.StatusOK},
+		{"no organization key", 0, "GET", "/parkingLot/2", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerBody = ""
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.keyOrgID != 0 {
+				r = r.WithContext(context.WithValue(r.Context(), orgIDKey{}, tt.keyOrgID))
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && handlerBody != tt.body {
+				t.Errorf("handler read body %q, want %q", handlerBody, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"parking_lot/services"
+	"parking_lot/storage"
+)
+
+// envDuration reads a duration such as "30s" from the named environment variable,
+// falling back to def when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// envInt reads a positive integer from the named environment variable, falling back
+// to def when it is unset or invalid.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// seedDefaultLot creates a lot of SEED_LOT_SIZE spaces when the variable is set and
+// no lots exist yet, so demos start with a usable lot.
+func seedDefaultLot(service *services.ParkingLotService) {
+	value := os.Getenv("SEED_LOT_SIZE")
+	if value == "" {
+		return
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		log.Printf("invalid SEED_LOT_SIZE %q, not seeding a lot", value)
+		return
+	}
+
+	ctx := context.Background()
+	count, err := service.CountParkingLots(ctx)
+	if err != nil {
+		log.Fatal("Failed to check for existing parking lots:", err)
+	}
+	if count > 0 {
+		return
+	}
+
+	parkingLot, err := service.CreateParkingLot(ctx, size, storage.LotOptions{})
+	if err != nil {
+		log.Fatal("Failed to seed parking lot:", err)
+	}
+	log.Printf("seeded parking lot %d with %d spaces", parkingLot.ID, size)
+}
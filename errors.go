@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"parking_lot/services"
+	"parking_lot/storage"
+)
+
+var errInvalidBody = &storage.ParkingError{
+	Code:    storage.CodeInvalidRequest,
+	Status:  http.StatusBadRequest,
+	Message: "Invalid request body",
+}
+
+// timeoutMessage is the body written by the request timeout handler when a request
+// takes too long. Its context is cancelled, which also cancels any running query.
+const timeoutMessage = `{"code":"TIMEOUT","message":"request timed out"}`
+
+// streamedRoutes are the routes whose responses are sent as they are written, such as
+// CSV exports. http.TimeoutHandler buffers the whole response, so they are only given a
+// context deadline instead.
+var streamedRoutes = map[string]bool{
+	"/occupancy.csv": true,
+}
+
+// withRequestTimeout cancels requests that take longer than timeout. Other than streamed
+// routes, they are answered with timeoutMessage; streamed routes may already have sent
+// part of their response, so their context is only cancelled.
+func withRequestTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	buffered := http.TimeoutHandler(next, timeout, timeoutMessage)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !streamedRoutes[r.URL.Path] {
+			buffered.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// writeError renders err as {code, message} with the status carried by the error.
+// Errors that are not a ParkingError are reported as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	var parkingErr *storage.ParkingError
+	if !errors.As(err, &parkingErr) {
+		parkingErr = &storage.ParkingError{
+			Code:    storage.CodeInternal,
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(parkingErr.Status)
+	json.NewEncoder(w).Encode(parkingErr)
+}
+
+// writeParkError renders an error from parking a vehicle. When the lot is full it also
+// sets Retry-After and includes the suggested wait in the body, so clients back off
+// instead of retrying right away.
+func writeParkError(ctx context.Context, w http.ResponseWriter, service *services.ParkingLotService, parkingLotID int, err error) {
+	var parkingErr *storage.ParkingError
+	if !errors.Is(err, storage.ErrLotFull) || !errors.As(err, &parkingErr) {
+		writeError(w, err)
+		return
+	}
+
+	retryAfter := int(service.RetryAfter(ctx, parkingLotID).Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(parkingErr.Status)
+	json.NewEncoder(w).Encode(struct {
+		*storage.ParkingError
+		RetryAfterSeconds int `json:"retryAfterSeconds"`
+	}{parkingErr, retryAfter})
+}
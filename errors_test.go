@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithRequestTimeoutStreamsExports(t *testing.T) {
+	tests := []struct {
+		path        string
+		wantFlusher bool
+	}{
+		{"/occupancy.csv", true},
+		{"/getTotalStats", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var flushed, deadline bool
+			handler := withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, deadline = r.Context().Deadline()
+				w.Write([]byte("slot\n"))
+				if flusher, ok := w.(http.Flusher); ok {
+					flusher.Flush()
+					flushed = true
+				}
+			}), time.Minute)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if flushed != tt.wantFlusher {
+				t.Errorf("response flushed = %v, want %v", flushed, tt.wantFlusher)
+			}
+			if !deadline {
+				t.Error("request has no deadline")
+			}
+			if rec.Body.String() != "slot\n" {
+				t.Errorf("body = %q, want the handler's", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithRequestTimeoutCancelsStreamedRoutes(t *testing.T) {
+	handler := withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("slot\n"))
+		<-r.Context().Done()
+	}), 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/occupancy.csv", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("streamed request not cancelled after its timeout")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxLoggedBody is how many bytes of a request or response body are logged.
+const maxLoggedBody = 2048
+
+// defaultMaxBody is the largest request body accepted unless MAX_BODY_BYTES says otherwise.
+const defaultMaxBody = 1 << 20
+
+// platePattern matches the JSON fields that carry a license plate, such as the
+// licensePlate of requests and tickets and the Vehicle of lot status entries.
+var platePattern = regexp.MustCompile(`(?i)("(?:licensePlate|license_plate|vehicle_license_plate|vehicle|plate)"\s*:\s*)"[^"]*"`)
+
+// redactPlates masks the license plates in a logged JSON body.
+func redactPlates(body string) string {
+	return platePattern.ReplaceAllString(body, `$1"***"`)
+}
+
+// maxBodySize limits request bodies to limit bytes. Reading beyond it fails, so handlers
+// reject the body as invalid, and middleware copying the body never sees more of it.
+func maxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and counts the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "...(truncated)"
+	}
+	return b.buf.String()
+}
+
+// loggingResponseWriter records the status and a copy of the body written by a handler.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   *limitedBuffer
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, so streamed responses are still sent as they are
+// written while bodies are logged.
+func (w *loggingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// bodyLogger logs the method, path, status, duration and truncated request and response
+// bodies of every request. The request body is copied as the handler reads it, so the
+// handler still sees all of it; it must run inside maxBodySize so that only bodies within
+// the limit are copied. License plates are masked when redact is set, and response
+// bodies other than JSON, such as CSV exports, are then left out of the log.
+func bodyLogger(redact bool) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestBody := &limitedBuffer{limit: maxLoggedBody}
+			if r.Body != nil {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, requestBody), r.Body}
+			}
+			lw := &loggingResponseWriter{ResponseWriter: w, body: &limitedBuffer{limit: maxLoggedBody}}
+
+			next.ServeHTTP(lw, r)
+
+			query, request, response := r.URL.RawQuery, requestBody.String(), lw.body.String()
+			if redact {
+				if r.URL.Query().Has("licensePlate") {
+					values := r.URL.Query()
+					values.Set("licensePlate", "***")
+					query = values.Encode()
+				}
+				request = redactPlates(request)
+				if contentType := lw.Header().Get("Content-Type"); contentType == "" || strings.HasPrefix(contentType, "application/json") {
+					response = redactPlates(response)
+				} else {
+					response = "(" + contentType + " body omitted)"
+				}
+			}
+			log.Printf("method=%s path=%s query=%q status=%d duration=%s request=%q response=%q",
+				r.Method, r.URL.Path, query, lw.status, time.Since(start), request, response)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactPlates(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"request", `{"parkingLotID": 6, "licensePlate": "DHA-1234"}`, `{"parkingLotID": 6, "licensePlate": "***"}`},
+		{"status entry", `{"ParkedVehicles":{"1":{"Vehicle":"DHA-1234","SlotNumber":1}}}`, `{"ParkedVehicles":{"1":{"Vehicle":"***","SlotNumber":1}}}`},
+		{"report column", `[{"license_plate":"DHA-1234"}]`, `[{"license_plate":"***"}]`},
+		{"no plate", `{"vehicles": 3, "vehicleType": "car"}`, `{"vehicles": 3, "vehicleType": "car"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactPlates(tt.body); got != tt.want {
+				t.Errorf("redactPlates(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodyLoggerRedactsAndLimitsBodies(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var handlerErr error
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			LicensePlate string `json:"licensePlate"`
+		}
+		handlerErr = json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "slot,license_plate\n1,"+request.LicensePlate+"\n")
+	})
+	server := maxBodySize(64)(bodyLogger(true)(handler))
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"within the limit", `{"licensePlate": "DHA-1234"}`, false},
+		{"over the limit", `{"licensePlate": "DHA-1234", "padding": "` + strings.Repeat("x", 64) + `"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged.Reset()
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/parkVehicle", strings.NewReader(tt.body)))
+
+			if (handlerErr != nil) != tt.wantErr {
+				t.Errorf("handler read error = %v, want error %v", handlerErr, tt.wantErr)
+			}
+			if strings.Contains(logged.String(), "DHA-1234") {
+				t.Errorf("plate logged: %s", logged.String())
+			}
+			if strings.Contains(logged.String(), strings.Repeat("x", 64)) {
+				t.Errorf("body beyond the limit logged: %s", logged.String())
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"parking_lot/services"
 	"parking_lot/storage"
@@ -15,11 +20,34 @@ import (
 func main() {
 
 	// Initialize storage n servicce
-	parkingLotStorage, err := storage.NewParkingLotStorage()
+	parkingLotStorage, err := storage.NewParkingLotStorage(envDuration("DB_PING_TIMEOUT", 5*time.Second))
 	if err != nil {
 		log.Fatal("Failed to initialize storage:", err)
 	}
+	parkingLotStorage.StartHoldSweeper(context.Background(), 30*time.Second)
+	if os.Getenv("AUTO_UNPARK_ENABLED") == "true" {
+		parkingLotStorage.StartAbandonedSweeper(context.Background(),
+			envDuration("AUTO_UNPARK_AFTER", storage.DefaultAutoUnparkAfter),
+			envDuration("AUTO_UNPARK_INTERVAL", time.Hour))
+	}
+	if os.Getenv("DAILY_SNAPSHOTS_ENABLED") == "true" {
+		parkingLotStorage.StartDailySnapshots(context.Background(),
+			envDuration("DAILY_SNAPSHOT_AT", 5*time.Minute),
+			envDuration("DAILY_SNAPSHOT_RETENTION", 0))
+	}
+	parkingLotStorage.SetAllowMultiLotPlates(os.Getenv("ALLOW_MULTI_LOT_PLATES") == "true")
+	parkingLotStorage.SetIdempotentUnpark(os.Getenv("IDEMPOTENT_UNPARK") == "true")
+	parkingLotStorage.SetStatementTimeouts(envDuration("READ_STATEMENT_TIMEOUT", 20*time.Second), envDuration("WRITE_STATEMENT_TIMEOUT", 5*time.Second))
+	parkingLotStorage.SetMaintenanceGrace(os.Getenv("MAINTENANCE_GRACE") == "true")
+	parkingLotStorage.SetPendingExitTimeout(envDuration("PENDING_EXIT_TIMEOUT", 0))
+	parkingLotStorage.SetBulkBatchSize(envInt("BULK_BATCH_SIZE", storage.DefaultBulkBatchSize))
+	parkingLotStorage.SetLostTicketRefundWindow(envDuration("LOST_TICKET_REFUND_WINDOW", storage.DefaultLostTicketRefundWindow))
 	parkingLotService := services.NewParkingLotService(parkingLotStorage)
+	parkingLotService.SetGateRequiresPayment(os.Getenv("GATE_REQUIRES_PAYMENT") != "false")
+
+	seedDefaultLot(parkingLotService)
+
+	adminKey := os.Getenv("ADMIN_API_KEY")
 
 	router := mux.NewRouter()
 
@@ -36,26 +64,215 @@ func main() {
 
 	router.HandleFunc("/getTotalStats", getTotalStatsHandler(parkingLotService)).Methods("GET")
 
+	router.HandleFunc("/getTotalStatsMulti", getTotalStatsMultiHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/gate/entry", gateEntryHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/gate/exit", gateExitHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/ticketFee", ticketFeeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/reportsByVehicleType", reportsByVehicleTypeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setAllocationStrategy", adminOnly(adminKey, setAllocationStrategyHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/occupancyHeatmap", occupancyHeatmapHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/orphanedSlots", adminOnly(adminKey, orphanedSlotsHandler(parkingLotService))).Methods("GET")
+
+	router.HandleFunc("/repairOrphanedSlots", adminOnly(adminKey, repairOrphanedSlotsHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/lotsWithAvailability", lotsWithAvailabilityHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/estimateWait", estimateWaitHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/importTransactions", adminOnly(adminKey, importTransactionsHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/revenueBySlot", revenueBySlotHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/maintenanceSlots", maintenanceSlotsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/breakEven", breakEvenHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/parkingLot/{id}", getParkingLotHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/clearAllMaintenance", adminOnly(adminKey, clearAllMaintenanceHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/parkingLot/{id}/config", lotConfigHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/parkedNow", parkedNowHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setFeeStrategy", adminOnly(adminKey, setFeeStrategyHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/lotsAboveOccupancy", lotsAboveOccupancyHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setNegativeFeePolicy", adminOnly(adminKey, setNegativeFeePolicyHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/lastTransaction", lastTransactionHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/turnover", turnoverHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/parkVehicleAt", adminOnly(adminKey, parkVehicleAtHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/slotsUsedByVehicle", slotsUsedByVehicleHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setOverflowLot", adminOnly(adminKey, setOverflowLotHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/kpis", kpisHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/reserveBlock", adminOnly(adminKey, reserveBlockHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/releaseBlock", adminOnly(adminKey, releaseBlockHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/setMaintenanceGrace", adminOnly(adminKey, setMaintenanceGraceHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/setTimezone", adminOnly(adminKey, setTimezoneHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/ticketLifecycle", ticketLifecycleHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setEntranceDistances", adminOnly(adminKey, setEntranceDistancesHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/setOperatingHours", adminOnly(adminKey, setOperatingHoursHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/quoteFee", quoteFeeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/organizations", adminOnly(adminKey, createOrganizationHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/parkingLots", listParkingLotsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/organizationReports", organizationReportsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/duplicateParked", adminOnly(adminKey, duplicateParkedHandler(parkingLotService))).Methods("GET")
+
+	router.HandleFunc("/repairDuplicateParked", adminOnly(adminKey, repairDuplicateParkedHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/occupancyAt", occupancyAtHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/confirmExit", confirmExitHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/averageFee", averageFeeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/updatePricingBulk", adminOnly(adminKey, updatePricingBulkHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/nearestFreeSlot", nearestFreeSlotHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/durationStatsByType", durationStatsByTypeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/transactions", transactionsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/addEmployeePlate", adminOnly(adminKey, addEmployeePlateHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/removeEmployeePlate", adminOnly(adminKey, removeEmployeePlateHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/employeePlates", employeePlatesHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/unusedSlots", unusedSlotsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/refundLostTicket", adminOnly(adminKey, refundLostTicketHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/maxDailyRevenue", maxDailyRevenueHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/currentStayDistribution", currentStayDistributionHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/slotUtilizationRank", slotUtilizationRankHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/checkIntegrity", adminOnly(adminKey, checkIntegrityHandler(parkingLotService))).Methods("GET")
+
+	router.HandleFunc("/revenueTrend", revenueTrendHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setSlotPriority", adminOnly(adminKey, setSlotPriorityHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/activeSessions", adminOnly(adminKey, activeSessionsHandler(parkingLotService))).Methods("GET")
+
+	router.HandleFunc("/validations", adminOnly(adminKey, createValidationHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/setSlotLayout", adminOnly(adminKey, setSlotLayoutHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/lotLayout", lotLayoutHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/rateHistory", rateHistoryHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/occupancyByFloor", occupancyByFloorHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/runRate", runRateHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/aboveAverageStays", aboveAverageStaysHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/occupancy.csv", occupancyCSVHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/reconcile", adminOnly(adminKey, reconcileHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/nextAvailableByType", nextAvailableByTypeHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/backfillDailyStats", adminOnly(adminKey, backfillDailyStatsHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/lotsWithFreeSlots", lotsWithFreeSlotsHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/isParked", isParkedHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/setCurrency", adminOnly(adminKey, setCurrencyHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/transactionNote", adminOnly(adminKey, transactionNoteHandler(parkingLotService))).Methods("POST")
+
+	router.HandleFunc("/slotStateHistory", slotStateHistoryHandler(parkingLotService)).Methods("GET")
+
+	router.HandleFunc("/holdSlot", holdSlotHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/confirmHold", confirmHoldHandler(parkingLotService)).Methods("POST")
+
+	router.HandleFunc("/releaseHold", releaseHoldHandler(parkingLotService)).Methods("POST")
+
+	router.Use(orgKeyScope(parkingLotService))
+
+	router.Use(maxBodySize(int64(envInt("MAX_BODY_BYTES", defaultMaxBody))))
+
+	router.Use(lotScope(parkingLotService.GetLotOrgID))
+
+	if os.Getenv("LOG_BODIES") == "true" {
+		router.Use(bodyLogger(os.Getenv("LOG_REDACT_PLATES") != "false"))
+	}
+
+	requestTimeout := envDuration("REQUEST_TIMEOUT", 30*time.Second)
+
 	fmt.Println("*************************************")
 	fmt.Println("Server is running on :8081...")
-	http.ListenAndServe(":8081", router)
+	http.ListenAndServe(":8081", withRequestTimeout(router, requestTimeout))
 }
 
 // Handler for creating a parking lot
 func createParkingLotHandler(service *services.ParkingLotService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
-			TotalSpaces int `json:"totalSpaces"`
+			TotalSpaces        int            `json:"totalSpaces"`
+			DefaultVehicleType string         `json:"defaultVehicleType"`
+			AllocationStrategy string         `json:"allocationStrategy"`
+			FeeStrategy        string         `json:"feeStrategy"`
+			NegativeFeePolicy  string         `json:"negativeFeePolicy"`
+			SlotTypes          map[string]int `json:"slotTypes"`
+			OrgID              int            `json:"orgID"`
 		}
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		orgID, err := scopedOrgID(r, request.OrgID)
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
-		parkingLot, err := service.CreateParkingLot(request.TotalSpaces)
+		parkingLot, err := service.CreateParkingLot(r.Context(), request.TotalSpaces, storage.LotOptions{
+			DefaultVehicleType: request.DefaultVehicleType,
+			AllocationStrategy: request.AllocationStrategy,
+			FeeStrategy:        request.FeeStrategy,
+			NegativeFeePolicy:  request.NegativeFeePolicy,
+			SlotTypes:          request.SlotTypes,
+			OrgID:              orgID,
+		})
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create parking lot: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
@@ -68,26 +285,43 @@ func createParkingLotHandler(service *services.ParkingLotService) http.HandlerFu
 func parkVehicleHandler(service *services.ParkingLotService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
-			ParkingLotID int    `json:"parkingLotID"`
-			LicensePlate string `json:"licensePlate"`
+			ParkingLotID   int    `json:"parkingLotID"`
+			LicensePlate   string `json:"licensePlate"`
+			VehicleType    string `json:"vehicleType"`
+			HoldToken      string `json:"holdToken"`
+			SlotsRequired  int    `json:"slotsRequired"`
+			PrepaidAmount  int    `json:"prepaidAmount"`
+			PrepaidMinutes int    `json:"prepaidMinutes"`
+			AllowOverflow  bool   `json:"allowOverflow"`
+			ReservationID  int    `json:"reservationID"`
+			EntranceID     string `json:"entranceID"`
+			EntryPhotoURL  string `json:"entryPhotoURL"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
 			return
 		}
 
-		slotNumber, err := service.ParkVehicle(request.ParkingLotID, request.LicensePlate)
+		ticket, err := service.ParkVehicle(r.Context(), request.ParkingLotID, request.LicensePlate, storage.ParkOptions{
+			VehicleType:     request.VehicleType,
+			HoldToken:       request.HoldToken,
+			SlotsRequired:   request.SlotsRequired,
+			PrepaidAmount:   request.PrepaidAmount,
+			PrepaidDuration: time.Duration(request.PrepaidMinutes) * time.Minute,
+			AllowOverflow:   request.AllowOverflow,
+			ReservationID:   request.ReservationID,
+			EntranceID:      request.EntranceID,
+			EntryPhotoURL:   request.EntryPhotoURL,
+		})
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to park vehicle: %v", err), http.StatusInternalServerError)
+			writeParkError(r.Context(), w, service, request.ParkingLotID, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(struct {
-			SlotNumber int `json:"slotNumber"`
-		}{SlotNumber: slotNumber})
+		json.NewEncoder(w).Encode(ticket)
 	}
 }
 
@@ -95,26 +329,25 @@ func parkVehicleHandler(service *services.ParkingLotService) http.HandlerFunc {
 func unparkVehicleHandler(service *services.ParkingLotService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
-			ParkingLotID int    `json:"parkingLotID"`
-			LicensePlate string `json:"licensePlate"`
+			ParkingLotID   int    `json:"parkingLotID"`
+			LicensePlate   string `json:"licensePlate"`
+			ValidationCode string `json:"validationCode"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
 			return
 		}
 
-		fee, err := service.UnparkVehicle(request.ParkingLotID, request.LicensePlate)
+		fee, err := service.UnparkVehicle(r.Context(), request.ParkingLotID, request.LicensePlate, request.ValidationCode)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to unpark vehicle: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(struct {
-			Fee int `json:"fee"`
-		}{Fee: fee})
+		json.NewEncoder(w).Encode(fee)
 	}
 }
 
@@ -127,16 +360,29 @@ func viewParkingLotStatusHandler(service *services.ParkingLotService) http.Handl
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		// Polling clients send back the ETag of the status they have, which is only
+		// rebuilt when the lot has changed since.
+		version, err := service.GetLotVersion(r.Context(), request.ParkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if r.Header.Get("If-None-Match") == lotStatusETag(request.ParkingLotID, version) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
-		status, err := service.ViewParkingLotStatus(request.ParkingLotID)
+		status, err := service.ViewParkingLotStatus(r.Context(), request.ParkingLotID)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get parking lot status: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
+		w.Header().Set("ETag", lotStatusETag(request.ParkingLotID, status.Version))
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(status)
 	}
@@ -146,27 +392,40 @@ func viewParkingLotStatusHandler(service *services.ParkingLotService) http.Handl
 func toggleMaintenanceHandler(service *services.ParkingLotService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
-			ParkingLotID  int  `json:"parkingLotID"`
-			SlotNumber    int  `json:"slotNumber"`
-			InMaintenance bool `json:"inMaintenance"`
+			ParkingLotID  int       `json:"parkingLotID"`
+			SlotNumber    int       `json:"slotNumber"`
+			InMaintenance bool      `json:"inMaintenance"`
+			Mode          string    `json:"mode"`
+			Reason        string    `json:"reason"`
+			ScheduledEnd  time.Time `json:"scheduledEnd"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
 			return
 		}
 
-		err = service.ToggleMaintenance(request.ParkingLotID, request.SlotNumber, request.InMaintenance)
+		changed, err := service.ToggleMaintenance(r.Context(), request.ParkingLotID, request.SlotNumber, request.InMaintenance, storage.MaintenanceDetails{
+			Mode:         request.Mode,
+			Reason:       request.Reason,
+			ScheduledEnd: request.ScheduledEnd,
+		})
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to toggle maintenance mode: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
+		message := "Maintenance mode toggled successfully"
+		if !changed {
+			message = "Maintenance mode already in requested state"
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(struct {
 			Message string `json:"message"`
-		}{Message: "Maintenance mode toggled successfully"})
+			Changed bool   `json:"changed"`
+		}{Message: message, Changed: changed})
 	}
 }
 
@@ -174,18 +433,228 @@ func toggleMaintenanceHandler(service *services.ParkingLotService) http.HandlerF
 func getTotalStatsHandler(service *services.ParkingLotService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
-			ParkingLotID int `json:"parkingLotID"`
+			ParkingLotID  int  `json:"parkingLotID"`
+			IncludeVoided bool `json:"includeVoided"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		stats, err := service.GetReports(r.Context(), request.ParkingLotID, request.IncludeVoided)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// For getting total statistics of several lots at once
+func getTotalStatsMultiHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotIDs []int     `json:"parkingLotIDs"`
+			From          time.Time `json:"from"`
+			To            time.Time `json:"to"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, errInvalidBody)
 			return
 		}
 
-		stats, err := service.GetReports(request.ParkingLotID)
+		reports, err := service.GetReportsForLots(r.Context(), request.ParkingLotIDs, request.From, request.To)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get total statistics: %v", err), http.StatusInternalServerError)
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
+
+// For holding a slot while the driver pays
+func holdSlotHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			VehicleType  string `json:"vehicleType"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		hold, err := service.HoldSlot(r.Context(), request.ParkingLotID, request.VehicleType)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hold)
+	}
+}
+
+// For confirming a held slot once payment is done
+func confirmHoldHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Token string `json:"token"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.ConfirmHold(r.Context(), request.Token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Hold confirmed successfully"})
+	}
+}
+
+// For releasing a held slot
+func releaseHoldHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Token string `json:"token"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.ReleaseHold(r.Context(), request.Token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Hold released successfully"})
+	}
+}
+
+// For a vehicle scanned at an entry gate
+func gateEntryHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID  int    `json:"parkingLotID"`
+			LicensePlate  string `json:"licensePlate"`
+			VehicleType   string `json:"vehicleType"`
+			EntryPhotoURL string `json:"entryPhotoURL"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		result, err := service.GateEntry(r.Context(), request.ParkingLotID, request.LicensePlate, storage.ParkOptions{
+			VehicleType:   request.VehicleType,
+			EntryPhotoURL: request.EntryPhotoURL,
+		})
+		if err != nil {
+			writeParkError(r.Context(), w, service, request.ParkingLotID, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// For a vehicle scanned at an exit gate
+func gateExitHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			LicensePlate string `json:"licensePlate"`
+			AmountPaid   int    `json:"amountPaid"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		result, err := service.GateExit(r.Context(), request.ParkingLotID, request.LicensePlate, request.AmountPaid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// For quoting the fee of an active ticket
+func ticketFeeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ticketID, err := queryString(r, "ticketID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ticketFee, err := service.GetTicketFee(r.Context(), ticketID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ticketFee)
+	}
+}
+
+// For getting daily statistics per vehicle type
+func reportsByVehicleTypeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		stats, err := service.GetReportsByVehicleType(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
@@ -193,3 +662,1809 @@ func getTotalStatsHandler(service *services.ParkingLotService) http.HandlerFunc
 		json.NewEncoder(w).Encode(stats)
 	}
 }
+
+// For choosing how a lot assigns slots
+func setAllocationStrategyHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID       int    `json:"parkingLotID"`
+			AllocationStrategy string `json:"allocationStrategy"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetAllocationStrategy(r.Context(), request.ParkingLotID, request.AllocationStrategy)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Allocation strategy updated successfully"})
+	}
+}
+
+// For getting the weekday by hour occupancy heatmap of a lot
+func occupancyHeatmapHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		heatmap, err := service.GetOccupancyHeatmap(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(heatmap)
+	}
+}
+
+// For finding slots marked occupied without a parked vehicle
+func orphanedSlotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		orphanedSlots, err := service.FindOrphanedSlots(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orphanedSlots)
+	}
+}
+
+// For freeing slots marked occupied without a parked vehicle
+func repairOrphanedSlotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int `json:"parkingLotID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		freed, err := service.RepairOrphanedSlots(r.Context(), request.ParkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			FreedSlots []int `json:"freedSlots"`
+		}{FreedSlots: freed})
+	}
+}
+
+// For finding lots with a free slot for a vehicle type
+func lotsWithAvailabilityHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := queryOptionalInt(r, "limit")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		lots, err := service.FindLotsWithAvailability(r.Context(), r.URL.Query().Get("vehicleType"), limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
+
+// For estimating how long until a slot frees up
+func estimateWaitHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		estimate, err := service.EstimateWaitTime(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(estimate)
+	}
+}
+
+// For backfilling historical transactions
+func importTransactionsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int                         `json:"parkingLotID"`
+			Records      []storage.TransactionRecord `json:"records"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		result, err := service.ImportTransactions(r.Context(), request.ParkingLotID, request.Records)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// For getting revenue per slot
+func revenueBySlotHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		revenues, err := service.GetRevenueBySlot(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revenues)
+	}
+}
+
+// For listing the slots currently out of service
+func maintenanceSlotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		slots, err := service.GetMaintenanceSlots(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(slots)
+	}
+}
+
+// For estimating the occupancy needed to cover operating cost
+func breakEvenHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		dailyCost, err := queryInt(r, "dailyCost")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		breakEven, err := service.GetBreakEvenOccupancy(r.Context(), parkingLotID, dailyCost)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(breakEven)
+	}
+}
+
+// For getting a lot with its spaces and slot type breakdown
+func getParkingLotHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := pathInt(r, "id")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		parkingLot, err := service.GetParkingLot(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(parkingLot)
+	}
+}
+
+// For taking every slot of a lot out of maintenance
+func clearAllMaintenanceHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int `json:"parkingLotID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		cleared, err := service.ClearAllMaintenance(r.Context(), request.ParkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Cleared int `json:"cleared"`
+		}{Cleared: cleared})
+	}
+}
+
+// For reading back a lot's configuration
+func lotConfigHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := pathInt(r, "id")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		config, err := service.GetLotConfig(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+	}
+}
+
+// For counting the vehicles parked right now across all lots
+func parkedNowHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkedNow, err := service.GetTotalParkedNow(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(parkedNow)
+	}
+}
+
+// For changing how fees are computed in a lot
+func setFeeStrategyHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			FeeStrategy  string `json:"feeStrategy"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetFeeStrategy(r.Context(), request.ParkingLotID, request.FeeStrategy)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Fee strategy updated successfully"})
+	}
+}
+
+// For finding lots nearing capacity
+func lotsAboveOccupancyHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		threshold, err := queryFloat(r, "threshold")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		lots, err := service.GetLotsAboveOccupancy(r.Context(), threshold)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
+
+// For choosing whether negative fees are clamped to zero or kept as a credit
+func setNegativeFeePolicyHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID      int    `json:"parkingLotID"`
+			NegativeFeePolicy string `json:"negativeFeePolicy"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetNegativeFeePolicy(r.Context(), request.ParkingLotID, request.NegativeFeePolicy)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Negative fee policy updated successfully"})
+	}
+}
+
+// For looking up the last stay a vehicle was charged for
+func lastTransactionHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		licensePlate, err := queryString(r, "licensePlate")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		transaction, err := service.GetLastTransaction(r.Context(), licensePlate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transaction)
+	}
+}
+
+// For getting the vehicles served per slot per day
+func turnoverHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		turnover, err := service.GetTurnoverRate(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(turnover)
+	}
+}
+
+// For parking a vehicle with a given entry time
+func parkVehicleAtHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID  int       `json:"parkingLotID"`
+			LicensePlate  string    `json:"licensePlate"`
+			EntryTime     time.Time `json:"entryTime"`
+			VehicleType   string    `json:"vehicleType"`
+			SlotsRequired int       `json:"slotsRequired"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		ticket, err := service.ParkVehicleAt(r.Context(), request.ParkingLotID, request.LicensePlate, request.EntryTime, storage.ParkOptions{
+			VehicleType:   request.VehicleType,
+			SlotsRequired: request.SlotsRequired,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ticket)
+	}
+}
+
+// For listing the slots a vehicle has used
+func slotsUsedByVehicleHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		licensePlate, err := queryString(r, "licensePlate")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		usages, err := service.GetSlotsUsedByVehicle(r.Context(), licensePlate, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usages)
+	}
+}
+
+// For setting the lot vehicles are redirected to when a lot is full
+func setOverflowLotHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID  int `json:"parkingLotID"`
+			OverflowLotID int `json:"overflowLotID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetOverflowLot(r.Context(), request.ParkingLotID, request.OverflowLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Overflow lot updated successfully"})
+	}
+}
+
+// For getting today's headline figures across all lots
+func kpisHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kpis, err := service.GetKPIs(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kpis)
+	}
+}
+
+// For reserving a block of slots for an event
+func reserveBlockHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int       `json:"parkingLotID"`
+			Count        int       `json:"count"`
+			From         time.Time `json:"from"`
+			To           time.Time `json:"to"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		reservation, err := service.ReserveBlock(r.Context(), request.ParkingLotID, request.Count, request.From, request.To)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reservation)
+	}
+}
+
+// For giving a reserved block back to walk-ins
+func releaseBlockHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ReservationID int `json:"reservationID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.ReleaseBlock(r.Context(), request.ReservationID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Reservation released successfully"})
+	}
+}
+
+// For choosing whether a lot charges for time spent in maintenance
+func setMaintenanceGraceHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID     int  `json:"parkingLotID"`
+			MaintenanceGrace bool `json:"maintenanceGrace"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetLotMaintenanceGrace(r.Context(), request.ParkingLotID, request.MaintenanceGrace)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Maintenance grace updated successfully"})
+	}
+}
+
+// For setting the timezone times about a lot are given in
+func setTimezoneHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			Timezone     string `json:"timezone"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetLotTimezone(r.Context(), request.ParkingLotID, request.Timezone)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Timezone updated successfully"})
+	}
+}
+
+// For getting the timeline of a ticket
+func ticketLifecycleHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ticketID, err := queryString(r, "ticketID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		lifecycle, err := service.GetTicketLifecycle(r.Context(), ticketID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lifecycle)
+	}
+}
+
+// For recording how far each slot is from an entrance
+func setEntranceDistancesHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int         `json:"parkingLotID"`
+			EntranceID   string      `json:"entranceID"`
+			Distances    map[int]int `json:"distances"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetEntranceDistances(r.Context(), request.ParkingLotID, request.EntranceID, request.Distances)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Entrance distances updated successfully"})
+	}
+}
+
+// For setting when a lot admits vehicles
+func setOperatingHoursHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID   int                     `json:"parkingLotID"`
+			OperatingHours *storage.OperatingHours `json:"operatingHours"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetOperatingHours(r.Context(), request.ParkingLotID, request.OperatingHours)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Operating hours updated successfully"})
+	}
+}
+
+// For quoting the fee of a stay of a given length
+func quoteFeeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		minutes, err := queryInt(r, "minutes")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		fee, err := service.QuoteFee(r.Context(), parkingLotID, minutes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fee)
+	}
+}
+
+// For creating an organization and its API key
+func createOrganizationHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Name string `json:"name"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		org, err := service.CreateOrganization(r.Context(), request.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(org)
+	}
+}
+
+// For listing parking lots, optionally of one organization
+func listParkingLotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := queryOptionalInt(r, "orgID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		orgID, err = scopedOrgID(r, orgID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		lots, err := service.ListParkingLots(r.Context(), orgID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
+
+// For getting the daily statistics of every lot of an organization
+func organizationReportsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := queryOptionalInt(r, "orgID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		orgID, err = scopedOrgID(r, orgID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if orgID == 0 {
+			writeError(w, invalidQuery("orgID"))
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		reports, err := service.GetReportsForOrganization(r.Context(), orgID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
+
+// For finding plates with more than one active parked row
+func duplicateParkedHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		duplicates, err := service.FindDuplicateParkedVehicles(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(duplicates)
+	}
+}
+
+// For closing all but the earliest active parked row of each plate
+func repairDuplicateParkedHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int `json:"parkingLotID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		repair, err := service.RepairDuplicateParkedVehicles(r.Context(), request.ParkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(repair)
+	}
+}
+
+// For getting how many vehicles were parked at a past time
+func occupancyAtHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		at, err := queryTime(r, "at")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if at.IsZero() {
+			writeError(w, invalidQuery("at"))
+			return
+		}
+
+		occupancy, err := service.GetOccupancyAt(r.Context(), parkingLotID, at)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(occupancy)
+	}
+}
+
+// For freeing the slot of a vehicle once it has paid and left
+func confirmExitHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			TicketID string `json:"ticketID"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		fee, err := service.ConfirmExit(r.Context(), request.TicketID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fee)
+	}
+}
+
+// For getting the mean and median ticket price over a period
+func averageFeeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		average, err := service.GetAverageFee(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(average)
+	}
+}
+
+// For applying the same pricing to several lots at once
+func updatePricingBulkHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotIDs []int                 `json:"parkingLotIDs"`
+			Pricing       storage.PricingConfig `json:"pricing"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		result, err := service.UpdatePricingBulk(r.Context(), request.ParkingLotIDs, request.Pricing)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// For showing the next slot a vehicle would get without reserving it
+func nearestFreeSlotHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		slotNumber, err := service.PeekNearestFreeSlot(r.Context(), parkingLotID, r.URL.Query().Get("vehicleType"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			SlotNumber int  `json:"slotNumber,omitempty"`
+			Full       bool `json:"full"`
+		}{SlotNumber: slotNumber, Full: slotNumber == 0})
+	}
+}
+
+// For getting how long each vehicle type stays
+func durationStatsByTypeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		stats, err := service.GetDurationStatsByType(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// For listing completed transactions by lot, plate, period and fee
+func transactionsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryOptionalInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		minFee, err := queryOptionalIntPtr(r, "minFee")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		maxFee, err := queryOptionalIntPtr(r, "maxFee")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		limit, err := queryOptionalInt(r, "limit")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		offset, err := queryOptionalInt(r, "offset")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		transactions, err := service.ListTransactions(r.Context(), storage.TransactionFilter{
+			ParkingLotID: parkingLotID,
+			LicensePlate: r.URL.Query().Get("licensePlate"),
+			From:         from,
+			To:           to,
+			MinFee:       minFee,
+			MaxFee:       maxFee,
+			Limit:        limit,
+			Offset:       offset,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transactions)
+	}
+}
+
+// For letting an employee vehicle park free
+func addEmployeePlateHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			LicensePlate string `json:"licensePlate"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.AddEmployeePlate(r.Context(), request.ParkingLotID, request.LicensePlate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Employee plate added successfully"})
+	}
+}
+
+// For charging a former employee vehicle again
+func removeEmployeePlateHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			LicensePlate string `json:"licensePlate"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.RemoveEmployeePlate(r.Context(), request.ParkingLotID, request.LicensePlate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Employee plate removed successfully"})
+	}
+}
+
+// For listing the plates that park free in a lot
+func employeePlatesHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		plates, err := service.GetEmployeePlates(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plates)
+	}
+}
+
+// For finding slots nobody has parked in lately
+func unusedSlotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		since, err := queryTime(r, "since")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if since.IsZero() {
+			writeError(w, invalidQuery("since"))
+			return
+		}
+
+		slots, err := service.GetUnusedSlots(r.Context(), parkingLotID, since)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(slots)
+	}
+}
+
+// For refunding a lost-ticket fee once the ticket is found
+func refundLostTicketHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			TransactionID     int       `json:"transactionID"`
+			OriginalEntryTime time.Time `json:"originalEntryTime"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		refund, err := service.RefundLostTicketDifference(r.Context(), request.TransactionID, request.OriginalEntryTime)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(refund)
+	}
+}
+
+// For getting the most a lot could earn in a day
+func maxDailyRevenueHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		revenue, err := service.GetMaxDailyRevenue(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revenue)
+	}
+}
+
+// For getting how long the vehicles parked now have stayed
+func currentStayDistributionHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		buckets, err := service.GetCurrentStayDistribution(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buckets)
+	}
+}
+
+// For ranking the slots of a lot by how long they were occupied
+func slotUtilizationRankHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ranked, err := service.GetSlotUtilizationRank(r.Context(), parkingLotID, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ranked)
+	}
+}
+
+// For auditing the consistency of a lot's spaces and parked vehicles
+func checkIntegrityHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		report, err := service.CheckLotIntegrity(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// For comparing a lot's revenue this period with the previous one
+func revenueTrendHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		period, err := queryString(r, "period")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		trend, err := service.GetRevenueTrend(r.Context(), parkingLotID, period)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(trend)
+	}
+}
+
+// For choosing whether block reservations or holds win a contested slot
+func setSlotPriorityHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			SlotPriority string `json:"slotPriority"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetSlotPriority(r.Context(), request.ParkingLotID, request.SlotPriority)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Slot priority updated successfully"})
+	}
+}
+
+// For listing the vehicles parked across all lots
+func activeSessionsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := queryOptionalInt(r, "limit")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		offset, err := queryOptionalInt(r, "offset")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		page, err := service.GetAllActiveSessions(r.Context(), limit, offset)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// lotStatusETag identifies the status of a lot at the given version.
+func lotStatusETag(parkingLotID int, version int64) string {
+	return fmt.Sprintf(`"%d-%d"`, parkingLotID, version)
+}
+
+// For registering a merchant validation that pays for part of a stay
+func createValidationHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request storage.Validation
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		validation, err := service.CreateValidation(r.Context(), request)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(validation)
+	}
+}
+
+// For placing slots on floors and zones of a lot's map
+func setSlotLayoutHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int                     `json:"parkingLotID"`
+			Slots        []storage.SlotPlacement `json:"slots"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetSlotLayout(r.Context(), request.ParkingLotID, request.Slots)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Slot layout updated successfully"})
+	}
+}
+
+// For getting a lot's slots by floor and zone, for maps and signage
+func lotLayoutHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		layout, err := service.GetLotLayout(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(layout)
+	}
+}
+
+// For auditing the fee strategy changes of a lot
+func rateHistoryHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		history, err := service.GetRateHistory(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// For getting the occupied and free slots of each floor of a lot
+func occupancyByFloorHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		floors, err := service.GetOccupancyByFloor(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(floors)
+	}
+}
+
+// For getting what the vehicles parked in a lot earn per hour right now
+func runRateHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		runRate, err := service.GetRevenueRunRate(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runRate)
+	}
+}
+
+// For getting the vehicles parked longer than the lot's average stay
+func aboveAverageStaysHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		stays, err := service.GetAboveAverageStays(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stays)
+	}
+}
+
+// For exporting the vehicles parked in a lot now as CSV
+func occupancyCSVHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// The header row is only written once the lot is known to exist, so that a
+		// missing lot is still reported as an error rather than an empty export.
+		out := csv.NewWriter(w)
+		started := false
+		start := func() error {
+			if started {
+				return nil
+			}
+			started = true
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"occupancy-%d.csv\"", parkingLotID))
+			return out.Write([]string{"slot", "license_plate", "entry_time", "duration_minutes", "accrued_fee"})
+		}
+
+		err = service.ExportActiveSessions(r.Context(), parkingLotID, func(session *storage.ActiveSession) error {
+			if err := start(); err != nil {
+				return err
+			}
+			return out.Write([]string{
+				strconv.Itoa(session.SlotNumber),
+				session.LicensePlate,
+				session.EntryTime.Format(time.RFC3339),
+				strconv.Itoa(session.StayMinutes),
+				strconv.Itoa(session.AccruedFee),
+			})
+		})
+		if err == nil {
+			err = start()
+		}
+		if err != nil {
+			if !started {
+				writeError(w, err)
+				return
+			}
+			log.Println("failed to export occupancy:", err)
+		}
+		out.Flush()
+	}
+}
+
+// For reconciling a lot's occupancy against its sensor count
+func reconcileHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID        int  `json:"parkingLotID"`
+			SensorOccupiedCount int  `json:"sensorOccupiedCount"`
+			Fix                 bool `json:"fix"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		reconciliation, err := service.Reconcile(r.Context(), request.ParkingLotID, request.SensorOccupiedCount, request.Fix)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reconciliation)
+	}
+}
+
+// For showing the next slot each vehicle type would get
+func nextAvailableByTypeHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		next, err := service.GetNextAvailableByType(r.Context(), parkingLotID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(next)
+	}
+}
+
+// For snapshotting the daily report totals of past days
+func backfillDailyStatsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			From time.Time `json:"from"`
+			To   time.Time `json:"to"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		days, err := service.BackfillDailyStats(r.Context(), request.From, request.To)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Days int `json:"days"`
+		}{Days: days})
+	}
+}
+
+// For finding lots with at least a number of free slots
+func lotsWithFreeSlotsHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minFree, err := queryInt(r, "min")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		limit, err := queryOptionalInt(r, "limit")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		lots, err := service.FindLotsWithFreeSlots(r.Context(), minFree, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
+
+// For checking whether and where a plate is parked
+func isParkedHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := service.IsVehicleParked(r.Context(), r.URL.Query().Get("licensePlate"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// For setting the currency a lot charges in
+func setCurrencyHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ParkingLotID int    `json:"parkingLotID"`
+			Currency     string `json:"currency"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		err = service.SetLotCurrency(r.Context(), request.ParkingLotID, request.Currency)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Message string `json:"message"`
+		}{Message: "Currency updated successfully"})
+	}
+}
+
+// For adding an operator note to a transaction
+func transactionNoteHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			TransactionID int    `json:"transactionID"`
+			Note          string `json:"note"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, errInvalidBody)
+			return
+		}
+
+		note, err := service.AddTransactionNote(r.Context(), request.TransactionID, request.Note)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(note)
+	}
+}
+
+// For debugging how a slot went between free, occupied and maintenance
+func slotStateHistoryHandler(service *services.ParkingLotService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parkingLotID, err := queryInt(r, "parkingLotID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		slotNumber, err := queryInt(r, "slotNumber")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		from, err := queryTime(r, "from")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		to, err := queryTime(r, "to")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		history, err := service.GetSlotStateHistory(r.Context(), parkingLotID, slotNumber, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
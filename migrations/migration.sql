@@ -37,4 +37,251 @@ CREATE TABLE parking_transactions (
 );
 
 
+ALTER TABLE parking_spaces ADD COLUMN vehicle_type VARCHAR(20) NOT NULL DEFAULT 'car';
+
+CREATE TABLE slot_holds (
+    token VARCHAR(64) PRIMARY KEY,
+    lot_id INT NOT NULL,
+    slot INT NOT NULL,
+    confirmed BOOLEAN DEFAULT false,
+    released BOOLEAN DEFAULT false,
+    expires_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_slot_holds_lot_id FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+);
+
+CREATE INDEX idx_slot_holds_lot_id_slot ON slot_holds (lot_id, slot);
+
+ALTER TABLE parked_vehicles ADD COLUMN slots_required INT NOT NULL DEFAULT 1;
+ALTER TABLE parked_vehicles ADD COLUMN exit_time TIMESTAMP;
+
+-- Close parked vehicle rows left behind before exit_time was tracked, keeping only the
+-- latest row for each occupied slot open.
+UPDATE parked_vehicles SET exit_time = NOW()
+WHERE id NOT IN (
+    SELECT MAX(parked_vehicles.id)
+    FROM parked_vehicles
+    JOIN parking_spaces ON parking_spaces.lot_id = parked_vehicles.parking_lot_id AND parking_spaces.number = parked_vehicles.slot
+    WHERE parking_spaces.occupied
+    GROUP BY parked_vehicles.parking_lot_id, parked_vehicles.slot
+);
+
+CREATE TABLE maintenance_windows (
+    id SERIAL PRIMARY KEY,
+    lot_id INT NOT NULL,
+    slot INT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    CONSTRAINT fk_maintenance_windows_lot_id FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+);
+
+CREATE INDEX idx_maintenance_windows_lot_id_slot ON maintenance_windows (lot_id, slot);
+
+ALTER TABLE parked_vehicles ADD COLUMN ticket_id VARCHAR(64) UNIQUE;
+ALTER TABLE parking_transactions ADD COLUMN ticket_id VARCHAR(64);
+
+ALTER TABLE parking_lots ADD COLUMN default_vehicle_type VARCHAR(20) NOT NULL DEFAULT 'car';
+ALTER TABLE parked_vehicles ADD COLUMN vehicle_type VARCHAR(20) NOT NULL DEFAULT 'car';
+ALTER TABLE parking_transactions ADD COLUMN vehicle_type VARCHAR(20) NOT NULL DEFAULT 'car';
+
+ALTER TABLE parking_lots ADD COLUMN allocation_strategy VARCHAR(20) NOT NULL DEFAULT 'nearest';
+ALTER TABLE parking_lots ADD COLUMN last_assigned_slot INT NOT NULL DEFAULT 0;
+
+ALTER TABLE parking_transactions ADD COLUMN slot INT;
+
+-- Backfill the slot of transactions recorded before it was stored, from the parked vehicle
+-- row of the same stay. Transactions without a matching row keep a NULL slot.
+UPDATE parking_transactions SET slot = (
+    SELECT parked_vehicles.slot
+    FROM parked_vehicles
+    WHERE parked_vehicles.parking_lot_id = parking_transactions.lot_id
+    AND parked_vehicles.license_plate = parking_transactions.vehicle_license_plate
+    AND parked_vehicles.entry_time BETWEEN parking_transactions.entry_time - INTERVAL '1 minute' AND parking_transactions.entry_time + INTERVAL '1 minute'
+    ORDER BY ABS(EXTRACT(EPOCH FROM (parked_vehicles.entry_time - parking_transactions.entry_time)))
+    LIMIT 1
+)
+WHERE slot IS NULL;
+
+CREATE INDEX idx_parking_transactions_lot_id_slot ON parking_transactions (lot_id, slot);
+
+ALTER TABLE maintenance_windows ADD COLUMN reason VARCHAR(255);
+ALTER TABLE maintenance_windows ADD COLUMN scheduled_end TIMESTAMP;
+
+ALTER TABLE parked_vehicles ADD COLUMN prepaid_amount INT NOT NULL DEFAULT 0;
+ALTER TABLE parked_vehicles ADD COLUMN prepaid_until TIMESTAMP;
+ALTER TABLE parking_transactions ADD COLUMN prepaid_amount INT NOT NULL DEFAULT 0;
+ALTER TABLE parking_transactions ADD COLUMN refund INT NOT NULL DEFAULT 0;
+
+ALTER TABLE parking_lots ADD COLUMN fee_strategy VARCHAR(20) NOT NULL DEFAULT 'flat';
+
+ALTER TABLE parking_lots ADD COLUMN negative_fee_policy VARCHAR(20) NOT NULL DEFAULT 'clamp';
+ALTER TABLE parking_transactions ADD COLUMN fee_before_clamp INT;
+
+ALTER TABLE parking_spaces ADD COLUMN maintenance_mode VARCHAR(20);
+UPDATE parking_spaces SET maintenance_mode = 'out-of-service' WHERE in_maintenance;
+ALTER TABLE maintenance_windows ADD COLUMN mode VARCHAR(20) NOT NULL DEFAULT 'out-of-service';
+
+ALTER TABLE parking_lots ADD COLUMN overflow_lot_id INT REFERENCES parking_lots(id);
+
+CREATE TABLE block_reservations (
+    id SERIAL PRIMARY KEY,
+    lot_id INT NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    released BOOLEAN NOT NULL DEFAULT false,
+    CONSTRAINT fk_block_reservations_lot_id FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+);
+
+CREATE TABLE block_reservation_slots (
+    reservation_id INT NOT NULL,
+    slot INT NOT NULL,
+    PRIMARY KEY (reservation_id, slot),
+    CONSTRAINT fk_block_reservation_slots_reservation_id FOREIGN KEY (reservation_id) REFERENCES block_reservations(id)
+);
+
+-- NULL falls back to the server-wide MAINTENANCE_GRACE setting.
+ALTER TABLE parking_lots ADD COLUMN maintenance_grace BOOLEAN;
+
+ALTER TABLE slot_holds ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE parked_vehicles ADD COLUMN hold_token VARCHAR(64);
+
+CREATE TABLE slot_entrance_distances (
+    lot_id INT NOT NULL,
+    entrance_id VARCHAR(50) NOT NULL,
+    slot INT NOT NULL,
+    distance INT NOT NULL,
+    PRIMARY KEY (lot_id, entrance_id, slot),
+    CONSTRAINT fk_slot_entrance_distances_lot_id FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+);
+
+-- Operating hours are minutes after midnight UTC; NULL means the lot never closes.
+ALTER TABLE parking_lots ADD COLUMN opens_at_minute INT;
+ALTER TABLE parking_lots ADD COLUMN closes_at_minute INT;
+ALTER TABLE parking_lots ADD COLUMN last_call_minutes INT NOT NULL DEFAULT 0;
+
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    api_key TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE parking_lots ADD COLUMN org_id INT REFERENCES organizations(id);
+
+-- Set when a transaction is voided; voided transactions are left out of reports by default.
+ALTER TABLE parking_transactions ADD COLUMN voided_at TIMESTAMP;
+
+-- Set when a vehicle is unparked in pay-then-exit mode; its slot stays occupied until the exit is confirmed.
+ALTER TABLE parked_vehicles ADD COLUMN pending_exit_at TIMESTAMP;
+
+ALTER TABLE parked_vehicles ADD COLUMN entry_photo_url TEXT;
+ALTER TABLE parking_transactions ADD COLUMN entry_photo_url TEXT;
+
+CREATE TABLE employee_plates (
+    lot_id INT NOT NULL REFERENCES parking_lots(id),
+    license_plate VARCHAR(20) NOT NULL,
+    added_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (lot_id, license_plate)
+);
+
+ALTER TABLE parking_transactions ADD COLUMN employee BOOLEAN NOT NULL DEFAULT false;
+
+-- Set when the overcharge of a lost-ticket fee is refunded; fee then holds the corrected fee.
+ALTER TABLE parking_transactions ADD COLUMN lost_ticket_fee INT;
+ALTER TABLE parking_transactions ADD COLUMN lost_ticket_refund INT;
+ALTER TABLE parking_transactions ADD COLUMN original_entry_time TIMESTAMP;
+
+-- History of each lot's fee strategy, so stays spanning a change are charged the rate in effect each hour.
+CREATE TABLE fee_strategy_changes (
+    id SERIAL PRIMARY KEY,
+    lot_id INT NOT NULL REFERENCES parking_lots(id),
+    fee_strategy VARCHAR(20) NOT NULL,
+    effective_from TIMESTAMP NOT NULL
+);
+
+CREATE INDEX fee_strategy_changes_lot_idx ON fee_strategy_changes (lot_id, effective_from);
+
+INSERT INTO fee_strategy_changes (lot_id, fee_strategy, effective_from)
+SELECT id, fee_strategy, 'epoch' FROM parking_lots;
+
+-- Set on transactions closed by the abandoned vehicle job rather than by the vehicle leaving.
+ALTER TABLE parking_transactions ADD COLUMN abandoned BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE parking_lots ADD COLUMN slot_priority VARCHAR(20) NOT NULL DEFAULT 'reservations-first';
+
+-- Bumped whenever vehicles park or leave or slots change maintenance, so status polls can be answered with 304.
+ALTER TABLE parking_lots ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+-- Merchant validations covering part of a stay, either its first hours or a fixed amount.
+CREATE TABLE parking_validations (
+    code VARCHAR(64) PRIMARY KEY,
+    lot_id INT NOT NULL REFERENCES parking_lots(id),
+    merchant VARCHAR(100) NOT NULL,
+    hours INT,
+    amount INT,
+    created_at TIMESTAMP NOT NULL,
+    CHECK ((hours IS NULL) <> (amount IS NULL))
+);
+
+ALTER TABLE parked_vehicles ADD COLUMN validation_code VARCHAR(64);
+ALTER TABLE parking_transactions ADD COLUMN validation_code VARCHAR(64);
+ALTER TABLE parking_transactions ADD COLUMN validated_amount INT NOT NULL DEFAULT 0;
+
+-- Where each slot is, for printed maps and signage.
+ALTER TABLE parking_spaces ADD COLUMN floor VARCHAR(20);
+ALTER TABLE parking_spaces ADD COLUMN zone VARCHAR(20);
+ALTER TABLE parking_spaces ADD COLUMN label VARCHAR(20);
+ALTER TABLE parking_spaces ADD COLUMN pos_x INT;
+ALTER TABLE parking_spaces ADD COLUMN pos_y INT;
+
+-- Finalized daily report totals, so reports on closed days are fast and do not change.
+-- daily_stats_days marks the days that have been snapshotted for all lots.
+CREATE TABLE daily_stats (
+    lot_id INT NOT NULL REFERENCES parking_lots(id),
+    day DATE NOT NULL,
+    include_voided BOOLEAN NOT NULL,
+    total_vehicles INT NOT NULL,
+    employee_vehicles INT NOT NULL,
+    total_parking_time DOUBLE PRECISION NOT NULL,
+    total_fee INT NOT NULL,
+    voided_vehicles INT NOT NULL,
+    PRIMARY KEY (lot_id, day, include_voided)
+);
+
+CREATE TABLE daily_stats_days (
+    day DATE PRIMARY KEY,
+    snapshot_at TIMESTAMP NOT NULL
+);
+
+-- ISO 4217 code of the currency fees are charged in; fees are stored in its minor unit.
+ALTER TABLE parking_lots ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT '';
+
+-- Append-only operator notes on transactions, e.g. for disputes.
+CREATE TABLE transaction_notes (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL REFERENCES parking_transactions(id),
+    note TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_transaction_notes_transaction_id ON transaction_notes (transaction_id);
+
+-- Every change of a slot between free, occupied and maintenance, and what caused it.
+CREATE TABLE slot_state_changes (
+    id SERIAL PRIMARY KEY,
+    lot_id INT NOT NULL REFERENCES parking_lots(id),
+    slot INT NOT NULL,
+    state VARCHAR(20) NOT NULL,
+    action VARCHAR(30) NOT NULL,
+    changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_slot_state_changes_lot_id_slot ON slot_state_changes (lot_id, slot, changed_at);
+
+-- The slots and prepayment a transaction's stay was charged for, so its fee can be recomputed.
+ALTER TABLE parking_transactions ADD COLUMN slots_required INT NOT NULL DEFAULT 1;
+ALTER TABLE parking_transactions ADD COLUMN prepaid_until TIMESTAMP;
+
+-- IANA name of the zone a lot's times are given in, e.g. in responses and calendar days.
+ALTER TABLE parking_lots ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+
 --psql -U postgres -d db_vehicle_parking -h localhost -f migrations/migration.sql
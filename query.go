@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"parking_lot/storage"
+
+	"github.com/gorilla/mux"
+)
+
+func invalidQuery(name string) *storage.ParkingError {
+	return &storage.ParkingError{
+		Code:    storage.CodeInvalidRequest,
+		Status:  http.StatusBadRequest,
+		Message: "missing or invalid query parameter: " + name,
+	}
+}
+
+// pathInt returns an integer route variable.
+func pathInt(r *http.Request, name string) (int, error) {
+	value, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		return 0, &storage.ParkingError{
+			Code:    storage.CodeInvalidRequest,
+			Status:  http.StatusBadRequest,
+			Message: "invalid path parameter: " + name,
+		}
+	}
+	return value, nil
+}
+
+// queryString returns a required query parameter.
+func queryString(r *http.Request, name string) (string, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", invalidQuery(name)
+	}
+	return value, nil
+}
+
+// queryInt returns a required integer query parameter.
+func queryInt(r *http.Request, name string) (int, error) {
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return 0, invalidQuery(name)
+	}
+	return value, nil
+}
+
+// queryFloat returns a required decimal query parameter.
+func queryFloat(r *http.Request, name string) (float64, error) {
+	value, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil {
+		return 0, invalidQuery(name)
+	}
+	return value, nil
+}
+
+// queryOptionalInt returns an optional integer query parameter, or 0 when it is absent.
+func queryOptionalInt(r *http.Request, name string) (int, error) {
+	if r.URL.Query().Get(name) == "" {
+		return 0, nil
+	}
+	return queryInt(r, name)
+}
+
+// queryOptionalIntPtr returns an optional integer query parameter, or nil when it is absent.
+func queryOptionalIntPtr(r *http.Request, name string) (*int, error) {
+	if r.URL.Query().Get(name) == "" {
+		return nil, nil
+	}
+	value, err := queryInt(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// queryTime returns an optional RFC3339 query parameter in UTC, or the zero time when it is absent.
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, invalidQuery(name)
+	}
+	return t.UTC(), nil
+}
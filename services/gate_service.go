@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"parking_lot/storage"
+)
+
+// GateEntryResult is the outcome of a vehicle arriving at an entry gate.
+type GateEntryResult struct {
+	TicketID   string `json:"ticketID"`
+	SlotNumber int    `json:"slotNumber"`
+	GateOpen   bool   `json:"gateOpen"`
+}
+
+// GateExitResult is the outcome of a vehicle arriving at an exit gate.
+type GateExitResult struct {
+	Fee       int                `json:"fee"`
+	Days      []storage.DailyFee `json:"days,omitempty"`
+	AmountDue int                `json:"amountDue"`
+	GateOpen  bool               `json:"gateOpen"`
+}
+
+// SetGateRequiresPayment controls whether exit gates stay closed until the fee is paid.
+func (s *ParkingLotService) SetGateRequiresPayment(required bool) {
+	s.gateRequiresPayment = required
+}
+
+// GateEntry parks the scanned vehicle and opens the gate once it has a slot.
+func (s *ParkingLotService) GateEntry(ctx context.Context, parkingLotID int, licensePlate string, opts storage.ParkOptions) (*GateEntryResult, error) {
+	ticket, err := s.storage.ParkVehicle(ctx, parkingLotID, licensePlate, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GateEntryResult{TicketID: ticket.TicketID, SlotNumber: ticket.SlotNumber, GateOpen: true}, nil
+}
+
+// GateExit unparks the scanned vehicle and opens the gate when the fee is settled
+// by amountPaid and any prepayment, or unconditionally when the gate does not require payment.
+// A pending exit is confirmed once the gate opens.
+func (s *ParkingLotService) GateExit(ctx context.Context, parkingLotID int, licensePlate string, amountPaid int) (*GateExitResult, error) {
+	fee, err := s.storage.UnparkVehicle(ctx, parkingLotID, licensePlate, "")
+	if err != nil {
+		return nil, err
+	}
+
+	amountDue := fee.Fee - fee.Prepaid - amountPaid
+	if amountDue < 0 {
+		amountDue = 0
+	}
+
+	gateOpen := !s.gateRequiresPayment || amountDue == 0
+	if gateOpen && fee.PendingExit != nil {
+		if _, err := s.storage.ConfirmExit(ctx, fee.PendingExit.TicketID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &GateExitResult{
+		Fee:       fee.Fee,
+		Days:      fee.Days,
+		AmountDue: amountDue,
+		GateOpen:  gateOpen,
+	}, nil
+}
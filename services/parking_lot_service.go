@@ -3,38 +3,366 @@
 package services
 
 import (
+	"context"
+	"time"
 
 	"parking_lot/storage"
 )
 
 type ParkingLotService struct {
 	storage *storage.ParkingLotStorage
+
+	gateRequiresPayment bool
 }
 
 func NewParkingLotService(storage *storage.ParkingLotStorage) *ParkingLotService {
 	return &ParkingLotService{storage: storage}
 }
 
-func (s *ParkingLotService) CreateParkingLot(totalSpaces int) (*storage.ParkingLot, error) {
-	return s.storage.CreateParkingLot(totalSpaces)
+func (s *ParkingLotService) CreateParkingLot(ctx context.Context, totalSpaces int, opts storage.LotOptions) (*storage.ParkingLot, error) {
+	return s.storage.CreateParkingLot(ctx, totalSpaces, opts)
+}
+
+func (s *ParkingLotService) ParkVehicle(ctx context.Context, parkingLotID int,LicensePlate string, opts storage.ParkOptions) (*storage.ParkingTicket, error) {
+	return s.storage.ParkVehicle(ctx, parkingLotID,LicensePlate, opts)
+}
+
+func (s *ParkingLotService) UnparkVehicle(ctx context.Context, parkingLotID int, LicensePlate, validationCode string) (*storage.FeeBreakdown, error) {
+	return s.storage.UnparkVehicle(ctx, parkingLotID, LicensePlate, validationCode)
+}
+
+func (s *ParkingLotService) ViewParkingLotStatus(ctx context.Context, parkingLotID int) (*storage.ParkingLotStatus, error) {
+	return s.storage.ViewParkingLotStatus(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) ToggleMaintenance(ctx context.Context, parkingLotID, slotNumber int, inMaintenance bool, details storage.MaintenanceDetails) (bool, error) {
+	return s.storage.ToggleMaintenance(ctx, parkingLotID, slotNumber, inMaintenance, details)
+}
+
+func (s *ParkingLotService) GetReports(ctx context.Context, parkingLotID int, includeVoided bool) ([]*storage.DailyStats, error) {
+	return s.storage.GetReports(ctx, parkingLotID, includeVoided)
+}
+
+func (s *ParkingLotService) HoldSlot(ctx context.Context, parkingLotID int, vehicleType string) (*storage.SlotHold, error) {
+	return s.storage.HoldSlot(ctx, parkingLotID, vehicleType)
+}
+
+func (s *ParkingLotService) ConfirmHold(ctx context.Context, token string) error {
+	return s.storage.ConfirmHold(ctx, token)
+}
+
+func (s *ParkingLotService) ReleaseHold(ctx context.Context, token string) error {
+	return s.storage.ReleaseHold(ctx, token)
+}
+
+func (s *ParkingLotService) GetReportsForLots(ctx context.Context, lotIDs []int, from, to time.Time) ([]*storage.LotReport, error) {
+	return s.storage.GetReportsForLots(ctx, lotIDs, from, to)
+}
+
+func (s *ParkingLotService) GetTicketFee(ctx context.Context, ticketID string) (*storage.TicketFee, error) {
+	return s.storage.GetTicketFee(ctx, ticketID)
+}
+
+func (s *ParkingLotService) GetReportsByVehicleType(ctx context.Context, parkingLotID int, from, to time.Time) ([]*storage.VehicleTypeStats, error) {
+	return s.storage.GetReportsByVehicleType(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) SetAllocationStrategy(ctx context.Context, parkingLotID int, strategy string) error {
+	return s.storage.SetAllocationStrategy(ctx, parkingLotID, strategy)
+}
+
+func (s *ParkingLotService) GetOccupancyHeatmap(ctx context.Context, parkingLotID int, from, to time.Time) (*storage.OccupancyHeatmap, error) {
+	return s.storage.GetOccupancyHeatmap(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) FindOrphanedSlots(ctx context.Context, parkingLotID int) ([]*storage.OrphanedSlot, error) {
+	return s.storage.FindOrphanedSlots(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) RepairOrphanedSlots(ctx context.Context, parkingLotID int) ([]int, error) {
+	return s.storage.RepairOrphanedSlots(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) BackfillDailyStats(ctx context.Context, from, to time.Time) (int, error) {
+	return s.storage.BackfillDailyStats(ctx, from, to)
+}
+
+func (s *ParkingLotService) Reconcile(ctx context.Context, parkingLotID, sensorOccupied int, fix bool) (*storage.Reconciliation, error) {
+	return s.storage.Reconcile(ctx, parkingLotID, sensorOccupied, fix)
+}
+
+func (s *ParkingLotService) FindLotsWithAvailability(ctx context.Context, vehicleType string, limit int) ([]*storage.LotAvailability, error) {
+	return s.storage.FindLotsWithAvailability(ctx, vehicleType, limit)
+}
+
+func (s *ParkingLotService) FindLotsWithFreeSlots(ctx context.Context, minFree, limit int) ([]*storage.LotAvailability, error) {
+	return s.storage.FindLotsWithFreeSlots(ctx, minFree, limit)
+}
+
+func (s *ParkingLotService) EstimateWaitTime(ctx context.Context, parkingLotID int) (*storage.WaitEstimate, error) {
+	return s.storage.EstimateWaitTime(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) ImportTransactions(ctx context.Context, parkingLotID int, records []storage.TransactionRecord) (*storage.ImportResult, error) {
+	return s.storage.ImportTransactions(ctx, parkingLotID, records)
+}
+
+func (s *ParkingLotService) GetRevenueBySlot(ctx context.Context, parkingLotID int, from, to time.Time) ([]*storage.SlotRevenue, error) {
+	return s.storage.GetRevenueBySlot(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) CountParkingLots(ctx context.Context) (int, error) {
+	return s.storage.CountParkingLots(ctx)
+}
+
+func (s *ParkingLotService) GetMaintenanceSlots(ctx context.Context, parkingLotID int) ([]*storage.MaintenanceSlot, error) {
+	return s.storage.GetMaintenanceSlots(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetBreakEvenOccupancy(ctx context.Context, parkingLotID int, dailyCost int) (*storage.BreakEven, error) {
+	return s.storage.GetBreakEvenOccupancy(ctx, parkingLotID, dailyCost)
+}
+
+func (s *ParkingLotService) GetParkingLot(ctx context.Context, parkingLotID int) (*storage.ParkingLot, error) {
+	return s.storage.GetParkingLot(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) ClearAllMaintenance(ctx context.Context, parkingLotID int) (int, error) {
+	return s.storage.ClearAllMaintenance(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetLotConfig(ctx context.Context, parkingLotID int) (*storage.LotConfig, error) {
+	return s.storage.GetLotConfig(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetTotalParkedNow(ctx context.Context) (*storage.ParkedNow, error) {
+	return s.storage.GetTotalParkedNow(ctx)
+}
+
+func (s *ParkingLotService) SetFeeStrategy(ctx context.Context, parkingLotID int, strategy string) error {
+	return s.storage.SetFeeStrategy(ctx, parkingLotID, strategy)
+}
+
+func (s *ParkingLotService) GetLotsAboveOccupancy(ctx context.Context, threshold float64) ([]*storage.LotOccupancy, error) {
+	return s.storage.GetLotsAboveOccupancy(ctx, threshold)
+}
+
+func (s *ParkingLotService) SetNegativeFeePolicy(ctx context.Context, parkingLotID int, policy string) error {
+	return s.storage.SetNegativeFeePolicy(ctx, parkingLotID, policy)
+}
+
+func (s *ParkingLotService) GetLotVersion(ctx context.Context, parkingLotID int) (int64, error) {
+	return s.storage.GetLotVersion(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) SetSlotPriority(ctx context.Context, parkingLotID int, priority string) error {
+	return s.storage.SetSlotPriority(ctx, parkingLotID, priority)
+}
+
+func (s *ParkingLotService) GetLastTransaction(ctx context.Context, licensePlate string) (*storage.Transaction, error) {
+	return s.storage.GetLastTransaction(ctx, licensePlate)
+}
+
+func (s *ParkingLotService) GetTurnoverRate(ctx context.Context, parkingLotID int, from, to time.Time) (*storage.TurnoverRate, error) {
+	return s.storage.GetTurnoverRate(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) ParkVehicleAt(ctx context.Context, parkingLotID int, licensePlate string, entryTime time.Time, opts storage.ParkOptions) (*storage.ParkingTicket, error) {
+	return s.storage.ParkVehicleAt(ctx, parkingLotID, licensePlate, entryTime, opts)
+}
+
+func (s *ParkingLotService) GetSlotsUsedByVehicle(ctx context.Context, licensePlate string, from, to time.Time) ([]*storage.SlotUsage, error) {
+	return s.storage.GetSlotsUsedByVehicle(ctx, licensePlate, from, to)
+}
+
+func (s *ParkingLotService) SetOverflowLot(ctx context.Context, parkingLotID, overflowLotID int) error {
+	return s.storage.SetOverflowLot(ctx, parkingLotID, overflowLotID)
+}
+
+func (s *ParkingLotService) GetKPIs(ctx context.Context) (*storage.KPIs, error) {
+	return s.storage.GetKPIs(ctx)
+}
+
+func (s *ParkingLotService) ReserveBlock(ctx context.Context, parkingLotID, count int, from, to time.Time) (*storage.BlockReservation, error) {
+	return s.storage.ReserveBlock(ctx, parkingLotID, count, from, to)
+}
+
+func (s *ParkingLotService) ReleaseBlock(ctx context.Context, reservationID int) error {
+	return s.storage.ReleaseBlock(ctx, reservationID)
+}
+
+func (s *ParkingLotService) SetLotMaintenanceGrace(ctx context.Context, parkingLotID int, enabled bool) error {
+	return s.storage.SetLotMaintenanceGrace(ctx, parkingLotID, enabled)
+}
+
+func (s *ParkingLotService) SetLotTimezone(ctx context.Context, parkingLotID int, timezone string) error {
+	return s.storage.SetLotTimezone(ctx, parkingLotID, timezone)
+}
+
+func (s *ParkingLotService) GetTicketLifecycle(ctx context.Context, ticketID string) (*storage.TicketLifecycle, error) {
+	return s.storage.GetTicketLifecycle(ctx, ticketID)
+}
+
+func (s *ParkingLotService) SetEntranceDistances(ctx context.Context, parkingLotID int, entranceID string, distances map[int]int) error {
+	return s.storage.SetEntranceDistances(ctx, parkingLotID, entranceID, distances)
+}
+
+func (s *ParkingLotService) SetOperatingHours(ctx context.Context, parkingLotID int, hours *storage.OperatingHours) error {
+	return s.storage.SetOperatingHours(ctx, parkingLotID, hours)
+}
+
+func (s *ParkingLotService) QuoteFee(ctx context.Context, parkingLotID int, durationMinutes int) (*storage.FeeBreakdown, error) {
+	return s.storage.QuoteFee(ctx, parkingLotID, durationMinutes)
+}
+
+func (s *ParkingLotService) CreateOrganization(ctx context.Context, name string) (*storage.Organization, error) {
+	return s.storage.CreateOrganization(ctx, name)
+}
+
+func (s *ParkingLotService) GetOrganizationByKey(ctx context.Context, apiKey string) (*storage.Organization, error) {
+	return s.storage.GetOrganizationByKey(ctx, apiKey)
+}
+
+func (s *ParkingLotService) GetLotOrgID(ctx context.Context, parkingLotID int) (int, error) {
+	return s.storage.GetLotOrgID(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) ListParkingLots(ctx context.Context, orgID int) ([]*storage.LotSummary, error) {
+	return s.storage.ListParkingLots(ctx, orgID)
+}
+
+func (s *ParkingLotService) GetReportsForOrganization(ctx context.Context, orgID int, from, to time.Time) ([]*storage.LotReport, error) {
+	return s.storage.GetReportsForOrganization(ctx, orgID, from, to)
+}
+
+func (s *ParkingLotService) FindDuplicateParkedVehicles(ctx context.Context, parkingLotID int) ([]*storage.DuplicateParkedVehicle, error) {
+	return s.storage.FindDuplicateParkedVehicles(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) RepairDuplicateParkedVehicles(ctx context.Context, parkingLotID int) (*storage.DuplicateRepair, error) {
+	return s.storage.RepairDuplicateParkedVehicles(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetOccupancyAt(ctx context.Context, parkingLotID int, at time.Time) (*storage.PointOccupancy, error) {
+	return s.storage.GetOccupancyAt(ctx, parkingLotID, at)
+}
+
+func (s *ParkingLotService) ConfirmExit(ctx context.Context, ticketID string) (*storage.FeeBreakdown, error) {
+	return s.storage.ConfirmExit(ctx, ticketID)
+}
+
+func (s *ParkingLotService) GetAverageFee(ctx context.Context, parkingLotID int, from, to time.Time) (*storage.AverageFee, error) {
+	return s.storage.GetAverageFee(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) UpdatePricingBulk(ctx context.Context, lotIDs []int, pricing storage.PricingConfig) (*storage.BulkPricingResult, error) {
+	return s.storage.UpdatePricingBulk(ctx, lotIDs, pricing)
+}
+
+func (s *ParkingLotService) PeekNearestFreeSlot(ctx context.Context, parkingLotID int, vehicleType string) (int, error) {
+	return s.storage.PeekNearestFreeSlot(ctx, parkingLotID, vehicleType)
+}
+
+func (s *ParkingLotService) GetNextAvailableByType(ctx context.Context, parkingLotID int) (map[string]*int, error) {
+	return s.storage.GetNextAvailableByType(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetDurationStatsByType(ctx context.Context, parkingLotID int, from, to time.Time) ([]*storage.DurationStats, error) {
+	return s.storage.GetDurationStatsByType(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) ListTransactions(ctx context.Context, filter storage.TransactionFilter) ([]*storage.Transaction, error) {
+	return s.storage.ListTransactions(ctx, filter)
+}
+
+func (s *ParkingLotService) AddEmployeePlate(ctx context.Context, parkingLotID int, licensePlate string) error {
+	return s.storage.AddEmployeePlate(ctx, parkingLotID, licensePlate)
+}
+
+func (s *ParkingLotService) RemoveEmployeePlate(ctx context.Context, parkingLotID int, licensePlate string) error {
+	return s.storage.RemoveEmployeePlate(ctx, parkingLotID, licensePlate)
+}
+
+func (s *ParkingLotService) GetEmployeePlates(ctx context.Context, parkingLotID int) ([]*storage.EmployeePlate, error) {
+	return s.storage.GetEmployeePlates(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetUnusedSlots(ctx context.Context, parkingLotID int, since time.Time) ([]*storage.UnusedSlot, error) {
+	return s.storage.GetUnusedSlots(ctx, parkingLotID, since)
+}
+
+func (s *ParkingLotService) RefundLostTicketDifference(ctx context.Context, transactionID int, originalEntryTime time.Time) (*storage.LostTicketRefund, error) {
+	return s.storage.RefundLostTicketDifference(ctx, transactionID, originalEntryTime)
+}
+
+func (s *ParkingLotService) GetMaxDailyRevenue(ctx context.Context, parkingLotID int) (*storage.MaxDailyRevenue, error) {
+	return s.storage.GetMaxDailyRevenue(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetCurrentStayDistribution(ctx context.Context, parkingLotID int) ([]*storage.StayBucket, error) {
+	return s.storage.GetCurrentStayDistribution(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) ExportActiveSessions(ctx context.Context, parkingLotID int, write func(*storage.ActiveSession) error) error {
+	return s.storage.ExportActiveSessions(ctx, parkingLotID, write)
+}
+
+func (s *ParkingLotService) GetAboveAverageStays(ctx context.Context, parkingLotID int) (*storage.AboveAverageStays, error) {
+	return s.storage.GetAboveAverageStays(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetSlotUtilizationRank(ctx context.Context, parkingLotID int, from, to time.Time) ([]*storage.SlotUtilization, error) {
+	return s.storage.GetSlotUtilizationRank(ctx, parkingLotID, from, to)
+}
+
+func (s *ParkingLotService) CheckLotIntegrity(ctx context.Context, parkingLotID int) (*storage.IntegrityReport, error) {
+	return s.storage.CheckLotIntegrity(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetRevenueTrend(ctx context.Context, parkingLotID int, period string) (*storage.RevenueTrend, error) {
+	return s.storage.GetRevenueTrend(ctx, parkingLotID, period)
+}
+
+func (s *ParkingLotService) GetAllActiveSessions(ctx context.Context, limit, offset int) (*storage.ActiveSessionPage, error) {
+	return s.storage.GetAllActiveSessions(ctx, limit, offset)
+}
+
+func (s *ParkingLotService) CreateValidation(ctx context.Context, validation storage.Validation) (*storage.Validation, error) {
+	return s.storage.CreateValidation(ctx, validation)
+}
+
+func (s *ParkingLotService) SetSlotLayout(ctx context.Context, parkingLotID int, placements []storage.SlotPlacement) error {
+	return s.storage.SetSlotLayout(ctx, parkingLotID, placements)
+}
+
+func (s *ParkingLotService) GetLotLayout(ctx context.Context, parkingLotID int) (*storage.LotLayout, error) {
+	return s.storage.GetLotLayout(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetRateHistory(ctx context.Context, parkingLotID int) ([]*storage.RateChange, error) {
+	return s.storage.GetRateHistory(ctx, parkingLotID)
+}
+
+func (s *ParkingLotService) GetOccupancyByFloor(ctx context.Context, parkingLotID int) ([]*storage.FloorOccupancy, error) {
+	return s.storage.GetOccupancyByFloor(ctx, parkingLotID)
 }
 
-func (s *ParkingLotService) ParkVehicle(parkingLotID int,LicensePlate string) (int, error) {
-	return s.storage.ParkVehicle(parkingLotID,LicensePlate)
+func (s *ParkingLotService) GetRevenueRunRate(ctx context.Context, parkingLotID int) (*storage.RevenueRunRate, error) {
+	return s.storage.GetRevenueRunRate(ctx, parkingLotID)
 }
 
-func (s *ParkingLotService) UnparkVehicle(parkingLotID int, LicensePlate string) (int, error) {
-	return s.storage.UnparkVehicle(parkingLotID, LicensePlate)
+func (s *ParkingLotService) IsVehicleParked(ctx context.Context, licensePlate string) (*storage.PlateStatus, error) {
+	return s.storage.IsVehicleParked(ctx, licensePlate)
 }
 
-func (s *ParkingLotService) ViewParkingLotStatus(parkingLotID int) (*storage.ParkingLotStatus, error) {
-	return s.storage.ViewParkingLotStatus(parkingLotID)
+func (s *ParkingLotService) SetLotCurrency(ctx context.Context, parkingLotID int, currency string) error {
+	return s.storage.SetLotCurrency(ctx, parkingLotID, currency)
 }
 
-func (s *ParkingLotService) ToggleMaintenance(parkingLotID, slotNumber int, inMaintenance bool) error {
-	return s.storage.ToggleMaintenance(parkingLotID, slotNumber, inMaintenance)
+func (s *ParkingLotService) AddTransactionNote(ctx context.Context, transactionID int, note string) (*storage.TransactionNote, error) {
+	return s.storage.AddTransactionNote(ctx, transactionID, note)
 }
 
-func (s *ParkingLotService) GetReports(parkingLotID int) ([]*storage.DailyStats, error) {
-	return s.storage.GetReports(parkingLotID)
+func (s *ParkingLotService) GetSlotStateHistory(ctx context.Context, parkingLotID, slotNumber int, from, to time.Time) ([]*storage.SlotStateChange, error) {
+	return s.storage.GetSlotStateHistory(ctx, parkingLotID, slotNumber, from, to)
 }
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultRetryAfter is suggested to clients of a full lot when no wait estimate is available.
+	DefaultRetryAfter = 5 * time.Minute
+	// minRetryAfter keeps clients from retrying right away when a slot is about to free up.
+	minRetryAfter = time.Minute
+)
+
+// RetryAfter suggests how long a client turned away from a full lot should wait before
+// trying again, based on the lot's wait estimate.
+func (s *ParkingLotService) RetryAfter(ctx context.Context, parkingLotID int) time.Duration {
+	estimate, err := s.storage.EstimateWaitTime(ctx, parkingLotID)
+	if err != nil || estimate.WaitMinutes == nil {
+		return DefaultRetryAfter
+	}
+
+	retryAfter := time.Duration(*estimate.WaitMinutes) * time.Minute
+	if retryAfter < minRetryAfter {
+		return minRetryAfter
+	}
+	return retryAfter
+}
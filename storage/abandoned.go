@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// DefaultAutoUnparkAfter is how long a vehicle stays parked before it is treated as abandoned.
+const DefaultAutoUnparkAfter = 30 * 24 * time.Hour
+
+// StartAbandonedSweeper periodically unparks vehicles parked for longer than after,
+// recording their transactions as abandoned and freeing their slots, until ctx is cancelled.
+func (s *ParkingLotStorage) StartAbandonedSweeper(ctx context.Context, after, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			s.mu.Lock()
+			unparked, err := s.unparkAbandoned(ctx, after)
+			s.mu.Unlock()
+			if err != nil {
+				log.Println("failed to unpark abandoned vehicles:", err)
+			}
+			if unparked > 0 {
+				log.Println("unparked abandoned vehicles:", unparked)
+			}
+		}
+	}()
+}
+
+// unparkAbandoned closes the stays of vehicles that entered before now minus after and
+// returns how many were closed. The caller must hold s.mu.
+func (s *ParkingLotStorage) unparkAbandoned(ctx context.Context, after time.Duration) (int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+parkedStayColumns+`
+		FROM parked_vehicles
+		WHERE parked_vehicles.exit_time IS NULL AND parked_vehicles.entry_time <= $1
+	`, s.now().Add(-after))
+	if err != nil {
+		return 0, err
+	}
+
+	var stays []*parkedStay
+	for rows.Next() {
+		stay, err := scanParkedStay(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stays = append(stays, stay)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var unparked int64
+	for _, stay := range stays {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return unparked, err
+		}
+		exitTime := s.now()
+		fee, err := s.closeStay(ctx, tx, stay, exitTime, true)
+		if errors.Is(err, ErrAlreadyUnparked) {
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return unparked, err
+		}
+		if err := tx.Commit(); err != nil {
+			return unparked, err
+		}
+		unparked++
+		log.Printf("auto-unparked abandoned vehicle from lot %d slot %d, parked since %s, fee %d",
+			stay.ParkingLotID, stay.FirstSlot, stay.EntryTime.Format(time.RFC3339), fee.Fee)
+	}
+
+	return unparked, nil
+}
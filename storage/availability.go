@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// defaultLotSearchLimit caps lot searches that do not specify a limit.
+const defaultLotSearchLimit = 20
+
+// LotAvailability is the number of free slots a parking lot has for a vehicle type.
+type LotAvailability struct {
+	ParkingLotID int `json:"parkingLotID"`
+	FreeSlots    int `json:"freeSlots"`
+}
+
+// FindLotsWithAvailability returns the lots that currently have a free slot compatible
+// with vehicleType, the lots with the most free slots first.
+func (s *ParkingLotStorage) FindLotsWithAvailability(ctx context.Context, vehicleType string, limit int) ([]*LotAvailability, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if vehicleType == "" {
+		vehicleType = DefaultVehicleType
+	}
+	if limit <= 0 {
+		limit = defaultLotSearchLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT lot_id, COUNT(*) AS free_slots
+		FROM parking_spaces
+		WHERE vehicle_type = $1 AND NOT occupied AND NOT in_maintenance
+		AND `+notHeld("$3")+`
+		AND `+notReserved("$3", "0")+`
+		GROUP BY lot_id
+		ORDER BY free_slots DESC, lot_id
+		LIMIT $2
+	`, vehicleType, limit, s.now())
+	if err != nil {
+		return nil, internalError("failed to find lots with availability")
+	}
+	defer rows.Close()
+
+	lots := []*LotAvailability{}
+	for rows.Next() {
+		var lot LotAvailability
+		if err := rows.Scan(&lot.ParkingLotID, &lot.FreeSlots); err != nil {
+			return nil, internalError("failed to read lots with availability")
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing lots with availability")
+	}
+
+	return lots, nil
+}
+
+// FindLotsWithFreeSlots returns the lots that currently have at least minFree free slots
+// of any vehicle type, the lots with the most free slots first.
+func (s *ParkingLotStorage) FindLotsWithFreeSlots(ctx context.Context, minFree, limit int) ([]*LotAvailability, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if minFree < 1 {
+		return nil, invalidRequest("min must be at least 1")
+	}
+	if limit <= 0 {
+		limit = defaultLotSearchLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT lot_id, COUNT(*) AS free_slots
+		FROM parking_spaces
+		WHERE NOT occupied AND NOT in_maintenance
+		AND `+notHeld("$3")+`
+		AND `+notReserved("$3", "0")+`
+		GROUP BY lot_id
+		HAVING COUNT(*) >= $1
+		ORDER BY free_slots DESC, lot_id
+		LIMIT $2
+	`, minFree, limit, s.now())
+	if err != nil {
+		return nil, internalError("failed to find lots with free slots")
+	}
+	defer rows.Close()
+
+	lots := []*LotAvailability{}
+	for rows.Next() {
+		var lot LotAvailability
+		if err := rows.Scan(&lot.ParkingLotID, &lot.FreeSlots); err != nil {
+			return nil, internalError("failed to read lots with free slots")
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing lots with free slots")
+	}
+
+	return lots, nil
+}
+
+// LotParkedCount is the number of occupied slots in a parking lot.
+type LotParkedCount struct {
+	ParkingLotID  int `json:"parkingLotID"`
+	OccupiedSlots int `json:"occupiedSlots"`
+}
+
+// ParkedNow is the number of occupied slots across all parking lots right now.
+type ParkedNow struct {
+	TotalOccupiedSlots int               `json:"totalOccupiedSlots"`
+	Lots               []*LotParkedCount `json:"lots"`
+}
+
+// GetTotalParkedNow returns the number of currently occupied slots across all lots,
+// broken down per lot.
+func (s *ParkingLotStorage) GetTotalParkedNow(ctx context.Context) (*ParkedNow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parking_lots.id, COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied)
+		FROM parking_lots
+		LEFT JOIN parking_spaces ON parking_spaces.lot_id = parking_lots.id
+		GROUP BY parking_lots.id
+		ORDER BY parking_lots.id
+	`)
+	if err != nil {
+		return nil, internalError("failed to count parked vehicles")
+	}
+	defer rows.Close()
+
+	parkedNow := &ParkedNow{Lots: []*LotParkedCount{}}
+	for rows.Next() {
+		var lot LotParkedCount
+		if err := rows.Scan(&lot.ParkingLotID, &lot.OccupiedSlots); err != nil {
+			return nil, internalError("failed to read parked vehicle counts")
+		}
+		parkedNow.TotalOccupiedSlots += lot.OccupiedSlots
+		parkedNow.Lots = append(parkedNow.Lots, &lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parked vehicle counts")
+	}
+
+	return parkedNow, nil
+}
+
+// LotOccupancy is how full a parking lot is.
+type LotOccupancy struct {
+	ParkingLotID     int     `json:"parkingLotID"`
+	TotalSpaces      int     `json:"totalSpaces"`
+	OccupiedSlots    int     `json:"occupiedSlots"`
+	OccupancyPercent float64 `json:"occupancyPercent"`
+}
+
+// GetLotsAboveOccupancy returns the lots whose occupied share of spaces exceeds threshold,
+// a fraction between 0 and 1, the fullest lots first.
+func (s *ParkingLotStorage) GetLotsAboveOccupancy(ctx context.Context, threshold float64) ([]*LotOccupancy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if threshold < 0 || threshold > 1 {
+		return nil, invalidRequest("threshold must be between 0 and 1")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			parking_lots.id,
+			parking_lots.total_spaces,
+			COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied) AS occupied,
+			100.0 * COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied) / parking_lots.total_spaces AS occupancy_percent
+		FROM parking_lots
+		LEFT JOIN parking_spaces ON parking_spaces.lot_id = parking_lots.id
+		WHERE parking_lots.total_spaces > 0
+		GROUP BY parking_lots.id
+		HAVING COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied) > $1 * parking_lots.total_spaces
+		ORDER BY occupancy_percent DESC, parking_lots.id
+	`, threshold)
+	if err != nil {
+		return nil, internalError("failed to find lots above occupancy")
+	}
+	defer rows.Close()
+
+	lots := []*LotOccupancy{}
+	for rows.Next() {
+		var lot LotOccupancy
+		if err := rows.Scan(&lot.ParkingLotID, &lot.TotalSpaces, &lot.OccupiedSlots, &lot.OccupancyPercent); err != nil {
+			return nil, internalError("failed to read lots above occupancy")
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing lots above occupancy")
+	}
+
+	return lots, nil
+}
+
+// PeekNearestFreeSlot returns the slot ParkVehicle would currently assign to a vehicle of
+// the given type in the specified lot, or 0 when the lot is full. Nothing is reserved.
+func (s *ParkingLotStorage) PeekNearestFreeSlot(ctx context.Context, parkingLotID int, vehicleType string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vehicleType, err := s.lotVehicleType(ctx, parkingLotID, vehicleType)
+	if err != nil {
+		return 0, err
+	}
+
+	slotNumber, err := s.nextFreeBlock(ctx, s.db, parkingLotID, vehicleType, 1, 0, "")
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, internalError("failed to look up free slot")
+	}
+
+	return slotNumber, nil
+}
+
+// GetNextAvailableByType returns, for every vehicle type the specified lot has slots for,
+// the slot ParkVehicle would currently assign to a vehicle of that type, or nil when none
+// of its slots is free. Nothing is reserved.
+func (s *ParkingLotStorage) GetNextAvailableByType(ctx context.Context, parkingLotID int) (map[string]*int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH lot AS (
+			SELECT CASE WHEN allocation_strategy = $2 THEN last_assigned_slot ELSE 0 END AS start_after
+			FROM parking_lots WHERE id = $1
+		), free_spaces AS (
+			SELECT DISTINCT ON (vehicle_type) vehicle_type, number
+			FROM parking_spaces, lot
+			WHERE lot_id = $1 AND NOT occupied AND NOT in_maintenance
+			AND `+notHeld("$3")+`
+			AND `+notReserved("$3", "0")+`
+			ORDER BY vehicle_type, number <= lot.start_after, number
+		)
+		SELECT types.vehicle_type, free_spaces.number
+		FROM (SELECT DISTINCT vehicle_type FROM parking_spaces WHERE lot_id = $1) types
+		LEFT JOIN free_spaces ON free_spaces.vehicle_type = types.vehicle_type
+	`, parkingLotID, StrategyRoundRobin, s.now())
+	if err != nil {
+		return nil, internalError("failed to look up free slots")
+	}
+	defer rows.Close()
+
+	next := make(map[string]*int)
+	for rows.Next() {
+		var vehicleType string
+		var slotNumber sql.NullInt64
+		if err := rows.Scan(&vehicleType, &slotNumber); err != nil {
+			return nil, internalError("failed to read free slots")
+		}
+		next[vehicleType] = nil
+		if slotNumber.Valid {
+			number := int(slotNumber.Int64)
+			next[vehicleType] = &number
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing free slots")
+	}
+
+	return next, nil
+}
+
+// stayBucketBounds are the upper bounds of the stay duration buckets; the last bucket
+// has no upper bound.
+var stayBucketBounds = []time.Duration{
+	time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// StayBucket counts the parked vehicles whose stay so far is at least MinHours and,
+// unless MaxHours is nil, less than MaxHours.
+type StayBucket struct {
+	MinHours int  `json:"minHours"`
+	MaxHours *int `json:"maxHours,omitempty"`
+	Vehicles int  `json:"vehicles"`
+}
+
+// GetCurrentStayDistribution buckets the vehicles parked in the specified lot right now
+// by how long they have been parked.
+func (s *ParkingLotStorage) GetCurrentStayDistribution(ctx context.Context, parkingLotID int) ([]*StayBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]*StayBucket, 0, len(stayBucketBounds)+1)
+	var lower time.Duration
+	for _, upper := range stayBucketBounds {
+		maxHours := int(upper.Hours())
+		buckets = append(buckets, &StayBucket{MinHours: int(lower.Hours()), MaxHours: &maxHours})
+		lower = upper
+	}
+	buckets = append(buckets, &StayBucket{MinHours: int(lower.Hours())})
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT entry_time
+		FROM parked_vehicles
+		WHERE parking_lot_id = $1 AND exit_time IS NULL
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve parked vehicles")
+	}
+	defer rows.Close()
+
+	now := s.now()
+	for rows.Next() {
+		var entryTime time.Time
+		if err := rows.Scan(&entryTime); err != nil {
+			return nil, internalError("failed to read parked vehicles")
+		}
+		stay := now.Sub(entryTime)
+		i := 0
+		for i < len(stayBucketBounds) && stay >= stayBucketBounds[i] {
+			i++
+		}
+		buckets[i].Vehicles++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parked vehicles")
+	}
+
+	return buckets, nil
+}
+
+// LongStay is a vehicle parked now whose stay so far exceeds its lot's average stay.
+type LongStay struct {
+	SlotNumber   int       `json:"slotNumber"`
+	LicensePlate string    `json:"licensePlate"`
+	TicketID     string    `json:"ticketID,omitempty"`
+	EntryTime    time.Time `json:"entryTime"`
+	StayMinutes  int       `json:"stayMinutes"`
+}
+
+// AboveAverageStays lists the vehicles parked in a lot longer than its historical
+// average stay. AverageStayMinutes is nil when the lot has no completed stays yet.
+type AboveAverageStays struct {
+	ParkingLotID       int         `json:"parkingLotID"`
+	AverageStayMinutes *float64    `json:"averageStayMinutes,omitempty"`
+	Vehicles           []*LongStay `json:"vehicles"`
+}
+
+// GetAboveAverageStays returns the vehicles parked in the specified lot right now that
+// have stayed longer than the average of its non-voided transactions, longest first.
+func (s *ParkingLotStorage) GetAboveAverageStays(ctx context.Context, parkingLotID int) (*AboveAverageStays, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AboveAverageStays{ParkingLotID: parkingLotID, Vehicles: []*LongStay{}}
+	var averageStaySeconds sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT AVG(EXTRACT(EPOCH FROM (exit_time - entry_time)))
+		FROM parking_transactions
+		WHERE lot_id = $1 AND voided_at IS NULL
+	`, parkingLotID).Scan(&averageStaySeconds)
+	if err != nil {
+		return nil, internalError("failed to retrieve average stay")
+	}
+	if !averageStaySeconds.Valid {
+		return result, nil
+	}
+	averageStay := time.Duration(averageStaySeconds.Float64 * float64(time.Second))
+	averageMinutes := averageStay.Minutes()
+	result.AverageStayMinutes = &averageMinutes
+
+	now := s.now()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT slot, license_plate, COALESCE(ticket_id, ''), entry_time
+		FROM parked_vehicles
+		WHERE parking_lot_id = $1 AND exit_time IS NULL AND entry_time < $2
+		ORDER BY entry_time, id
+	`, parkingLotID, now.Add(-averageStay))
+	if err != nil {
+		return nil, internalError("failed to retrieve parked vehicles")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stay LongStay
+		if err := rows.Scan(&stay.SlotNumber, &stay.LicensePlate, &stay.TicketID, &stay.EntryTime); err != nil {
+			return nil, internalError("failed to read parked vehicles")
+		}
+		stay.StayMinutes = int(now.Sub(stay.EntryTime).Minutes())
+		inLotZone(lot, &stay.EntryTime)
+		result.Vehicles = append(result.Vehicles, &stay)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parked vehicles")
+	}
+
+	return result, nil
+}
+
+// FloorOccupancy is the number of slots on one floor of a parking lot by state. Free
+// slots are those a walk-in could park in now; the floor is full when there are none.
+type FloorOccupancy struct {
+	Floor         string `json:"floor"`
+	TotalSlots    int    `json:"totalSlots"`
+	OccupiedSlots int    `json:"occupiedSlots"`
+	FreeSlots     int    `json:"freeSlots"`
+	Full          bool   `json:"full"`
+}
+
+// GetOccupancyByFloor counts the slots of the specified lot per floor, ordered by floor.
+// Slots without a floor are counted under an empty floor name.
+func (s *ParkingLotStorage) GetOccupancyByFloor(ctx context.Context, parkingLotID int) ([]*FloorOccupancy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(floor, ''), COUNT(*), COUNT(*) FILTER (WHERE occupied),
+			COUNT(*) FILTER (WHERE NOT occupied AND NOT in_maintenance
+				AND `+notHeld("$2")+`
+				AND `+notReserved("$2", "0")+`)
+		FROM parking_spaces
+		WHERE lot_id = $1
+		GROUP BY 1
+		ORDER BY 1
+	`, parkingLotID, s.now())
+	if err != nil {
+		return nil, internalError("failed to retrieve floor occupancy")
+	}
+	defer rows.Close()
+
+	floors := []*FloorOccupancy{}
+	for rows.Next() {
+		var floor FloorOccupancy
+		if err := rows.Scan(&floor.Floor, &floor.TotalSlots, &floor.OccupiedSlots, &floor.FreeSlots); err != nil {
+			return nil, internalError("failed to read floor occupancy")
+		}
+		floor.Full = floor.FreeSlots == 0
+		floors = append(floors, &floor)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing floor occupancy")
+	}
+
+	return floors, nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrReservationNotFound = &ParkingError{Code: CodeReservationNotFound, Status: http.StatusNotFound, Message: "reservation not found"}
+
+// BlockReservation is a block of slots set aside for an event between From and To.
+// While it is active the slots are only given to vehicles parking under the reservation.
+type BlockReservation struct {
+	ID           int       `json:"id"`
+	ParkingLotID int       `json:"parkingLotID"`
+	SlotNumbers  []int     `json:"slotNumbers"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+}
+
+// ReserveBlock sets aside count slots of the lot's default vehicle type between from and to.
+// Slots in maintenance or reserved for an overlapping window are skipped, as are occupied
+// slots when the window has already started and, when holds come first, held slots.
+func (s *ParkingLotStorage) ReserveBlock(ctx context.Context, parkingLotID, count int, from, to time.Time) (*BlockReservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if count < 1 {
+		return nil, invalidRequest("count must be at least 1")
+	}
+	if from.IsZero() || to.IsZero() || !from.Before(to) {
+		return nil, invalidRequest("from must be before to")
+	}
+	now := s.now()
+	if !to.After(now) {
+		return nil, invalidRequest("reservation window has already ended")
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to reserve block")
+	}
+	defer tx.Rollback()
+
+	reservation := &BlockReservation{ParkingLotID: parkingLotID, From: from.UTC(), To: to.UTC()}
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(number ORDER BY number), '{}')
+		FROM (
+			SELECT number
+			FROM parking_spaces
+			WHERE lot_id = $1 AND vehicle_type = $2 AND NOT in_maintenance
+			AND ($3::timestamp > $5::timestamp OR NOT occupied)
+			AND (NOT $7 OR `+notHeld("$5")+`)
+			AND NOT EXISTS (
+				SELECT 1 FROM block_reservation_slots
+				JOIN block_reservations ON block_reservations.id = block_reservation_slots.reservation_id
+				WHERE block_reservations.lot_id = parking_spaces.lot_id AND block_reservation_slots.slot = parking_spaces.number
+				AND NOT block_reservations.released AND block_reservations.starts_at < $4 AND block_reservations.ends_at > $3
+			)
+			ORDER BY number
+			LIMIT $6
+			FOR UPDATE
+		) candidates
+	`, parkingLotID, lot.DefaultVehicleType, reservation.From, reservation.To, now, count, lot.SlotPriority == PriorityHoldsFirst).Scan(pq.Array(&reservation.SlotNumbers))
+	if err != nil {
+		return nil, internalError("failed to find slots to reserve")
+	}
+	if len(reservation.SlotNumbers) < count {
+		return nil, newError(CodeLotFull, http.StatusConflict, "only %d slots available to reserve", len(reservation.SlotNumbers))
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO block_reservations (lot_id, starts_at, ends_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, parkingLotID, reservation.From, reservation.To).Scan(&reservation.ID)
+	if err != nil {
+		return nil, internalError("failed to reserve block")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO block_reservation_slots (reservation_id, slot)
+		SELECT $1, unnest($2::int[])
+	`, reservation.ID, pq.Array(reservation.SlotNumbers))
+	if err != nil {
+		return nil, internalError("failed to reserve block")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to reserve block")
+	}
+	inLotZone(lot, &reservation.From, &reservation.To)
+
+	return reservation, nil
+}
+
+// ReleaseBlock gives the slots of a reservation back to walk-ins.
+func (s *ParkingLotStorage) ReleaseBlock(ctx context.Context, reservationID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE block_reservations SET released = true WHERE id = $1 AND NOT released", reservationID)
+	if err != nil {
+		return internalError("failed to release reservation")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
+
+// releaseEndedReservations marks reservations whose window has passed as released.
+// The caller must hold s.mu.
+func (s *ParkingLotStorage) releaseEndedReservations(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE block_reservations
+		SET released = true
+		WHERE NOT released AND ends_at <= $1
+	`, s.now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// notReserved returns a condition on parking_spaces excluding spaces in an active block
+// reservation other than the one bound to reservationParam, where nowParam is the
+// placeholder bound to the current time.
+func notReserved(nowParam, reservationParam string) string {
+	return `NOT EXISTS (
+		SELECT 1 FROM block_reservation_slots
+		JOIN block_reservations ON block_reservations.id = block_reservation_slots.reservation_id
+		WHERE block_reservations.lot_id = parking_spaces.lot_id AND block_reservation_slots.slot = parking_spaces.number
+		AND NOT block_reservations.released AND block_reservations.starts_at <= ` + nowParam + ` AND block_reservations.ends_at > ` + nowParam + `
+		AND block_reservations.id <> ` + reservationParam + `
+	)`
+}
+
+// inReservation returns a condition on parking_spaces limiting them to the slots of the
+// active block reservation bound to reservationParam, or allowing all spaces when it is 0.
+func inReservation(nowParam, reservationParam string) string {
+	return `(` + reservationParam + ` = 0 OR EXISTS (
+		SELECT 1 FROM block_reservation_slots
+		JOIN block_reservations ON block_reservations.id = block_reservation_slots.reservation_id
+		WHERE block_reservations.id = ` + reservationParam + `
+		AND block_reservations.lot_id = parking_spaces.lot_id AND block_reservation_slots.slot = parking_spaces.number
+		AND NOT block_reservations.released AND block_reservations.starts_at <= ` + nowParam + ` AND block_reservations.ends_at > ` + nowParam + `
+	))`
+}
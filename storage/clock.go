@@ -0,0 +1,28 @@
+package storage
+
+import "time"
+
+// Clock provides the current time to the storage layer so billing can be tested
+// with controlled entry and exit times.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// SetClock replaces the clock used for entry, exit and billing times.
+func (s *ParkingLotStorage) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = clock
+}
+
+// now returns the current time in UTC, matching how timestamps are stored.
+func (s *ParkingLotStorage) now() time.Time {
+	return s.clock.Now().UTC()
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"math"
+)
+
+// currencyMinorUnits is the number of decimal places of the minor unit of each supported
+// currency, e.g. cents for USD and none for JPY.
+var currencyMinorUnits = map[string]int{
+	"BDT": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"USD": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// minorUnits returns the decimal places of the currency's minor unit. Lots without a
+// currency charge the rates as they are, as if the currency had no minor unit.
+func minorUnits(currency string) int {
+	return currencyMinorUnits[currency]
+}
+
+// minorUnitScale is what the rates, given in whole currency units, are multiplied by to
+// charge fees as integers in the currency's minor unit.
+func minorUnitScale(currency string) int {
+	return int(math.Pow10(minorUnits(currency)))
+}
+
+// scaleFee converts a fee computed from the rates into the lot currency's minor unit.
+// Rates are whole amounts, so this is exact.
+func scaleFee(fee *FeeBreakdown, currency string) {
+	scale := minorUnitScale(currency)
+	if scale == 1 {
+		return
+	}
+
+	fee.Fee *= scale
+	if fee.UnclampedFee != nil {
+		unclamped := *fee.UnclampedFee * scale
+		fee.UnclampedFee = &unclamped
+	}
+	for i := range fee.Days {
+		fee.Days[i].Amount *= scale
+	}
+}
+
+// SetLotCurrency sets the currency the specified lot charges in. Its fees, and all other
+// amounts such as payments and validations, are then integers in that currency's minor
+// unit. The currency can only be set before the lot's first stay, so that stored fees
+// never mix units.
+func (s *ParkingLotStorage) SetLotCurrency(ctx context.Context, parkingLotID int, currency string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return invalidRequest("unsupported currency %q", currency)
+	}
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE parking_lots SET currency = $2
+		WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM parked_vehicles WHERE parking_lot_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM parking_transactions WHERE lot_id = $1)
+	`, parkingLotID, currency)
+	if err != nil {
+		return internalError("failed to set currency")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return invalidRequest("currency can only be set before the lot's first stay")
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
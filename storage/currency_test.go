@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScaleFee(t *testing.T) {
+	tests := []struct {
+		currency       string
+		wantMinorUnits int
+		wantScale      int
+	}{
+		{"", 0, 1},
+		{"JPY", 0, 1},
+		{"USD", 2, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			if n := minorUnits(tt.currency); n != tt.wantMinorUnits {
+				t.Errorf("minorUnits(%q) = %d, want %d", tt.currency, n, tt.wantMinorUnits)
+			}
+			if scale := minorUnitScale(tt.currency); scale != tt.wantScale {
+				t.Errorf("minorUnitScale(%q) = %d, want %d", tt.currency, scale, tt.wantScale)
+			}
+
+			unclamped := -5
+			fee := &FeeBreakdown{Fee: 30, UnclampedFee: &unclamped, Days: []DailyFee{{Amount: 10}, {Amount: 20}}}
+			scaleFee(fee, tt.currency)
+			if fee.Fee != 30*tt.wantScale || *fee.UnclampedFee != -5*tt.wantScale {
+				t.Errorf("scaled fee = %d, unclamped %d, want %d and %d", fee.Fee, *fee.UnclampedFee, 30*tt.wantScale, -5*tt.wantScale)
+			}
+			if fee.Days[0].Amount != 10*tt.wantScale || fee.Days[1].Amount != 20*tt.wantScale {
+				t.Errorf("scaled days = %+v, want %d and %d", fee.Days, 10*tt.wantScale, 20*tt.wantScale)
+			}
+		})
+	}
+}
+
+func TestUnparkVehicleChargesInMinorUnits(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+	}{
+		{"JPY", 30},
+		{"USD", 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			lot := testLot()
+			lot.Currency = tt.currency
+			s, db, clock := newFakeStorage(append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(lot))...)
+			clock.Advance(150 * time.Minute)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.want {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.want)
+			}
+
+			transaction, _ := db.last("INSERT INTO parking_transactions")
+			if recorded := transaction.args[3]; recorded != int64(tt.want) {
+				t.Errorf("recorded fee %v, want %d", recorded, tt.want)
+			}
+		})
+	}
+}
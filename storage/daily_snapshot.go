@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// dailyStatsColumns aggregates parking_transactions into the totals of DailyStats.
+const dailyStatsColumns = `
+	COUNT(*) AS total_vehicles,
+	COALESCE(SUM(EXTRACT(EPOCH FROM (parking_transactions.exit_time - parking_transactions.entry_time)) / 3600), 0) AS total_parking_time,
+	COALESCE(SUM(parking_transactions.fee), 0) AS total_fee,
+	COUNT(*) FILTER (WHERE parking_transactions.voided_at IS NOT NULL) AS voided_vehicles,
+	COUNT(*) FILTER (WHERE parking_transactions.employee) AS employee_vehicles
+`
+
+// StartDailySnapshots snapshots the report totals of every closed day not snapshotted yet
+// once a day, at the given offset from midnight UTC, until ctx is cancelled. Each lot's
+// totals are for the days in its own timezone, so a day is only closed once it has ended
+// in every timezone.
+// Snapshots of days older than retention are then dropped, and those days are computed
+// live again; zero keeps them forever.
+func (s *ParkingLotStorage) StartDailySnapshots(ctx context.Context, at, retention time.Duration) {
+	go func() {
+		for {
+			now := s.now().UTC()
+			next := startOfDay(now).Add(at)
+			if !next.After(now) {
+				next = startOfDay(now).AddDate(0, 0, 1).Add(at)
+			}
+
+			timer := time.NewTimer(next.Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			s.mu.Lock()
+			days, err := s.snapshotPendingDays(ctx)
+			var pruned int64
+			if err == nil && retention > 0 {
+				pruned, err = s.pruneDailyStats(ctx, retention)
+			}
+			s.mu.Unlock()
+			if err != nil {
+				log.Println("failed to snapshot daily stats:", err)
+				continue
+			}
+			if days > 0 {
+				log.Println("snapshotted daily stats for days:", days)
+			}
+			if pruned > 0 {
+				log.Println("dropped expired daily stats snapshots for days:", pruned)
+			}
+		}
+	}()
+}
+
+// BackfillDailyStats snapshots the report totals of the closed days from from up to but
+// not including to, replacing any existing snapshots of those days, and returns the
+// number of days snapshotted. Days that have not ended yet are left to be computed live.
+func (s *ParkingLotStorage) BackfillDailyStats(ctx context.Context, from, to time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from.IsZero() || to.IsZero() {
+		return 0, invalidRequest("from and to are required")
+	}
+	if !to.After(from) {
+		return 0, invalidRequest("to must be after from")
+	}
+
+	days, err := s.snapshotDays(ctx, startOfDay(from.UTC()), startOfDay(to.UTC()), true)
+	if err != nil {
+		return 0, internalError("failed to backfill daily stats")
+	}
+
+	return days, nil
+}
+
+// snapshotPendingDays snapshots the closed days after the last snapshotted one, or only
+// the last closed day when nothing has been snapshotted yet. The caller must hold s.mu.
+func (s *ParkingLotStorage) snapshotPendingDays(ctx context.Context) (int, error) {
+	open := s.firstOpenDay()
+
+	var from time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(day) + 1, $1::date) FROM daily_stats_days
+	`, open.AddDate(0, 0, -1)).Scan(&from)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.snapshotDays(ctx, startOfDay(from.UTC()), open, false)
+}
+
+// firstOpenDay returns the first day that may not have ended yet in every lot's timezone.
+// No timezone is more than a day behind UTC.
+func (s *ParkingLotStorage) firstOpenDay() time.Time {
+	return startOfDay(s.now().UTC()).AddDate(0, 0, -1)
+}
+
+// snapshotDays writes the report totals of every lot for the days in [from, to) that
+// have ended in every lot's timezone, and marks those days as snapshotted. Existing snapshots are kept unless
+// replace is set. The caller must hold s.mu.
+func (s *ParkingLotStorage) snapshotDays(ctx context.Context, from, to time.Time, replace bool) (int, error) {
+	if open := s.firstOpenDay(); to.After(open) {
+		to = open
+	}
+	if !to.After(from) {
+		return 0, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		_, err := tx.ExecContext(ctx, "DELETE FROM daily_stats WHERE day >= $1 AND day < $2", from, to)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO daily_stats (lot_id, day, include_voided, total_vehicles, total_parking_time, total_fee, voided_vehicles, employee_vehicles)
+		SELECT parking_transactions.lot_id, `+localDate("parking_transactions.exit_time", "parking_lots.timezone")+` AS day, flags.include_voided, `+dailyStatsColumns+`
+		FROM parking_transactions
+		JOIN parking_lots ON parking_lots.id = parking_transactions.lot_id
+		CROSS JOIN (VALUES (false), (true)) AS flags (include_voided)
+		WHERE parking_transactions.exit_time >= $1::timestamp - INTERVAL '1 day' AND parking_transactions.exit_time < $2::timestamp + INTERVAL '1 day'
+		AND `+localDate("parking_transactions.exit_time", "parking_lots.timezone")+` >= $1::date
+		AND `+localDate("parking_transactions.exit_time", "parking_lots.timezone")+` < $2::date
+		AND (flags.include_voided OR parking_transactions.voided_at IS NULL)
+		GROUP BY parking_transactions.lot_id, day, flags.include_voided
+		ON CONFLICT (lot_id, day, include_voided) DO NOTHING
+	`, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO daily_stats_days (day, snapshot_at)
+		SELECT generate_series($1::date, $2::date - 1, '1 day')::date, $3
+		ON CONFLICT (day) DO UPDATE SET snapshot_at = EXCLUDED.snapshot_at WHERE $4
+	`, from, to, s.now(), replace)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(to.Sub(from).Hours() / 24), nil
+}
+
+// pruneDailyStats drops the snapshots of days older than retention and returns how many
+// days were dropped. The caller must hold s.mu.
+func (s *ParkingLotStorage) pruneDailyStats(ctx context.Context, retention time.Duration) (int64, error) {
+	cutoff := startOfDay(s.now().UTC().Add(-retention))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM daily_stats WHERE day < $1", cutoff); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM daily_stats_days WHERE day < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// startOfDay returns midnight at the start of t's day in t's location.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// EmployeePlate is a license plate that parks free of charge in a lot.
+type EmployeePlate struct {
+	LicensePlate string    `json:"licensePlate"`
+	AddedAt      time.Time `json:"addedAt"`
+}
+
+// AddEmployeePlate lets the vehicle with the given plate park free in the specified lot.
+// Adding a plate that is already listed changes nothing.
+func (s *ParkingLotStorage) AddEmployeePlate(ctx context.Context, parkingLotID int, licensePlate string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := validateLicensePlate(licensePlate); err != nil {
+		return err
+	}
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO employee_plates (lot_id, license_plate, added_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, parkingLotID, licensePlate, s.now())
+	if err != nil {
+		return internalError("failed to add employee plate")
+	}
+
+	return nil
+}
+
+// RemoveEmployeePlate charges the vehicle with the given plate again in the specified lot.
+func (s *ParkingLotStorage) RemoveEmployeePlate(ctx context.Context, parkingLotID int, licensePlate string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM employee_plates WHERE lot_id = $1 AND license_plate = $2", parkingLotID, licensePlate)
+	if err != nil {
+		return internalError("failed to remove employee plate")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newError(CodeVehicleNotFound, http.StatusNotFound, "employee plate not found")
+	}
+
+	return nil
+}
+
+// GetEmployeePlates returns the employee plates of the specified lot, ordered by plate.
+func (s *ParkingLotStorage) GetEmployeePlates(ctx context.Context, parkingLotID int) ([]*EmployeePlate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT license_plate, added_at
+		FROM employee_plates
+		WHERE lot_id = $1
+		ORDER BY license_plate
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve employee plates")
+	}
+	defer rows.Close()
+
+	plates := []*EmployeePlate{}
+	for rows.Next() {
+		var plate EmployeePlate
+		if err := rows.Scan(&plate.LicensePlate, &plate.AddedAt); err != nil {
+			return nil, internalError("failed to read employee plates")
+		}
+		inLotZone(lot, &plate.AddedAt)
+		plates = append(plates, &plate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing employee plates")
+	}
+
+	return plates, nil
+}
+
+// employeeFee waives the fee of a stay by a vehicle on the lot's employee plate list.
+// Other stays keep their fee.
+func employeeFee(ctx context.Context, q querier, stay *parkedStay, fee *FeeBreakdown) (*FeeBreakdown, error) {
+	var employee bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM employee_plates WHERE lot_id = $1 AND license_plate = $2)
+	`, stay.ParkingLotID, stay.LicensePlate).Scan(&employee)
+	if err != nil {
+		return nil, internalError("failed to look up employee plates")
+	}
+	if !employee {
+		return fee, nil
+	}
+
+	return &FeeBreakdown{Employee: true}, nil
+}
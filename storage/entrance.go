@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SetEntranceDistances records how far each slot of the specified lot is from an entrance,
+// replacing the distances previously recorded for it.
+func (s *ParkingLotStorage) SetEntranceDistances(ctx context.Context, parkingLotID int, entranceID string, distances map[int]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entranceID == "" {
+		return invalidRequest("entrance id is required")
+	}
+	if len(distances) == 0 {
+		return invalidRequest("no distances given")
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return err
+	}
+	for slot, distance := range distances {
+		if slot < 1 || slot > lot.TotalSpaces {
+			return invalidRequest("slot %d is not in the lot", slot)
+		}
+		if distance < 0 {
+			return invalidRequest("distance of slot %d must not be negative", slot)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError("failed to set entrance distances")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM slot_entrance_distances WHERE lot_id = $1 AND entrance_id = $2", parkingLotID, entranceID)
+	if err != nil {
+		return internalError("failed to set entrance distances")
+	}
+
+	for slot, distance := range distances {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO slot_entrance_distances (lot_id, entrance_id, slot, distance)
+			VALUES ($1, $2, $3, $4)
+		`, parkingLotID, entranceID, slot, distance)
+		if err != nil {
+			return internalError("failed to set entrance distances")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return internalError("failed to set entrance distances")
+	}
+
+	return nil
+}
+
+// checkEntranceExists fails when no slot distances are recorded for the entrance.
+func (s *ParkingLotStorage) checkEntranceExists(ctx context.Context, parkingLotID int, entranceID string) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM slot_entrance_distances WHERE lot_id = $1 AND entrance_id = $2)
+	`, parkingLotID, entranceID).Scan(&exists)
+	if err != nil {
+		return internalError("failed to look up entrance")
+	}
+	if !exists {
+		return invalidRequest("unknown entrance %q", entranceID)
+	}
+	return nil
+}
+
+// entranceDistance returns the distance of a slot from an entrance, or nil when none is recorded.
+func entranceDistance(ctx context.Context, q querier, parkingLotID int, entranceID string, slot int) (*int, error) {
+	var distance int
+	err := q.QueryRowContext(ctx, `
+		SELECT distance FROM slot_entrance_distances
+		WHERE lot_id = $1 AND entrance_id = $2 AND slot = $3
+	`, parkingLotID, entranceID, slot).Scan(&distance)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &distance, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is a machine-readable identifier for a ParkingError.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest  ErrorCode = "INVALID_REQUEST"
+	CodeLotNotFound     ErrorCode = "LOT_NOT_FOUND"
+	CodeLotFull         ErrorCode = "LOT_FULL"
+	CodeVehicleNotFound ErrorCode = "VEHICLE_NOT_FOUND"
+	CodeHoldNotFound    ErrorCode = "HOLD_NOT_FOUND"
+	CodeTicketNotFound  ErrorCode = "TICKET_NOT_FOUND"
+	CodeTicketClosed    ErrorCode = "TICKET_CLOSED"
+	CodeUnauthorized    ErrorCode = "UNAUTHORIZED"
+	CodeInternal        ErrorCode = "INTERNAL"
+
+	CodeTransactionNotFound ErrorCode = "TRANSACTION_NOT_FOUND"
+	CodeReservationNotFound ErrorCode = "RESERVATION_NOT_FOUND"
+	CodeLotClosed           ErrorCode = "LOT_CLOSED"
+	CodeWouldExceedClosing  ErrorCode = "WOULD_EXCEED_CLOSING"
+
+	CodeOrganizationNotFound ErrorCode = "ORGANIZATION_NOT_FOUND"
+	CodeForbidden            ErrorCode = "FORBIDDEN"
+	CodeExitNotPending       ErrorCode = "EXIT_NOT_PENDING"
+	CodeAlreadyRefunded      ErrorCode = "ALREADY_REFUNDED"
+	CodeValidationNotFound   ErrorCode = "VALIDATION_NOT_FOUND"
+	CodePlateParkedElsewhere ErrorCode = "PLATE_PARKED_ELSEWHERE"
+	CodeAlreadyUnparked      ErrorCode = "ALREADY_UNPARKED"
+	CodeStatementTimeout     ErrorCode = "STATEMENT_TIMEOUT"
+)
+
+// ParkingError is the error type returned by the storage layer.
+// It carries a code clients can branch on and the HTTP status it maps to.
+type ParkingError struct {
+	Code    ErrorCode `json:"code"`
+	Status  int       `json:"-"`
+	Message string    `json:"message"`
+}
+
+func (e *ParkingError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is a ParkingError with the same code, so errors.Is
+// matches the sentinels below regardless of the message.
+func (e *ParkingError) Is(target error) bool {
+	t, ok := target.(*ParkingError)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrLotNotFound     = &ParkingError{Code: CodeLotNotFound, Status: http.StatusNotFound, Message: "parking lot not found"}
+	ErrLotFull         = &ParkingError{Code: CodeLotFull, Status: http.StatusConflict, Message: "nearest available slot not found"}
+	ErrVehicleNotFound = &ParkingError{Code: CodeVehicleNotFound, Status: http.StatusNotFound, Message: "required parked vehicle lot not found"}
+	ErrHoldNotFound    = &ParkingError{Code: CodeHoldNotFound, Status: http.StatusNotFound, Message: "hold not found or expired"}
+)
+
+func newError(code ErrorCode, status int, format string, args ...interface{}) *ParkingError {
+	return &ParkingError{Code: code, Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+func invalidRequest(format string, args ...interface{}) *ParkingError {
+	return newError(CodeInvalidRequest, http.StatusBadRequest, format, args...)
+}
+
+func internalError(message string) *ParkingError {
+	return &ParkingError{Code: CodeInternal, Status: http.StatusInternalServerError, Message: message}
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// testTime is the time the fake clock of a fake storage starts at.
+var testTime = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// fakeResult is the canned answer of a fakeDB to the statements containing match: the
+// rows of a query, or the number of rows affected by any other statement. A result with
+// times set only answers that many statements.
+type fakeResult struct {
+	match        string
+	rows         [][]driver.Value
+	rowsAffected int64
+	times        int
+}
+
+// fakeStatement is a statement executed against a fakeDB.
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeDB is a database/sql connector answering statements with canned results, so storage
+// logic can be tested without a database. Each statement is answered by the first result
+// whose match it contains, and fails when there is none.
+type fakeDB struct {
+	mu         sync.Mutex
+	results    []fakeResult
+	used       map[int]int
+	statements []fakeStatement
+}
+
+// newFakeStorage returns a storage backed by a fakeDB answering with results and a fake
+// clock stopped at testTime.
+func newFakeStorage(results ...fakeResult) (*ParkingLotStorage, *fakeDB, *fakeClock) {
+	db := &fakeDB{results: results}
+	clock := newFakeClock(testTime)
+	return &ParkingLotStorage{db: sql.OpenDB(db), clock: clock, lots: newLotCache()}, db, clock
+}
+
+// count returns how many of the statements executed so far contain match.
+func (db *fakeDB) count(match string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := 0
+	for _, statement := range db.statements {
+		if strings.Contains(statement.query, match) {
+			n++
+		}
+	}
+	return n
+}
+
+// last returns the last statement executed that contains match.
+func (db *fakeDB) last(match string) (fakeStatement, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := len(db.statements) - 1; i >= 0; i-- {
+		if strings.Contains(db.statements[i].query, match) {
+			return db.statements[i], true
+		}
+	}
+	return fakeStatement{}, false
+}
+
+func (db *fakeDB) answer(query string, args []driver.NamedValue) (fakeResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	statement := fakeStatement{query: query}
+	for _, arg := range args {
+		statement.args = append(statement.args, arg.Value)
+	}
+	db.statements = append(db.statements, statement)
+
+	for i, result := range db.results {
+		if !strings.Contains(query, result.match) {
+			continue
+		}
+		if result.times > 0 {
+			if db.used == nil {
+				db.used = make(map[int]int)
+			}
+			if db.used[i] == result.times {
+				continue
+			}
+			db.used[i]++
+		}
+		return result, nil
+	}
+	return fakeResult{}, fmt.Errorf("unexpected statement: %s", query)
+}
+
+// Connect implements driver.Connector.
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+// Driver implements driver.Connector.
+func (db *fakeDB) Driver() driver.Driver {
+	return fakeDriver{db}
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: result.rows}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.rowsAffected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// testLot is the metadata of a lot of ten car slots in UTC under the flat fee strategy.
+func testLot() lotMeta {
+	return lotMeta{
+		TotalSpaces:        10,
+		DefaultVehicleType: DefaultVehicleType,
+		AllocationStrategy: StrategyNearest,
+		FeeStrategy:        FeeStrategyFlat,
+		NegativeFeePolicy:  NegativeFeeClamp,
+		SlotPriority:       PriorityReservationsFirst,
+		Location:           time.UTC,
+	}
+}
+
+// lotResult answers the lot metadata query with lot.
+func lotResult(lot lotMeta) fakeResult {
+	return fakeResult{match: "SELECT total_spaces, default_vehicle_type", rows: [][]driver.Value{{
+		int64(lot.TotalSpaces), lot.DefaultVehicleType, lot.AllocationStrategy, lot.FeeStrategy, lot.NegativeFeePolicy, int64(lot.OverflowLotID), nullBool(lot.MaintenanceGrace),
+		nullInt(lot.OpensAtMinute), nullInt(lot.ClosesAtMinute), int64(lot.LastCallMinutes), int64(lot.OrgID), lot.SlotPriority, lot.Currency, lot.Location.String(),
+	}}}
+}
+
+func nullBool(b *bool) driver.Value {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+func nullInt(n *int) driver.Value {
+	if n == nil {
+		return nil
+	}
+	return int64(*n)
+}
+
+// parkResults answers the statements of parking a vehicle in slot 1.
+func parkResults() []fakeResult {
+	return []fakeResult{
+		{match: "WHERE license_plate = $1 AND exit_time IS NULL AND parking_lot_id <> $2"},
+		{match: "WITH free_spaces", rows: [][]driver.Value{{int64(1)}}},
+		{match: "SET occupied = true", rowsAffected: 1},
+		{match: "INSERT INTO slot_state_changes", rowsAffected: 1},
+		{match: "SET last_assigned_slot", rowsAffected: 1},
+		{match: "INSERT INTO parked_vehicles", rows: [][]driver.Value{{testTime}}},
+	}
+}
+
+// parkedStayRow is a parked_vehicles row of a one-slot stay as read by scanParkedStay.
+func parkedStayRow(parkingLotID int, licensePlate string, slot int, entryTime time.Time) []driver.Value {
+	return []driver.Value{int64(1), int64(parkingLotID), licensePlate, int64(slot), int64(1),
+		"ticket", DefaultVehicleType, entryTime, nil,
+		int64(0), nil, nil, "",
+		""}
+}
+
+// unparkResults answers the statements of unparking the vehicle of stay, which was not
+// validated and is not an employee's.
+func unparkResults(stay []driver.Value) []fakeResult {
+	return []fakeResult{
+		{match: "ORDER BY parked_vehicles.id DESC LIMIT 1", rows: [][]driver.Value{stay}},
+		{match: "UPDATE parked_vehicles SET exit_time", rowsAffected: 1},
+		{match: "SET occupied = false", rows: [][]driver.Value{{stay[3]}}},
+		{match: "INSERT INTO slot_state_changes", rowsAffected: 1},
+		{match: "UPDATE parking_lots SET version", rowsAffected: 1},
+		{match: "FROM fee_strategy_changes"},
+		{match: "FROM employee_plates", rows: [][]driver.Value{{false}}},
+		{match: "INSERT INTO parking_transactions", rowsAffected: 1},
+	}
+}
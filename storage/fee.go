@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// DailyFee is the part of a fee charged for one calendar day of a stay.
+type DailyFee struct {
+	Date   string  `json:"date"`
+	Hours  float64 `json:"hours"`
+	Amount int     `json:"amount"`
+}
+
+// FeeBreakdown is a parking fee together with its per-day breakdown.
+// Days is only set when the stay spans more than one calendar day.
+// For prepaid stays Refund is the unused part of the prepayment returned on an early
+// exit, and AmountDue what is still owed on a late one.
+// UnclampedFee is only set when a negative fee was raised to zero by the lot's policy.
+// PendingExit is set while the vehicle still holds its slot awaiting ConfirmExit.
+// Employee is set when the fee was waived for an employee plate.
+// For validated stays Validated is the part of the fee paid by the merchant and
+// CustomerFee the rest, which is what prepayments are set off against.
+// AlreadyUnparked is set when the fee is the one charged by an earlier unpark.
+type FeeBreakdown struct {
+	Fee          int          `json:"fee"`
+	UnclampedFee *int         `json:"unclampedFee,omitempty"`
+	Days         []DailyFee   `json:"days,omitempty"`
+	Prepaid      int          `json:"prepaid,omitempty"`
+	Refund       int          `json:"refund,omitempty"`
+	AmountDue    int          `json:"amountDue,omitempty"`
+	PendingExit  *PendingExit `json:"pendingExit,omitempty"`
+	Employee     bool         `json:"employee,omitempty"`
+
+	ValidationCode string `json:"validationCode,omitempty"`
+	Validated      int    `json:"validated,omitempty"`
+	CustomerFee    int    `json:"customerFee,omitempty"`
+
+	AlreadyUnparked bool `json:"alreadyUnparked,omitempty"`
+}
+
+// parkingFee calculates the fee for a vehicle occupying slotsRequired slots starting at
+// firstSlot between entryTime and exitTime, using the lot's fee strategy.
+func (s *ParkingLotStorage) parkingFee(ctx context.Context, q querier, parkingLotID, firstSlot, slotsRequired int, entryTime, exitTime time.Time) (*FeeBreakdown, error) {
+	inputs, err := s.loadFeeInputs(ctx, q, parkingLotID, entryTime, exitTime)
+	if err != nil {
+		return nil, err
+	}
+	return inputs.fee(ctx, firstSlot, slotsRequired, entryTime, exitTime)
+}
+
+// stayFee charges a stay until exitTime as unparking does: the parking fee, waived for
+// employees, with the part covered by its validation split off and its prepayment settled.
+func (s *ParkingLotStorage) stayFee(ctx context.Context, q querier, stay *parkedStay, exitTime time.Time) (*FeeBreakdown, error) {
+	fee, err := s.parkingFee(ctx, q, stay.ParkingLotID, stay.FirstSlot, stay.SlotsRequired, stay.EntryTime, exitTime)
+	if err != nil {
+		return nil, err
+	}
+	fee, err = employeeFee(ctx, q, stay, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyValidation(ctx, q, stay, fee, exitTime); err != nil {
+		return nil, err
+	}
+	settlePrepayment(fee, stay.PrepaidAmount, stay.PrepaidUntil.Time, exitTime)
+
+	return fee, nil
+}
+
+// feeInputs is what the fees of stays in a lot during a period are calculated from besides
+// the stays themselves. They are read once so that any number of stays in the period can
+// be charged without further queries.
+type feeInputs struct {
+	parkingLotID int
+	lot          lotMeta
+	changes      changingFeeStrategy
+	// windows are the out-of-service windows of the lot's slots, only read when the lot
+	// waives maintenance.
+	windows []slotWindow
+}
+
+// loadFeeInputs reads the fee inputs of the specified lot for stays between from and to.
+func (s *ParkingLotStorage) loadFeeInputs(ctx context.Context, q querier, parkingLotID int, from, to time.Time) (*feeInputs, error) {
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := feeStrategyChanges(ctx, q, parkingLotID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := &feeInputs{parkingLotID: parkingLotID, lot: lot, changes: changes}
+	if s.maintenanceGraceFor(lot) {
+		inputs.windows, err = maintenanceWindows(ctx, q, parkingLotID, from, to)
+		if err != nil {
+			return nil, internalError("failed to read maintenance windows")
+		}
+	}
+
+	return inputs, nil
+}
+
+// fee calculates the fee for a vehicle occupying slotsRequired slots starting at firstSlot
+// between entryTime and exitTime, which must lie within the period the inputs were read for.
+func (in *feeInputs) fee(ctx context.Context, firstSlot, slotsRequired int, entryTime, exitTime time.Time) (*FeeBreakdown, error) {
+	strategy, err := in.strategyDuring(entryTime, exitTime)
+	if err != nil {
+		return nil, err
+	}
+
+	stay := Stay{ParkingLotID: in.parkingLotID, SlotsRequired: slotsRequired, EntryTime: entryTime, ExitTime: exitTime, Location: in.lot.Location}
+	stay.excluded = windowsOfSlots(in.windows, firstSlot, slotsRequired)
+
+	fee, err := strategy.Compute(ctx, stay)
+	if err != nil {
+		return nil, err
+	}
+	scaleFee(fee, in.lot.Currency)
+	applyNegativeFeePolicy(fee, in.lot.NegativeFeePolicy)
+
+	return fee, nil
+}
+
+// strategyDuring returns the fee strategy to charge a stay between entryTime and exitTime
+// with: the one in effect during the stay, or one following the lot's changes of strategy
+// when it changed during the stay. Lots without a recorded history use their current
+// strategy.
+func (in *feeInputs) strategyDuring(entryTime, exitTime time.Time) (FeeStrategy, error) {
+	start, end := 0, 0
+	for end < len(in.changes) && in.changes[end].EffectiveFrom.Before(exitTime) {
+		if !in.changes[end].EffectiveFrom.After(entryTime) {
+			start = end
+		}
+		end++
+	}
+
+	switch changes := in.changes[start:end]; len(changes) {
+	case 0:
+	case 1:
+		return changes[0].Strategy, nil
+	default:
+		return changes, nil
+	}
+
+	strategy, ok := lookupFeeStrategy(in.lot.FeeStrategy)
+	if !ok {
+		return nil, internalError("unknown fee strategy " + in.lot.FeeStrategy)
+	}
+	return strategy, nil
+}
+
+// feeStrategyChanges returns the changes of the lot's fee strategy in effect at some time
+// between from and to, in the order they took effect.
+func feeStrategyChanges(ctx context.Context, q querier, parkingLotID int, from, to time.Time) (changingFeeStrategy, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT fee_strategy, effective_from
+		FROM fee_strategy_changes
+		WHERE lot_id = $1 AND effective_from < $3
+		AND effective_from >= COALESCE((
+			SELECT MAX(effective_from) FROM fee_strategy_changes
+			WHERE lot_id = $1 AND effective_from <= $2
+		), 'epoch')
+		ORDER BY effective_from, id
+	`, parkingLotID, from, to)
+	if err != nil {
+		return nil, internalError("failed to read fee strategy changes")
+	}
+	defer rows.Close()
+
+	var changes changingFeeStrategy
+	for rows.Next() {
+		var name string
+		var change feeStrategyChange
+		if err := rows.Scan(&name, &change.EffectiveFrom); err != nil {
+			return nil, internalError("failed to read fee strategy changes")
+		}
+		strategy, ok := lookupFeeStrategy(name)
+		if !ok {
+			return nil, internalError("unknown fee strategy " + name)
+		}
+		change.Strategy = strategy
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing fee strategy changes")
+	}
+
+	return changes, nil
+}
+
+// QuoteFee computes what a one-slot stay of the given length starting now would cost in
+// the specified lot under its fee strategy and negative fee policy. Nothing is recorded.
+func (s *ParkingLotStorage) QuoteFee(ctx context.Context, parkingLotID int, durationMinutes int) (*FeeBreakdown, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if durationMinutes < 0 {
+		return nil, invalidRequest("duration must not be negative")
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	strategy, ok := lookupFeeStrategy(lot.FeeStrategy)
+	if !ok {
+		return nil, internalError("unknown fee strategy " + lot.FeeStrategy)
+	}
+
+	now := s.now()
+	stay := Stay{ParkingLotID: parkingLotID, SlotsRequired: 1, EntryTime: now, ExitTime: now.Add(time.Duration(durationMinutes) * time.Minute), Location: lot.Location}
+	fee, err := strategy.Compute(ctx, stay)
+	if err != nil {
+		return nil, err
+	}
+	scaleFee(fee, lot.Currency)
+	applyNegativeFeePolicy(fee, lot.NegativeFeePolicy)
+
+	return fee, nil
+}
+
+// applyNegativeFeePolicy raises a negative fee to zero unless the policy keeps it as a credit.
+func applyNegativeFeePolicy(fee *FeeBreakdown, policy string) {
+	if fee.Fee >= 0 || policy == NegativeFeeCredit {
+		return
+	}
+
+	unclamped := fee.Fee
+	fee.UnclampedFee = &unclamped
+	fee.Fee = 0
+}
+
+// feeBeforeClamp returns the fee as computed before the negative fee policy was applied.
+func (f *FeeBreakdown) feeBeforeClamp() int {
+	if f.UnclampedFee != nil {
+		return *f.UnclampedFee
+	}
+	return f.Fee
+}
+
+// feeBreakdown charges rate(hourStart) per started hour of the stay between entryTime and
+// exitTime, not counting the excluded windows. Each calendar day is charged for the
+// hours that start during that day, so the days add up to the fee.
+func feeBreakdown(entryTime, exitTime time.Time, excluded []timeWindow, rate func(hourStart time.Time) int) *FeeBreakdown {
+	breakdown := &FeeBreakdown{}
+
+	billable := billableWindows(entryTime, exitTime, excluded)
+	var billed time.Duration
+	for _, window := range billable {
+		billed += window.End.Sub(window.Start)
+	}
+	hourStarts := billableHourStarts(billable, startedHours(billed))
+
+	for dayStart := entryTime; dayStart.Before(exitTime); {
+		dayEnd := time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day()+1, 0, 0, 0, 0, dayStart.Location())
+		if dayEnd.After(exitTime) {
+			dayEnd = exitTime
+		}
+
+		duration := dayEnd.Sub(dayStart) - maintenanceOverlap(dayStart, dayEnd, excluded)
+		day := DailyFee{Date: dayStart.Format("2006-01-02"), Hours: duration.Hours()}
+		for len(hourStarts) > 0 && hourStarts[0].Before(dayEnd) {
+			day.Amount += rate(hourStarts[0])
+			hourStarts = hourStarts[1:]
+		}
+
+		breakdown.Fee += day.Amount
+		breakdown.Days = append(breakdown.Days, day)
+		dayStart = dayEnd
+	}
+
+	if len(breakdown.Days) <= 1 {
+		breakdown.Days = nil
+	}
+
+	return breakdown
+}
+
+// settlePrepayment sets off a prepayment made for a stay until prepaidUntil against the
+// fee for the actual stay. Leaving before prepaidUntil refunds the unused part, while
+// leaving after it leaves the fee beyond the prepayment due.
+func settlePrepayment(fee *FeeBreakdown, prepaidAmount int, prepaidUntil, exitTime time.Time) {
+	if prepaidAmount == 0 {
+		return
+	}
+
+	owed := fee.Fee - fee.Validated
+	fee.Prepaid = prepaidAmount
+	if !exitTime.After(prepaidUntil) {
+		if owed < prepaidAmount {
+			fee.Refund = prepaidAmount - owed
+		}
+		return
+	}
+	if owed > prepaidAmount {
+		fee.AmountDue = owed - prepaidAmount
+	}
+}
+
+// billableHourStarts returns the times at which each of the first n hours of billable
+// time in the given windows begins.
+func billableHourStarts(billable []timeWindow, n int) []time.Time {
+	starts := make([]time.Time, 0, n)
+
+	var billed time.Duration
+	for _, window := range billable {
+		length := window.End.Sub(window.Start)
+		for len(starts) < n {
+			next := time.Duration(len(starts)) * time.Hour
+			if next >= billed+length {
+				break
+			}
+			starts = append(starts, window.Start.Add(next-billed))
+		}
+		billed += length
+	}
+
+	return starts
+}
+
+// zeroStayThreshold is the billable time below which a stay is charged nothing, so that a
+// vehicle parked and unparked again right away, e.g. after a misread plate, pays no fee.
+// Longer stays are charged at least one started hour.
+const zeroStayThreshold = time.Minute
+
+// startedHours returns the number of started hours in d, or none when d is shorter than
+// zeroStayThreshold.
+func startedHours(d time.Duration) int {
+	if d < zeroStayThreshold {
+		return 0
+	}
+	return int(math.Ceil(d.Hours()))
+}
+
+// nextIncrementHorizon is how far ahead nextFeeIncrement looks for the fee to go up.
+const nextIncrementHorizon = 25 * time.Hour
+
+// nextFeeIncrement returns when the fee of a stay that started at entryTime next goes up
+// after now, and by how much: the rate of the next started hour of billable time, in the
+// lot's currency. It returns false when that hour does not start within
+// nextIncrementHorizon, or when the lot's strategy does not charge hours on their own.
+func (s *ParkingLotStorage) nextFeeIncrement(ctx context.Context, q querier, parkingLotID, firstSlot, slotsRequired int, entryTime, now time.Time) (time.Time, int, bool, error) {
+	horizon := now.Add(nextIncrementHorizon)
+	inputs, err := s.loadFeeInputs(ctx, q, parkingLotID, entryTime, horizon)
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+	strategy, err := inputs.strategyDuring(entryTime, horizon)
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+
+	stay := Stay{ParkingLotID: parkingLotID, SlotsRequired: slotsRequired, EntryTime: entryTime, ExitTime: horizon, Location: inputs.lot.Location}
+	stay.excluded = windowsOfSlots(inputs.windows, firstSlot, slotsRequired)
+	rate, ok := hourlyRate(strategy, stay)
+	if !ok {
+		return time.Time{}, 0, false, nil
+	}
+
+	var billed time.Duration
+	for _, window := range billableWindows(entryTime, now, stay.excluded) {
+		billed += window.End.Sub(window.Start)
+	}
+	charged := startedHours(billed)
+	hourStarts := billableHourStarts(billableWindows(entryTime, horizon, stay.excluded), charged+1)
+	if len(hourStarts) <= charged {
+		return time.Time{}, 0, false, nil
+	}
+
+	// The fee goes up once the stay is billed for longer than the hours charged so far,
+	// which is when the next hour starts, except that the first hour is only charged
+	// once the stay is billed for zeroStayThreshold.
+	hi := hourStarts[charged]
+	switch {
+	case charged > 0:
+	case billed > 0:
+		hi = now.Add(zeroStayThreshold - billed)
+	default:
+		hi = hi.Add(zeroStayThreshold)
+	}
+	if hi.After(horizon) {
+		return time.Time{}, 0, false, nil
+	}
+
+	return hi, rate(hourStarts[charged]) * minorUnitScale(inputs.lot.Currency), true, nil
+}
@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Names of the built-in fee strategies.
+const (
+	FeeStrategyFlat          = "flat"
+	FeeStrategyPeak          = "peak"
+	FeeStrategyDailyBoundary = "daily_boundary"
+)
+
+// Policies for fees that come out negative, e.g. when a discount exceeds the base fee.
+// Under NegativeFeeClamp they are raised to zero; under NegativeFeeCredit they are
+// kept and owed to the customer.
+const (
+	NegativeFeeClamp  = "clamp"
+	NegativeFeeCredit = "credit"
+)
+
+// Default peak pricing: a higher hourly rate for hours starting between 08:00 and 18:00
+// in the lot's timezone.
+const (
+	ParkingFeePeakHour = 15
+	peakStartHour      = 8
+	peakEndHour        = 18
+)
+
+// ParkingFeePerCalendarDay is the default rate of the daily boundary strategy.
+const ParkingFeePerCalendarDay = 100
+
+// Stay is a completed or ongoing stay of a vehicle to be charged for.
+type Stay struct {
+	ParkingLotID  int
+	SlotsRequired int
+	EntryTime     time.Time
+	ExitTime      time.Time
+	// Location is the lot's timezone, whose calendar days the stay is broken down into.
+	// A nil Location means UTC.
+	Location *time.Location
+
+	// excluded are windows the stay is not charged for, such as maintenance under grace.
+	excluded []timeWindow
+}
+
+// Breakdown charges rate(hourStart) per started billable hour of the stay, where
+// hourStart is the time that hour began.
+func (stay Stay) Breakdown(rate func(hourStart time.Time) int) *FeeBreakdown {
+	return feeBreakdown(stay.EntryTime.In(stay.location()), stay.ExitTime.In(stay.location()), stay.excluded, rate)
+}
+
+// location returns the stay's Location, or UTC when it has none.
+func (stay Stay) location() *time.Location {
+	if stay.Location == nil {
+		return time.UTC
+	}
+	return stay.Location
+}
+
+// FeeStrategy computes the fee for a stay.
+type FeeStrategy interface {
+	Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error)
+}
+
+// HourlyRater is implemented by fee strategies that charge each started hour on its own.
+// A stay spanning a change between such strategies is charged each hour by the strategy
+// in effect when that hour started.
+type HourlyRater interface {
+	Rate(stay Stay, hourStart time.Time) int
+}
+
+// hourlyRate returns the rate of each started hour of the stay under strategy, and false
+// when the strategy does not charge hours on their own.
+func hourlyRate(strategy FeeStrategy, stay Stay) (func(hourStart time.Time) int, bool) {
+	switch strategy := strategy.(type) {
+	case HourlyRater:
+		return func(hourStart time.Time) int {
+			return strategy.Rate(stay, hourStart)
+		}, true
+	case changingFeeStrategy:
+		return strategy.rate(stay)
+	}
+	return nil, false
+}
+
+// FlatFeeStrategy charges the same rate per slot for every started hour.
+type FlatFeeStrategy struct {
+	HourlyRate int
+}
+
+// Compute implements FeeStrategy.
+func (f FlatFeeStrategy) Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	return stay.Breakdown(func(hourStart time.Time) int {
+		return f.Rate(stay, hourStart)
+	}), nil
+}
+
+// Rate implements HourlyRater.
+func (f FlatFeeStrategy) Rate(stay Stay, hourStart time.Time) int {
+	return f.HourlyRate * stay.SlotsRequired
+}
+
+// PeakFeeStrategy charges PeakHourlyRate per slot for hours starting between PeakStartHour
+// and PeakEndHour in the stay's Location, and HourlyRate for all other hours.
+type PeakFeeStrategy struct {
+	HourlyRate     int
+	PeakHourlyRate int
+	PeakStartHour  int
+	PeakEndHour    int
+}
+
+// Compute implements FeeStrategy.
+func (p PeakFeeStrategy) Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	return stay.Breakdown(func(hourStart time.Time) int {
+		return p.Rate(stay, hourStart)
+	}), nil
+}
+
+// Rate implements HourlyRater.
+func (p PeakFeeStrategy) Rate(stay Stay, hourStart time.Time) int {
+	hour := hourStart.In(stay.location()).Hour()
+	if hour >= p.PeakStartHour && hour < p.PeakEndHour {
+		return p.PeakHourlyRate * stay.SlotsRequired
+	}
+	return p.HourlyRate * stay.SlotsRequired
+}
+
+// CalendarDayFeeStrategy charges DailyRate per slot for every calendar day in Location
+// the stay touches, however little of it, so a stay from 23:00 to 01:00 is charged two
+// days. Stays shorter than zeroStayThreshold are not charged. A nil Location means the
+// timezone of the stay's lot.
+type CalendarDayFeeStrategy struct {
+	DailyRate int
+	Location  *time.Location
+}
+
+// Compute implements FeeStrategy.
+func (c CalendarDayFeeStrategy) Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	location := c.Location
+	if location == nil {
+		location = stay.location()
+	}
+
+	breakdown := &FeeBreakdown{}
+	if stay.ExitTime.Sub(stay.EntryTime)-maintenanceOverlap(stay.EntryTime, stay.ExitTime, stay.excluded) < zeroStayThreshold {
+		return breakdown, nil
+	}
+
+	entryTime, exitTime := stay.EntryTime.In(location), stay.ExitTime.In(location)
+	for dayStart := entryTime; dayStart.Before(exitTime); {
+		dayEnd := time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day()+1, 0, 0, 0, 0, location)
+		if dayEnd.After(exitTime) {
+			dayEnd = exitTime
+		}
+
+		duration := dayEnd.Sub(dayStart) - maintenanceOverlap(dayStart, dayEnd, stay.excluded)
+		day := DailyFee{Date: dayStart.Format("2006-01-02"), Hours: duration.Hours()}
+		if duration > 0 {
+			day.Amount = c.DailyRate * stay.SlotsRequired
+		}
+
+		breakdown.Fee += day.Amount
+		breakdown.Days = append(breakdown.Days, day)
+		dayStart = dayEnd
+	}
+
+	if len(breakdown.Days) <= 1 {
+		breakdown.Days = nil
+	}
+
+	return breakdown, nil
+}
+
+// feeStrategyChange is a fee strategy taking effect in a lot.
+type feeStrategyChange struct {
+	Strategy      FeeStrategy
+	EffectiveFrom time.Time
+}
+
+// changingFeeStrategy charges a stay under the strategies in effect during it, which are
+// ordered by the time they took effect. When all of them rate single hours each started
+// hour of the stay is charged by the strategy in effect when it started. Otherwise the
+// stay is split at each change and every part is charged by the strategy in effect then.
+type changingFeeStrategy []feeStrategyChange
+
+// Compute implements FeeStrategy.
+func (c changingFeeStrategy) Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	rate, ok := c.rate(stay)
+	if !ok {
+		return c.computeSegments(ctx, stay)
+	}
+	return stay.Breakdown(rate), nil
+}
+
+// rate returns the rate of each started hour of the stay under the strategy in effect when
+// it started, and false unless all the strategies rate single hours.
+func (c changingFeeStrategy) rate(stay Stay) (func(hourStart time.Time) int, bool) {
+	raters := make([]HourlyRater, len(c))
+	for i, change := range c {
+		rater, ok := change.Strategy.(HourlyRater)
+		if !ok {
+			return nil, false
+		}
+		raters[i] = rater
+	}
+
+	return func(hourStart time.Time) int {
+		i := len(c) - 1
+		for i > 0 && hourStart.Before(c[i].EffectiveFrom) {
+			i--
+		}
+		return raters[i].Rate(stay, hourStart)
+	}, true
+}
+
+// computeSegments charges each part of the stay between two changes by the strategy in
+// effect during it and adds up the parts, merging the days they share.
+func (c changingFeeStrategy) computeSegments(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	breakdown := &FeeBreakdown{}
+	for i, change := range c {
+		segment := stay
+		if change.EffectiveFrom.After(segment.EntryTime) {
+			segment.EntryTime = change.EffectiveFrom
+		}
+		if i+1 < len(c) && c[i+1].EffectiveFrom.Before(segment.ExitTime) {
+			segment.ExitTime = c[i+1].EffectiveFrom
+		}
+		if !segment.ExitTime.After(segment.EntryTime) {
+			continue
+		}
+
+		fee, err := change.Strategy.Compute(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		days := fee.Days
+		if days == nil {
+			duration := segment.ExitTime.Sub(segment.EntryTime) - maintenanceOverlap(segment.EntryTime, segment.ExitTime, segment.excluded)
+			days = []DailyFee{{Date: segment.EntryTime.In(segment.location()).Format("2006-01-02"), Hours: duration.Hours(), Amount: fee.Fee}}
+		}
+
+		breakdown.Fee += fee.Fee
+		for _, day := range days {
+			if n := len(breakdown.Days); n > 0 && breakdown.Days[n-1].Date == day.Date {
+				breakdown.Days[n-1].Hours += day.Hours
+				breakdown.Days[n-1].Amount += day.Amount
+				continue
+			}
+			breakdown.Days = append(breakdown.Days, day)
+		}
+	}
+
+	if len(breakdown.Days) <= 1 {
+		breakdown.Days = nil
+	}
+
+	return breakdown, nil
+}
+
+var (
+	feeStrategiesMu sync.RWMutex
+	feeStrategies   = map[string]FeeStrategy{
+		FeeStrategyFlat: FlatFeeStrategy{HourlyRate: ParkingFeeperHour},
+		FeeStrategyPeak: PeakFeeStrategy{
+			HourlyRate:     ParkingFeeperHour,
+			PeakHourlyRate: ParkingFeePeakHour,
+			PeakStartHour:  peakStartHour,
+			PeakEndHour:    peakEndHour,
+		},
+		FeeStrategyDailyBoundary: CalendarDayFeeStrategy{DailyRate: ParkingFeePerCalendarDay},
+	}
+)
+
+// RegisterFeeStrategy makes a fee strategy available to lots under the given name,
+// replacing any strategy registered under it before.
+func RegisterFeeStrategy(name string, strategy FeeStrategy) {
+	feeStrategiesMu.Lock()
+	defer feeStrategiesMu.Unlock()
+
+	feeStrategies[name] = strategy
+}
+
+// lookupFeeStrategy returns the fee strategy registered under name.
+func lookupFeeStrategy(name string) (FeeStrategy, bool) {
+	feeStrategiesMu.RLock()
+	defer feeStrategiesMu.RUnlock()
+
+	strategy, ok := feeStrategies[name]
+	return strategy, ok
+}
+
+func validNegativeFeePolicy(policy string) bool {
+	return policy == NegativeFeeClamp || policy == NegativeFeeCredit
+}
+
+// SetNegativeFeePolicy changes how negative fees are settled in the specified parking lot.
+func (s *ParkingLotStorage) SetNegativeFeePolicy(ctx context.Context, parkingLotID int, policy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validNegativeFeePolicy(policy) {
+		return invalidRequest("unknown negative fee policy %q", policy)
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE parking_lots SET negative_fee_policy = $2 WHERE id = $1", parkingLotID, policy)
+	if err != nil {
+		return internalError("failed to set negative fee policy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// SetFeeStrategy changes how fees are computed in the specified parking lot.
+func (s *ParkingLotStorage) SetFeeStrategy(ctx context.Context, parkingLotID int, strategy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := lookupFeeStrategy(strategy); !ok {
+		return invalidRequest("unknown fee strategy %q", strategy)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError("failed to set fee strategy")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE parking_lots SET fee_strategy = $2 WHERE id = $1", parkingLotID, strategy)
+	if err != nil {
+		return internalError("failed to set fee strategy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO fee_strategy_changes (lot_id, fee_strategy, effective_from)
+		VALUES ($1, $2, $3)
+	`, parkingLotID, strategy, s.now())
+	if err != nil {
+		return internalError("failed to record fee strategy change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return internalError("failed to set fee strategy")
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// PricingConfig is the pricing of a parking lot. Empty fields leave that setting as it is.
+type PricingConfig struct {
+	FeeStrategy       string `json:"feeStrategy"`
+	NegativeFeePolicy string `json:"negativeFeePolicy"`
+}
+
+// BulkPricingResult lists the lots whose pricing was updated and the requested ids that
+// are not parking lots.
+type BulkPricingResult struct {
+	Updated  []int `json:"updated"`
+	NotFound []int `json:"notFound"`
+}
+
+// UpdatePricingBulk applies the same pricing to every existing lot in lotIDs at once.
+func (s *ParkingLotStorage) UpdatePricingBulk(ctx context.Context, lotIDs []int, pricing PricingConfig) (*BulkPricingResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(lotIDs) == 0 {
+		return nil, invalidRequest("no parking lots requested")
+	}
+	if err := checkBulkSize(len(lotIDs)); err != nil {
+		return nil, err
+	}
+	if pricing.FeeStrategy == "" && pricing.NegativeFeePolicy == "" {
+		return nil, invalidRequest("no pricing settings given")
+	}
+	if pricing.FeeStrategy != "" {
+		if _, ok := lookupFeeStrategy(pricing.FeeStrategy); !ok {
+			return nil, invalidRequest("unknown fee strategy %q", pricing.FeeStrategy)
+		}
+	}
+	if pricing.NegativeFeePolicy != "" && !validNegativeFeePolicy(pricing.NegativeFeePolicy) {
+		return nil, invalidRequest("unknown negative fee policy %q", pricing.NegativeFeePolicy)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH updated AS (
+			UPDATE parking_lots
+			SET fee_strategy = COALESCE(NULLIF($2, ''), fee_strategy),
+				negative_fee_policy = COALESCE(NULLIF($3, ''), negative_fee_policy)
+			WHERE id = ANY($1)
+			RETURNING id
+		), changes AS (
+			INSERT INTO fee_strategy_changes (lot_id, fee_strategy, effective_from)
+			SELECT id, $2, $4 FROM updated WHERE $2 <> ''
+		)
+		SELECT id FROM updated
+	`, pq.Array(lotIDs), pricing.FeeStrategy, pricing.NegativeFeePolicy, s.now())
+	if err != nil {
+		return nil, internalError("failed to update pricing")
+	}
+	defer rows.Close()
+
+	updated := make(map[int]bool, len(lotIDs))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, internalError("failed to read updated lots")
+		}
+		updated[id] = true
+		s.lots.invalidate(id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing updated lots")
+	}
+
+	result := &BulkPricingResult{Updated: []int{}, NotFound: []int{}}
+	seen := make(map[int]bool, len(lotIDs))
+	for _, id := range lotIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if updated[id] {
+			result.Updated = append(result.Updated, id)
+		} else {
+			result.NotFound = append(result.NotFound, id)
+		}
+	}
+
+	return result, nil
+}
+
+// RateChange is a fee strategy taking effect in a lot. Strategies in effect since before
+// changes were tracked take effect at the Unix epoch.
+type RateChange struct {
+	FeeStrategy   string    `json:"feeStrategy"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+}
+
+// GetRateHistory returns every fee strategy change of the specified lot, oldest first.
+func (s *ParkingLotStorage) GetRateHistory(ctx context.Context, parkingLotID int) ([]*RateChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT fee_strategy, effective_from
+		FROM fee_strategy_changes
+		WHERE lot_id = $1
+		ORDER BY effective_from, id
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve rate history")
+	}
+	defer rows.Close()
+
+	history := []*RateChange{}
+	for rows.Next() {
+		var change RateChange
+		if err := rows.Scan(&change.FeeStrategy, &change.EffectiveFrom); err != nil {
+			return nil, internalError("failed to read rate history")
+		}
+		inLotZone(lot, &change.EffectiveFrom)
+		history = append(history, &change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing rate history")
+	}
+
+	return history, nil
+}
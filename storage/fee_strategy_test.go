@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestChangingFeeStrategy(t *testing.T) {
+	flat := FlatFeeStrategy{HourlyRate: 10}
+	dearer := FlatFeeStrategy{HourlyRate: 20}
+	daily := CalendarDayFeeStrategy{DailyRate: 100}
+	evening := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		changes  changingFeeStrategy
+		entry    time.Time
+		exit     time.Time
+		wantFee  int
+		wantDays []DailyFee
+	}{
+		{
+			"hourly rate raised mid-stay",
+			changingFeeStrategy{{flat, time.Unix(0, 0)}, {dearer, at(time.Hour)}},
+			at(0), at(150 * time.Minute), 50, nil,
+		},
+		{
+			"hourly to daily",
+			changingFeeStrategy{{flat, time.Unix(0, 0)}, {daily, at(2 * time.Hour)}},
+			at(0), at(270 * time.Minute), 120, nil,
+		},
+		{
+			"daily to hourly",
+			changingFeeStrategy{{daily, time.Unix(0, 0)}, {flat, at(2 * time.Hour)}},
+			at(0), at(270 * time.Minute), 130, nil,
+		},
+		{
+			"hourly to daily across midnight",
+			changingFeeStrategy{{flat, time.Unix(0, 0)}, {daily, evening.Add(2 * time.Hour)}},
+			evening, evening.Add(7 * time.Hour), 220, []DailyFee{
+				{Date: "2024-03-01", Hours: 4, Amount: 120},
+				{Date: "2024-03-02", Hours: 3, Amount: 100},
+			},
+		},
+		{
+			"change after the stay",
+			changingFeeStrategy{{flat, time.Unix(0, 0)}, {daily, at(5 * time.Hour)}},
+			at(0), at(2 * time.Hour), 20, nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee, err := tt.changes.Compute(context.Background(), Stay{SlotsRequired: 1, EntryTime: tt.entry, ExitTime: tt.exit})
+			if err != nil {
+				t.Fatalf("Compute: %v", err)
+			}
+			if fee.Fee != tt.wantFee {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+			if len(fee.Days) != len(tt.wantDays) {
+				t.Fatalf("days = %+v, want %+v", fee.Days, tt.wantDays)
+			}
+			for i, day := range fee.Days {
+				if day != tt.wantDays[i] {
+					t.Errorf("day %d = %+v, want %+v", i, day, tt.wantDays[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnparkVehicleAcrossRateChange(t *testing.T) {
+	results := append([]fakeResult{{match: "FROM fee_strategy_changes", rows: [][]driver.Value{
+		{FeeStrategyFlat, time.Unix(0, 0)},
+		{FeeStrategyPeak, at(time.Hour)},
+	}}}, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)
+	s, _, clock := newFakeStorage(append(results, lotResult(testLot()))...)
+	clock.Advance(3 * time.Hour)
+
+	fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+	if err != nil {
+		t.Fatalf("UnparkVehicle: %v", err)
+	}
+	// One hour at the flat rate, then two peak hours after the change.
+	if want := ParkingFeeperHour + 2*ParkingFeePeakHour; fee.Fee != want {
+		t.Errorf("fee = %d, want %d", fee.Fee, want)
+	}
+}
+
+func TestUnparkVehicleCrossingLocalMidnight(t *testing.T) {
+	dhaka, err := time.LoadLocation("Asia/Dhaka")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		wantFee  int
+		wantDays []DailyFee
+	}{
+		{"UTC", time.UTC, ParkingFeePerCalendarDay, nil},
+		{"Asia/Dhaka", dhaka, 2 * ParkingFeePerCalendarDay, []DailyFee{
+			{Date: "2024-03-01", Hours: 8, Amount: ParkingFeePerCalendarDay},
+			{Date: "2024-03-02", Hours: 2, Amount: ParkingFeePerCalendarDay},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lot := testLot()
+			lot.FeeStrategy, lot.Location = FeeStrategyDailyBoundary, tt.location
+			s, _, clock := newFakeStorage(append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(lot))...)
+			// From 10:00 to 20:00 UTC, which is 16:00 to 02:00 the next day in Dhaka.
+			clock.Advance(10 * time.Hour)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.wantFee {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+			if len(fee.Days) != len(tt.wantDays) {
+				t.Fatalf("days = %+v, want %+v", fee.Days, tt.wantDays)
+			}
+			for i, day := range fee.Days {
+				if day != tt.wantDays[i] {
+					t.Errorf("day %d = %+v, want %+v", i, day, tt.wantDays[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPeakHoursInLotTimezone(t *testing.T) {
+	tests := []struct {
+		timezone string
+		wantFee  int
+	}{
+		// From 10:00 to 13:00 UTC, all peak hours.
+		{"UTC", 3 * ParkingFeePeakHour},
+		// From 16:00 to 19:00, the last hour after the peak ends.
+		{"Asia/Dhaka", 2*ParkingFeePeakHour + ParkingFeeperHour},
+		// From 05:00 to 08:00, before the peak starts.
+		{"America/New_York", 3 * ParkingFeeperHour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timezone, func(t *testing.T) {
+			location, err := time.LoadLocation(tt.timezone)
+			if err != nil {
+				t.Fatalf("LoadLocation: %v", err)
+			}
+			lot := testLot()
+			lot.FeeStrategy, lot.Location = FeeStrategyPeak, location
+			s, _, clock := newFakeStorage(append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(lot))...)
+			clock.Advance(3 * time.Hour)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.wantFee {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// at returns testTime moved by d.
+func at(d time.Duration) time.Time {
+	return testTime.Add(d)
+}
+
+func TestFeeExcludesMaintenanceWindows(t *testing.T) {
+	tests := []struct {
+		name     string
+		stay     time.Duration
+		excluded []timeWindow
+		want     int
+	}{
+		{"no maintenance", 3 * time.Hour, nil, 30},
+		{"maintenance covering the first hour", 3 * time.Hour, []timeWindow{{at(0), at(time.Hour)}}, 20},
+		{"maintenance covering the last 90 minutes", 3 * time.Hour, []timeWindow{{at(90 * time.Minute), at(4 * time.Hour)}}, 20},
+		{"maintenance covering the whole stay", 2 * time.Hour, []timeWindow{{at(-time.Hour), at(3 * time.Hour)}}, 0},
+		{"maintenance outside the stay", 2 * time.Hour, []timeWindow{{at(-2 * time.Hour), at(-time.Hour)}}, 20},
+		{
+			"overlapping windows of two slots counted once",
+			4 * time.Hour,
+			[]timeWindow{{at(time.Hour), at(2 * time.Hour)}, {at(90 * time.Minute), at(150 * time.Minute)}},
+			30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stay := Stay{SlotsRequired: 1, EntryTime: at(0), ExitTime: at(tt.stay), excluded: tt.excluded}
+			fee, err := FlatFeeStrategy{HourlyRate: 10}.Compute(context.Background(), stay)
+			if err != nil {
+				t.Fatalf("Compute: %v", err)
+			}
+			if fee.Fee != tt.want {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceOverlap(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []timeWindow
+		want    time.Duration
+	}{
+		{"none", nil, 0},
+		{"inside", []timeWindow{{at(time.Hour), at(2 * time.Hour)}}, time.Hour},
+		{"clipped to the stay", []timeWindow{{at(-time.Hour), at(30 * time.Minute)}}, 30 * time.Minute},
+		{"merged", []timeWindow{{at(time.Hour), at(2 * time.Hour)}, {at(90 * time.Minute), at(3 * time.Hour)}}, 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maintenanceOverlap(at(0), at(4*time.Hour), tt.windows); got != tt.want {
+				t.Errorf("maintenanceOverlap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeeBreakdownOfThreeDayStay(t *testing.T) {
+	entryTime := time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC)
+	exitTime := time.Date(2024, 3, 3, 4, 45, 0, 0, time.UTC)
+	peak := PeakFeeStrategy{HourlyRate: 10, PeakHourlyRate: 15, PeakStartHour: 8, PeakEndHour: 18}
+
+	tests := []struct {
+		name     string
+		strategy FeeStrategy
+		want     []DailyFee
+	}{
+		{"flat", FlatFeeStrategy{HourlyRate: 10}, []DailyFee{
+			{Date: "2024-03-01", Hours: 1.5, Amount: 20},
+			{Date: "2024-03-02", Hours: 24, Amount: 240},
+			{Date: "2024-03-03", Hours: 4.75, Amount: 50},
+		}},
+		{"peak", peak, []DailyFee{
+			{Date: "2024-03-01", Hours: 1.5, Amount: 20},
+			{Date: "2024-03-02", Hours: 24, Amount: 290},
+			{Date: "2024-03-03", Hours: 4.75, Amount: 50},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stay := Stay{SlotsRequired: 1, EntryTime: entryTime, ExitTime: exitTime}
+			fee, err := tt.strategy.Compute(context.Background(), stay)
+			if err != nil {
+				t.Fatalf("Compute: %v", err)
+			}
+			if len(fee.Days) != len(tt.want) {
+				t.Fatalf("days = %+v, want %+v", fee.Days, tt.want)
+			}
+			total := 0
+			for i, day := range fee.Days {
+				if day != tt.want[i] {
+					t.Errorf("day %d = %+v, want %+v", i, day, tt.want[i])
+				}
+				total += tt.want[i].Amount
+			}
+			if fee.Fee != total {
+				t.Errorf("fee = %d, want the days' total %d", fee.Fee, total)
+			}
+		})
+	}
+}
+
+func TestSettlePrepayment(t *testing.T) {
+	prepaidUntil := at(3 * time.Hour)
+
+	tests := []struct {
+		name     string
+		fee      FeeBreakdown
+		prepaid  int
+		exitTime time.Time
+		want     FeeBreakdown
+	}{
+		{"not prepaid", FeeBreakdown{Fee: 20}, 0, at(time.Hour), FeeBreakdown{Fee: 20}},
+		{"early exit refunds the unused part", FeeBreakdown{Fee: 20}, 30, at(2 * time.Hour), FeeBreakdown{Fee: 20, Prepaid: 30, Refund: 10}},
+		{"exit at the end of the prepayment", FeeBreakdown{Fee: 30}, 30, prepaidUntil, FeeBreakdown{Fee: 30, Prepaid: 30}},
+		{"late exit leaves the rest due", FeeBreakdown{Fee: 50}, 30, at(5 * time.Hour), FeeBreakdown{Fee: 50, Prepaid: 30, AmountDue: 20}},
+		{"late exit covered by validation", FeeBreakdown{Fee: 50, Validated: 20}, 30, at(5 * time.Hour), FeeBreakdown{Fee: 50, Validated: 20, Prepaid: 30}},
+		{"early exit fully validated", FeeBreakdown{Fee: 20, Validated: 20}, 30, at(2 * time.Hour), FeeBreakdown{Fee: 20, Validated: 20, Prepaid: 30, Refund: 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee := tt.fee
+			settlePrepayment(&fee, tt.prepaid, prepaidUntil, tt.exitTime)
+			if fee.Prepaid != tt.want.Prepaid || fee.Refund != tt.want.Refund || fee.AmountDue != tt.want.AmountDue || fee.Fee != tt.want.Fee {
+				t.Errorf("settled %+v, want %+v", fee, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartedHours(t *testing.T) {
+	tests := []struct {
+		stay      time.Duration
+		wantHours int
+		wantFee   int
+	}{
+		{0, 0, 0},
+		{2 * time.Second, 0, 0},
+		{zeroStayThreshold - time.Second, 0, 0},
+		{zeroStayThreshold, 1, 10},
+		{59*time.Minute + 59*time.Second, 1, 10},
+		{time.Hour, 1, 10},
+		{time.Hour + time.Second, 2, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stay.String(), func(t *testing.T) {
+			if got := startedHours(tt.stay); got != tt.wantHours {
+				t.Errorf("startedHours(%v) = %d, want %d", tt.stay, got, tt.wantHours)
+			}
+			fee := feeBreakdown(at(0), at(tt.stay), nil, func(time.Time) int { return 10 })
+			if fee.Fee != tt.wantFee {
+				t.Errorf("feeBreakdown fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+		})
+	}
+}
+
+// discountFeeStrategy takes a fixed discount off the fee of another strategy.
+type discountFeeStrategy struct {
+	FeeStrategy
+	Discount int
+}
+
+func (d discountFeeStrategy) Compute(ctx context.Context, stay Stay) (*FeeBreakdown, error) {
+	fee, err := d.FeeStrategy.Compute(ctx, stay)
+	if err != nil {
+		return nil, err
+	}
+	fee.Fee -= d.Discount
+	return fee, nil
+}
+
+func TestApplyNegativeFeePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		fee           int
+		policy        string
+		wantFee       int
+		wantUnclamped *int
+	}{
+		{"positive fee", 20, NegativeFeeClamp, 20, nil},
+		{"zero fee", 0, NegativeFeeClamp, 0, nil},
+		{"discount exceeding the fee clamped", -5, NegativeFeeClamp, 0, intPtr(-5)},
+		{"discount exceeding the fee credited", -5, NegativeFeeCredit, -5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee := &FeeBreakdown{Fee: tt.fee}
+			applyNegativeFeePolicy(fee, tt.policy)
+			if fee.Fee != tt.wantFee {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+			if (fee.UnclampedFee == nil) != (tt.wantUnclamped == nil) || fee.UnclampedFee != nil && *fee.UnclampedFee != *tt.wantUnclamped {
+				t.Errorf("unclamped fee = %v, want %v", fee.UnclampedFee, tt.wantUnclamped)
+			}
+			if fee.feeBeforeClamp() != tt.fee {
+				t.Errorf("fee before clamp = %d, want %d", fee.feeBeforeClamp(), tt.fee)
+			}
+		})
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestNextFeeIncrement(t *testing.T) {
+	tests := []struct {
+		name       string
+		now        time.Duration
+		slots      int
+		currency   string
+		windows    [][]driver.Value
+		wantAt     time.Duration
+		wantAmount int
+	}{
+		{"within the first hour", 30 * time.Minute, 1, "", nil, time.Hour, 10},
+		{"at the end of an hour", time.Hour, 1, "", nil, time.Hour, 10},
+		{"within a later hour", 90 * time.Minute, 1, "", nil, 2 * time.Hour, 10},
+		{"before the first hour is charged", 30 * time.Second, 1, "", nil, zeroStayThreshold, 10},
+		{"oversized vehicle", 30 * time.Minute, 2, "", nil, time.Hour, 20},
+		{"minor currency unit", 30 * time.Minute, 1, "USD", nil, time.Hour, 1000},
+		{"next hour after maintenance", 30 * time.Minute, 1, "", [][]driver.Value{{int64(1), at(time.Hour), at(2 * time.Hour)}}, 2 * time.Hour, 10},
+	}
+
+	graced := true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lot := testLot()
+			lot.Currency, lot.MaintenanceGrace = tt.currency, &graced
+			s, db, _ := newFakeStorage(lotResult(lot),
+				fakeResult{match: "FROM fee_strategy_changes"},
+				fakeResult{match: "FROM maintenance_windows", rows: tt.windows})
+			ctx := context.Background()
+
+			nextAt, amount, ok, err := s.nextFeeIncrement(ctx, s.db, 1, 1, tt.slots, at(0), at(tt.now))
+			if err != nil || !ok {
+				t.Fatalf("nextFeeIncrement = %v, %v", ok, err)
+			}
+			if !nextAt.Equal(at(tt.wantAt)) || amount != tt.wantAmount {
+				t.Errorf("next increment of %d at %v, want %d at %v", amount, nextAt, tt.wantAmount, at(tt.wantAt))
+			}
+			if n := db.count("FROM fee_strategy_changes"); n != 1 {
+				t.Errorf("fee strategy changes queried %d times, want 1", n)
+			}
+
+			// Leaving before the increment costs the current fee, and leaving after it that much more.
+			current, err := s.parkingFee(ctx, s.db, 1, 1, tt.slots, at(0), at(tt.now))
+			if err != nil {
+				t.Fatalf("parkingFee: %v", err)
+			}
+			for _, exit := range []struct {
+				at   time.Time
+				want int
+			}{{nextAt.Add(-time.Second), current.Fee}, {nextAt.Add(time.Second), current.Fee + amount}} {
+				fee, err := s.parkingFee(ctx, s.db, 1, 1, tt.slots, at(0), exit.at)
+				if err != nil {
+					t.Fatalf("parkingFee: %v", err)
+				}
+				if fee.Fee != exit.want {
+					t.Errorf("fee when leaving at %v = %d, want %d", exit.at, fee.Fee, exit.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNextFeeIncrementOfCalendarDays(t *testing.T) {
+	lot := testLot()
+	lot.FeeStrategy = FeeStrategyDailyBoundary
+	s, _, _ := newFakeStorage(lotResult(lot), fakeResult{match: "FROM fee_strategy_changes"})
+
+	if _, _, ok, err := s.nextFeeIncrement(context.Background(), s.db, 1, 1, 1, at(0), at(time.Hour)); err != nil || ok {
+		t.Errorf("nextFeeIncrement = %v, %v, want no increment for a strategy not charging hours", ok, err)
+	}
+}
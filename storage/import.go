@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Bulk operations write at most DefaultBulkBatchSize records per transaction unless
+// configured otherwise, and refuse requests of more than MaxBulkRecords records.
+const (
+	DefaultBulkBatchSize = 500
+	MaxBulkRecords       = 10000
+)
+
+// SetBulkBatchSize sets how many records bulk operations write per transaction.
+func (s *ParkingLotStorage) SetBulkBatchSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bulkBatchSize = size
+}
+
+// batchSize returns the configured bulk batch size. The caller must hold s.mu.
+func (s *ParkingLotStorage) batchSize() int {
+	if s.bulkBatchSize <= 0 {
+		return DefaultBulkBatchSize
+	}
+	return s.bulkBatchSize
+}
+
+// checkBulkSize rejects bulk requests of more than MaxBulkRecords records.
+func checkBulkSize(n int) error {
+	if n > MaxBulkRecords {
+		return newError(CodeInvalidRequest, http.StatusRequestEntityTooLarge, "at most %d records can be processed per request, got %d", MaxBulkRecords, n)
+	}
+	return nil
+}
+
+// TransactionRecord is a historical parking transaction imported from another system.
+type TransactionRecord struct {
+	LicensePlate string    `json:"licensePlate"`
+	SlotNumber   int       `json:"slotNumber"`
+	EntryTime    time.Time `json:"entryTime"`
+	ExitTime     time.Time `json:"exitTime"`
+	Fee          int       `json:"fee"`
+}
+
+// ImportResult is the outcome of an import written in several batches.
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Batches  int `json:"batches"`
+}
+
+// ImportTransactions inserts historical transactions into the specified lot.
+// All records are validated first, then written in batches of the bulk batch size, each
+// in its own transaction. When a batch fails the batches before it stay imported and
+// the error says how many records were.
+func (s *ParkingLotStorage) ImportTransactions(ctx context.Context, parkingLotID int, records []TransactionRecord) (*ImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil, invalidRequest("no records to import")
+	}
+	if err := checkBulkSize(len(records)); err != nil {
+		return nil, err
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, record := range records {
+		if err := validateLicensePlate(record.LicensePlate); err != nil {
+			return nil, invalidRequest("record %d: %s", i, err.Error())
+		}
+		switch {
+		case record.SlotNumber < 1 || record.SlotNumber > lot.TotalSpaces:
+			return nil, invalidRequest("record %d: slot %d is not in the lot", i, record.SlotNumber)
+		case record.EntryTime.IsZero() || record.ExitTime.IsZero():
+			return nil, invalidRequest("record %d: entry and exit times are required", i)
+		case record.ExitTime.Before(record.EntryTime):
+			return nil, invalidRequest("record %d: exit time is before entry time", i)
+		case record.Fee < 0:
+			return nil, invalidRequest("record %d: fee must not be negative", i)
+		}
+	}
+
+	result := &ImportResult{}
+	batchSize := s.batchSize()
+	for start := 0; start < len(records); start += batchSize {
+		end := start + batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		if err := s.importBatch(ctx, parkingLotID, records[start:end]); err != nil {
+			return nil, internalError(fmt.Sprintf("failed to import transactions after importing %d", result.Imported))
+		}
+		result.Imported += end - start
+		result.Batches++
+	}
+
+	return result, nil
+}
+
+// importBatch inserts the records in one transaction.
+func (s *ParkingLotStorage) importBatch(ctx context.Context, parkingLotID int, records []TransactionRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO parking_transactions (lot_id, vehicle_license_plate, slot, fee, entry_time, exit_time)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, record := range records {
+		_, err := stmt.ExecContext(ctx, parkingLotID, record.LicensePlate, record.SlotNumber, record.Fee, record.EntryTime.UTC(), record.ExitTime.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// newIntegrationStorage connects to the development database, skipping the test when it
+// cannot be reached. Each call opens its own connection pool, like a separate server.
+func newIntegrationStorage(t *testing.T) *ParkingLotStorage {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping database test in short mode")
+	}
+	s, err := NewParkingLotStorage(time.Second)
+	if err != nil {
+		t.Skipf("database not available: %v", err)
+	}
+	t.Cleanup(func() {
+		s.db.Close()
+	})
+
+	return s
+}
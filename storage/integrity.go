@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OrphanedSlot is a parking space marked occupied with no active parked vehicle in it.
+type OrphanedSlot struct {
+	SlotNumber int       `json:"slotNumber"`
+	EntryTime  time.Time `json:"entryTime"`
+}
+
+// orphanedSlotsCondition matches occupied spaces that no open parked_vehicles row covers.
+const orphanedSlotsCondition = `
+	parking_spaces.lot_id = $1 AND parking_spaces.occupied
+	AND NOT EXISTS (
+		SELECT 1 FROM parked_vehicles
+		WHERE parked_vehicles.parking_lot_id = parking_spaces.lot_id
+		AND parking_spaces.number BETWEEN parked_vehicles.slot AND parked_vehicles.slot + parked_vehicles.slots_required - 1
+		AND parked_vehicles.exit_time IS NULL
+	)
+`
+
+// FindOrphanedSlots returns the spaces in the specified lot that are marked occupied
+// without a matching parked vehicle.
+func (s *ParkingLotStorage) FindOrphanedSlots(ctx context.Context, parkingLotID int) ([]*OrphanedSlot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	return findOrphanedSlots(ctx, s.db, parkingLotID, lot)
+}
+
+func findOrphanedSlots(ctx context.Context, q querier, parkingLotID int, lot lotMeta) ([]*OrphanedSlot, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT number, COALESCE(entry_time, 'epoch')
+		FROM parking_spaces
+		WHERE `+orphanedSlotsCondition+`
+		ORDER BY number
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to find orphaned slots")
+	}
+	defer rows.Close()
+
+	orphanedSlots := []*OrphanedSlot{}
+	for rows.Next() {
+		var slot OrphanedSlot
+		if err := rows.Scan(&slot.SlotNumber, &slot.EntryTime); err != nil {
+			return nil, internalError("failed to read orphaned slots")
+		}
+		inLotZone(lot, &slot.EntryTime)
+		orphanedSlots = append(orphanedSlots, &slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing orphaned slots")
+	}
+
+	return orphanedSlots, nil
+}
+
+// RepairOrphanedSlots frees the orphaned spaces in the specified lot and returns their numbers.
+func (s *ParkingLotStorage) RepairOrphanedSlots(ctx context.Context, parkingLotID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	return repairOrphanedSlots(ctx, s.db, parkingLotID, s.now())
+}
+
+// repairOrphanedSlots frees the orphaned spaces in the specified lot as of at and returns
+// their numbers.
+func repairOrphanedSlots(ctx context.Context, q querier, parkingLotID int, at time.Time) ([]int, error) {
+	rows, err := q.QueryContext(ctx, `
+		UPDATE parking_spaces
+		SET occupied = false
+		WHERE `+orphanedSlotsCondition+`
+		RETURNING number
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to repair orphaned slots")
+	}
+	defer rows.Close()
+
+	freed := []int{}
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			return nil, internalError("failed to read repaired slots")
+		}
+		freed = append(freed, number)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing repaired slots")
+	}
+
+	if len(freed) > 0 {
+		if err := recordSlotStates(ctx, q, parkingLotID, freed, SlotActionOrphanRepair, at); err != nil {
+			return nil, internalError("failed to repair orphaned slots")
+		}
+		if err := bumpLotVersion(ctx, q, parkingLotID); err != nil {
+			return nil, internalError("failed to repair orphaned slots")
+		}
+	}
+
+	return freed, nil
+}
+
+// ParkedEntry is one active parked_vehicles row.
+type ParkedEntry struct {
+	ID         int       `json:"id"`
+	SlotNumber int       `json:"slotNumber"`
+	EntryTime  time.Time `json:"entryTime"`
+}
+
+// DuplicateParkedVehicle is a license plate with more than one active parked_vehicles
+// row in a lot. Entries are ordered from the earliest entry.
+type DuplicateParkedVehicle struct {
+	LicensePlate string         `json:"licensePlate"`
+	Entries      []*ParkedEntry `json:"entries"`
+}
+
+// DuplicateRepair is the outcome of closing duplicate parked_vehicles rows.
+type DuplicateRepair struct {
+	ClosedIDs  []int `json:"closedIDs"`
+	FreedSlots []int `json:"freedSlots"`
+}
+
+// FindDuplicateParkedVehicles returns the plates parked more than once at the same time
+// in the specified lot, ordered by plate.
+func (s *ParkingLotStorage) FindDuplicateParkedVehicles(ctx context.Context, parkingLotID int) ([]*DuplicateParkedVehicle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	return findDuplicateParkedVehicles(ctx, s.db, parkingLotID, lot)
+}
+
+func findDuplicateParkedVehicles(ctx context.Context, q querier, parkingLotID int, lot lotMeta) ([]*DuplicateParkedVehicle, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT license_plate, id, slot, entry_time
+		FROM parked_vehicles
+		WHERE parking_lot_id = $1 AND exit_time IS NULL
+		AND license_plate IN (
+			SELECT license_plate FROM parked_vehicles
+			WHERE parking_lot_id = $1 AND exit_time IS NULL
+			GROUP BY license_plate
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY license_plate, entry_time, id
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to find duplicate parked vehicles")
+	}
+	defer rows.Close()
+
+	duplicates := []*DuplicateParkedVehicle{}
+	for rows.Next() {
+		var plate string
+		var entry ParkedEntry
+		if err := rows.Scan(&plate, &entry.ID, &entry.SlotNumber, &entry.EntryTime); err != nil {
+			return nil, internalError("failed to read duplicate parked vehicles")
+		}
+		inLotZone(lot, &entry.EntryTime)
+		if n := len(duplicates); n == 0 || duplicates[n-1].LicensePlate != plate {
+			duplicates = append(duplicates, &DuplicateParkedVehicle{LicensePlate: plate})
+		}
+		last := duplicates[len(duplicates)-1]
+		last.Entries = append(last.Entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing duplicate parked vehicles")
+	}
+
+	return duplicates, nil
+}
+
+// RepairDuplicateParkedVehicles keeps the earliest active row of every plate parked more
+// than once in the specified lot and closes the others, freeing the slots that only the
+// closed rows occupied. No transactions are recorded for the closed rows.
+func (s *ParkingLotStorage) RepairDuplicateParkedVehicles(ctx context.Context, parkingLotID int) (*DuplicateRepair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to repair duplicate parked vehicles")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE parked_vehicles
+		SET exit_time = $2
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY license_plate ORDER BY entry_time, id) AS position
+				FROM parked_vehicles
+				WHERE parking_lot_id = $1 AND exit_time IS NULL
+			) active
+			WHERE position > 1
+		)
+		RETURNING id, slot, slots_required
+	`, parkingLotID, s.now())
+	if err != nil {
+		return nil, internalError("failed to repair duplicate parked vehicles")
+	}
+
+	repair := &DuplicateRepair{ClosedIDs: []int{}, FreedSlots: []int{}}
+	var closedBlocks [][2]int
+	for rows.Next() {
+		var id, slot, slotsRequired int
+		if err := rows.Scan(&id, &slot, &slotsRequired); err != nil {
+			rows.Close()
+			return nil, internalError("failed to read repaired parked vehicles")
+		}
+		repair.ClosedIDs = append(repair.ClosedIDs, id)
+		closedBlocks = append(closedBlocks, [2]int{slot, slot + slotsRequired - 1})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing repaired parked vehicles")
+	}
+
+	for _, block := range closedBlocks {
+		freed, err := tx.QueryContext(ctx, `
+			UPDATE parking_spaces
+			SET occupied = false
+			WHERE `+orphanedSlotsCondition+`
+			AND parking_spaces.number BETWEEN $2 AND $3
+			RETURNING number
+		`, parkingLotID, block[0], block[1])
+		if err != nil {
+			return nil, internalError("failed to free duplicate slots")
+		}
+		for freed.Next() {
+			var number int
+			if err := freed.Scan(&number); err != nil {
+				freed.Close()
+				return nil, internalError("failed to read freed slots")
+			}
+			repair.FreedSlots = append(repair.FreedSlots, number)
+		}
+		freed.Close()
+		if err := freed.Err(); err != nil {
+			return nil, internalError("error processing freed slots")
+		}
+	}
+
+	if err := recordSlotStates(ctx, tx, parkingLotID, repair.FreedSlots, SlotActionDuplicateRepair, s.now()); err != nil {
+		return nil, internalError("failed to repair duplicate parked vehicles")
+	}
+	if len(repair.ClosedIDs) > 0 {
+		if err := bumpLotVersion(ctx, tx, parkingLotID); err != nil {
+			return nil, internalError("failed to repair duplicate parked vehicles")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to repair duplicate parked vehicles")
+	}
+
+	return repair, nil
+}
+
+// UnusedSlot is a parking space no transaction has used in a period. LastUsed is the
+// last exit from it before the period, if any.
+type UnusedSlot struct {
+	SlotNumber  int        `json:"slotNumber"`
+	VehicleType string     `json:"vehicleType"`
+	LastUsed    *time.Time `json:"lastUsed,omitempty"`
+}
+
+// GetUnusedSlots returns the spaces of the specified lot that no transaction has started
+// in since the given time and that are not occupied now, ordered by slot number.
+// Transactions of oversized vehicles only count for the first slot of their block.
+func (s *ParkingLotStorage) GetUnusedSlots(ctx context.Context, parkingLotID int, since time.Time) ([]*UnusedSlot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parking_spaces.number, parking_spaces.vehicle_type, MAX(parking_transactions.exit_time)
+		FROM parking_spaces
+		LEFT JOIN parking_transactions ON parking_transactions.lot_id = parking_spaces.lot_id
+			AND parking_transactions.slot = parking_spaces.number
+		WHERE parking_spaces.lot_id = $1 AND NOT parking_spaces.occupied
+		GROUP BY parking_spaces.number, parking_spaces.vehicle_type
+		HAVING COUNT(*) FILTER (WHERE parking_transactions.exit_time >= $2) = 0
+		ORDER BY parking_spaces.number
+	`, parkingLotID, since.UTC())
+	if err != nil {
+		return nil, internalError("failed to find unused slots")
+	}
+	defer rows.Close()
+
+	unused := []*UnusedSlot{}
+	for rows.Next() {
+		var slot UnusedSlot
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&slot.SlotNumber, &slot.VehicleType, &lastUsed); err != nil {
+			return nil, internalError("failed to read unused slots")
+		}
+		if lastUsed.Valid {
+			slot.LastUsed = &lastUsed.Time
+		}
+		inLotZone(lot, slot.LastUsed)
+		unused = append(unused, &slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing unused slots")
+	}
+
+	return unused, nil
+}
+
+// UnmarkedParkedVehicle is an active parked_vehicles row covering a parking space that
+// is not marked occupied, so the space can be given to another vehicle.
+type UnmarkedParkedVehicle struct {
+	ID           int       `json:"id"`
+	LicensePlate string    `json:"licensePlate"`
+	SlotNumber   int       `json:"slotNumber"`
+	EntryTime    time.Time `json:"entryTime"`
+}
+
+// IntegrityReport lists every inconsistency found between the spaces of a lot and the
+// vehicles parked in it.
+type IntegrityReport struct {
+	ParkingLotID            int                       `json:"parkingLotID"`
+	Healthy                 bool                      `json:"healthy"`
+	OrphanedSlots           []*OrphanedSlot           `json:"orphanedSlots"`
+	DuplicateParkedVehicles []*DuplicateParkedVehicle `json:"duplicateParkedVehicles"`
+	UnmarkedParkedVehicles  []*UnmarkedParkedVehicle  `json:"unmarkedParkedVehicles"`
+}
+
+// CheckLotIntegrity runs all integrity checks on the specified lot: occupied spaces
+// without a parked vehicle, plates parked more than once, and parked vehicles whose
+// spaces are not marked occupied. It reports the problems without repairing them.
+func (s *ParkingLotStorage) CheckLotIntegrity(ctx context.Context, parkingLotID int) (*IntegrityReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IntegrityReport{ParkingLotID: parkingLotID}
+
+	report.OrphanedSlots, err = findOrphanedSlots(ctx, s.db, parkingLotID, lot)
+	if err != nil {
+		return nil, err
+	}
+	report.DuplicateParkedVehicles, err = findDuplicateParkedVehicles(ctx, s.db, parkingLotID, lot)
+	if err != nil {
+		return nil, err
+	}
+	report.UnmarkedParkedVehicles, err = findUnmarkedParkedVehicles(ctx, s.db, parkingLotID, lot)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Healthy = len(report.OrphanedSlots) == 0 && len(report.DuplicateParkedVehicles) == 0 && len(report.UnmarkedParkedVehicles) == 0
+
+	return report, nil
+}
+
+func findUnmarkedParkedVehicles(ctx context.Context, q querier, parkingLotID int, lot lotMeta) ([]*UnmarkedParkedVehicle, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT parked_vehicles.id, parked_vehicles.license_plate, parking_spaces.number, parked_vehicles.entry_time
+		FROM parked_vehicles
+		JOIN parking_spaces ON parking_spaces.lot_id = parked_vehicles.parking_lot_id
+			AND parking_spaces.number BETWEEN parked_vehicles.slot AND parked_vehicles.slot + parked_vehicles.slots_required - 1
+		WHERE parked_vehicles.parking_lot_id = $1 AND parked_vehicles.exit_time IS NULL
+		AND NOT parking_spaces.occupied
+		ORDER BY parking_spaces.number, parked_vehicles.id
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to find unmarked parked vehicles")
+	}
+	defer rows.Close()
+
+	unmarked := []*UnmarkedParkedVehicle{}
+	for rows.Next() {
+		var vehicle UnmarkedParkedVehicle
+		if err := rows.Scan(&vehicle.ID, &vehicle.LicensePlate, &vehicle.SlotNumber, &vehicle.EntryTime); err != nil {
+			return nil, internalError("failed to read unmarked parked vehicles")
+		}
+		inLotZone(lot, &vehicle.EntryTime)
+		unmarked = append(unmarked, &vehicle)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing unmarked parked vehicles")
+	}
+
+	return unmarked, nil
+}
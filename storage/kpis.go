@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// KPIs are the headline figures across all parking lots.
+type KPIs struct {
+	ParkedNow               int     `json:"parkedNow"`
+	TodayRevenue            int     `json:"todayRevenue"`
+	TodayVehicles           int     `json:"todayVehicles"`
+	AverageOccupancyPercent float64 `json:"averageOccupancyPercent"`
+}
+
+// GetKPIs returns the vehicles parked now, the average occupancy of the lots, and the
+// revenue and number of vehicles that left since midnight UTC, leaving out voided transactions.
+func (s *ParkingLotStorage) GetKPIs(ctx context.Context) (*KPIs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	kpis := &KPIs{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(occupied), 0), COALESCE(AVG(100.0 * occupied / NULLIF(total_spaces, 0)), 0)
+		FROM (
+			SELECT parking_lots.total_spaces, COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied) AS occupied
+			FROM parking_lots
+			LEFT JOIN parking_spaces ON parking_spaces.lot_id = parking_lots.id
+			GROUP BY parking_lots.id
+		) lots
+	`).Scan(&kpis.ParkedNow, &kpis.AverageOccupancyPercent)
+	if err != nil {
+		return nil, internalError("failed to retrieve occupancy")
+	}
+
+	now := s.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(fee), 0)
+		FROM parking_transactions
+		WHERE exit_time >= $1 AND voided_at IS NULL
+	`, today).Scan(&kpis.TodayVehicles, &kpis.TodayRevenue)
+	if err != nil {
+		return nil, internalError("failed to retrieve today's transactions")
+	}
+
+	return kpis, nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// maxLayoutFieldLength bounds the floor, zone and label of a slot.
+const maxLayoutFieldLength = 20
+
+// Slot states shown on a lot layout.
+const (
+	SlotStateFree        = "free"
+	SlotStateOccupied    = "occupied"
+	SlotStateMaintenance = "maintenance"
+)
+
+// SlotPlacement is where a slot is in its lot. X and Y are nil when the slot has no
+// coordinates on the map.
+type SlotPlacement struct {
+	SlotNumber int    `json:"slotNumber"`
+	Floor      string `json:"floor,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	Label      string `json:"label,omitempty"`
+	X          *int   `json:"x,omitempty"`
+	Y          *int   `json:"y,omitempty"`
+}
+
+// LayoutSlot is a slot on a lot layout together with its current state.
+type LayoutSlot struct {
+	SlotNumber  int    `json:"slotNumber"`
+	Label       string `json:"label,omitempty"`
+	VehicleType string `json:"vehicleType"`
+	X           *int   `json:"x,omitempty"`
+	Y           *int   `json:"y,omitempty"`
+	State       string `json:"state"`
+}
+
+// LayoutZone is the slots of one zone of a floor, by slot number.
+type LayoutZone struct {
+	Zone  string        `json:"zone"`
+	Slots []*LayoutSlot `json:"slots"`
+}
+
+// LayoutFloor is the zones of one floor, by name.
+type LayoutFloor struct {
+	Floor string        `json:"floor"`
+	Zones []*LayoutZone `json:"zones"`
+}
+
+// LotLayout is a parking lot's slots grouped by floor and zone. Slots without a floor or
+// zone are grouped under an empty name.
+type LotLayout struct {
+	ParkingLotID int            `json:"parkingLotID"`
+	Floors       []*LayoutFloor `json:"floors"`
+}
+
+// SetSlotLayout places the given slots of a lot, replacing their previous placement.
+func (s *ParkingLotStorage) SetSlotLayout(ctx context.Context, parkingLotID int, placements []SlotPlacement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkBulkSize(len(placements)); err != nil {
+		return err
+	}
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool, len(placements))
+	numbers := make([]int64, len(placements))
+	floors := make([]string, len(placements))
+	zones := make([]string, len(placements))
+	labels := make([]string, len(placements))
+	xs := make([]sql.NullInt64, len(placements))
+	ys := make([]sql.NullInt64, len(placements))
+	for i, placement := range placements {
+		if seen[placement.SlotNumber] {
+			return invalidRequest("slot %d is placed more than once", placement.SlotNumber)
+		}
+		seen[placement.SlotNumber] = true
+		if len(placement.Floor) > maxLayoutFieldLength || len(placement.Zone) > maxLayoutFieldLength || len(placement.Label) > maxLayoutFieldLength {
+			return invalidRequest("floor, zone and label must be at most %d characters", maxLayoutFieldLength)
+		}
+
+		numbers[i] = int64(placement.SlotNumber)
+		floors[i], zones[i], labels[i] = placement.Floor, placement.Zone, placement.Label
+		if placement.X != nil {
+			xs[i] = sql.NullInt64{Int64: int64(*placement.X), Valid: true}
+		}
+		if placement.Y != nil {
+			ys[i] = sql.NullInt64{Int64: int64(*placement.Y), Valid: true}
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError("failed to set slot layout")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE parking_spaces
+		SET floor = NULLIF(placements.floor, ''), zone = NULLIF(placements.zone, ''), label = NULLIF(placements.label, ''),
+			pos_x = placements.x, pos_y = placements.y
+		FROM unnest($2::int[], $3::text[], $4::text[], $5::text[], $6::int[], $7::int[])
+			AS placements(number, floor, zone, label, x, y)
+		WHERE parking_spaces.lot_id = $1 AND parking_spaces.number = placements.number
+	`, parkingLotID, pq.Array(numbers), pq.Array(floors), pq.Array(zones), pq.Array(labels), pq.Array(xs), pq.Array(ys))
+	if err != nil {
+		return internalError("failed to set slot layout")
+	}
+	if n, _ := res.RowsAffected(); n != int64(len(placements)) {
+		return invalidRequest("lot %d has no slot for %d of the placements", parkingLotID, len(placements)-int(n))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return internalError("failed to set slot layout")
+	}
+
+	return nil
+}
+
+// GetLotLayout returns the slots of the specified lot grouped by floor and zone, with
+// their labels, types, coordinates and whether they are free, occupied or in maintenance.
+func (s *ParkingLotStorage) GetLotLayout(ctx context.Context, parkingLotID int) (*LotLayout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(floor, ''), COALESCE(zone, ''), number, COALESCE(label, ''), vehicle_type, pos_x, pos_y,
+			CASE WHEN in_maintenance THEN $2 WHEN occupied THEN $3 ELSE $4 END
+		FROM parking_spaces
+		WHERE lot_id = $1
+		ORDER BY 1, 2, number
+	`, parkingLotID, SlotStateMaintenance, SlotStateOccupied, SlotStateFree)
+	if err != nil {
+		return nil, internalError("failed to retrieve lot layout")
+	}
+	defer rows.Close()
+
+	layout := &LotLayout{ParkingLotID: parkingLotID, Floors: []*LayoutFloor{}}
+	var floor *LayoutFloor
+	var zone *LayoutZone
+	for rows.Next() {
+		var floorName, zoneName string
+		var x, y sql.NullInt64
+		var slot LayoutSlot
+		if err := rows.Scan(&floorName, &zoneName, &slot.SlotNumber, &slot.Label, &slot.VehicleType, &x, &y, &slot.State); err != nil {
+			return nil, internalError("failed to read lot layout")
+		}
+		if x.Valid {
+			slotX := int(x.Int64)
+			slot.X = &slotX
+		}
+		if y.Valid {
+			slotY := int(y.Int64)
+			slot.Y = &slotY
+		}
+
+		if floor == nil || floor.Floor != floorName {
+			floor = &LayoutFloor{Floor: floorName}
+			layout.Floors = append(layout.Floors, floor)
+			zone = nil
+		}
+		if zone == nil || zone.Zone != zoneName {
+			zone = &LayoutZone{Zone: zoneName}
+			floor.Zones = append(floor.Zones, zone)
+		}
+		zone.Slots = append(zone.Slots, &slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing lot layout")
+	}
+
+	return layout, nil
+}
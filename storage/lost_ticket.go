@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// DefaultLostTicketRefundWindow is how long after exit a lost-ticket overcharge can be refunded.
+const DefaultLostTicketRefundWindow = 30 * 24 * time.Hour
+
+var ErrAlreadyRefunded = &ParkingError{Code: CodeAlreadyRefunded, Status: http.StatusConflict, Message: "lost ticket fee already refunded"}
+
+// LostTicketRefund is the correction of a transaction charged the lost-ticket fee once
+// the ticket turns up. ChargedFee is what was paid and CorrectFee what the stay costs.
+type LostTicketRefund struct {
+	TransactionID     int       `json:"transactionID"`
+	OriginalEntryTime time.Time `json:"originalEntryTime"`
+	ChargedFee        int       `json:"chargedFee"`
+	CorrectFee        int       `json:"correctFee"`
+	Refund            int       `json:"refund"`
+}
+
+// SetLostTicketRefundWindow sets how long after exit a lost-ticket overcharge can be refunded.
+func (s *ParkingLotStorage) SetLostTicketRefundWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lostTicketRefundWindow = window
+}
+
+// RefundLostTicketDifference recomputes the fee of a transaction from the entry time on
+// the found ticket and refunds what was charged beyond it. The fee is recomputed as
+// unparking computes it, for the slots, validation and prepayment of the stay. The
+// transaction keeps the charged fee and refund for audit, and its fee and adjustments
+// become the correct ones.
+func (s *ParkingLotStorage) RefundLostTicketDifference(ctx context.Context, transactionID int, originalEntryTime time.Time) (*LostTicketRefund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if originalEntryTime.IsZero() {
+		return nil, invalidRequest("original entry time is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to refund lost ticket")
+	}
+	defer tx.Rollback()
+
+	stay := &parkedStay{EntryTime: originalEntryTime.UTC()}
+	var slot sql.NullInt64
+	var exitTime sql.NullTime
+	var chargedFee int
+	var refunded sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+		SELECT lot_id, vehicle_license_plate, slot, slots_required, exit_time, COALESCE(fee, 0), lost_ticket_refund,
+			prepaid_amount, prepaid_until, COALESCE(validation_code, '')
+		FROM parking_transactions
+		WHERE id = $1
+		FOR UPDATE
+	`, transactionID).Scan(&stay.ParkingLotID, &stay.LicensePlate, &slot, &stay.SlotsRequired, &exitTime, &chargedFee, &refunded,
+		&stay.PrepaidAmount, &stay.PrepaidUntil, &stay.ValidationCode)
+	if err == sql.ErrNoRows {
+		return nil, newError(CodeTransactionNotFound, http.StatusNotFound, "transaction %d not found", transactionID)
+	}
+	if err != nil {
+		return nil, internalError("failed to look up transaction")
+	}
+	if refunded.Valid {
+		return nil, ErrAlreadyRefunded
+	}
+	if !exitTime.Valid {
+		return nil, invalidRequest("transaction %d has no exit time", transactionID)
+	}
+	if !slot.Valid {
+		return nil, invalidRequest("transaction %d has no slot to recompute its fee for", transactionID)
+	}
+	stay.FirstSlot = int(slot.Int64)
+	if !stay.EntryTime.Before(exitTime.Time) {
+		return nil, invalidRequest("original entry time must be before the exit time")
+	}
+
+	window := s.lostTicketRefundWindow
+	if window <= 0 {
+		window = DefaultLostTicketRefundWindow
+	}
+	if s.now().Sub(exitTime.Time) > window {
+		return nil, invalidRequest("lost ticket refunds must be claimed within %d days of exit", int(window.Hours()/24))
+	}
+
+	fee, err := s.stayFee(ctx, tx, stay, exitTime.Time)
+	if err != nil {
+		return nil, err
+	}
+	if fee.Fee >= chargedFee {
+		return nil, invalidRequest("the lost ticket fee did not exceed the fee for the stay")
+	}
+
+	refund := &LostTicketRefund{
+		TransactionID:     transactionID,
+		OriginalEntryTime: originalEntryTime,
+		ChargedFee:        chargedFee,
+		CorrectFee:        fee.Fee,
+		Refund:            chargedFee - fee.Fee,
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE parking_transactions
+		SET fee = $2, lost_ticket_fee = $3, lost_ticket_refund = $4, original_entry_time = $5,
+			fee_before_clamp = $6, employee = $7, validated_amount = $8, refund = $9
+		WHERE id = $1
+	`, transactionID, refund.CorrectFee, refund.ChargedFee, refund.Refund, stay.EntryTime,
+		fee.feeBeforeClamp(), fee.Employee, fee.Validated, fee.Refund)
+	if err != nil {
+		return nil, internalError("failed to record lost ticket refund")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to refund lost ticket")
+	}
+
+	lot, err := s.lotMeta(ctx, stay.ParkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	inLotZone(lot, &refund.OriginalEntryTime)
+
+	return refund, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestRefundLostTicketDifference(t *testing.T) {
+	exitTime := at(-time.Hour)
+	originalEntryTime := exitTime.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name          string
+		slot          driver.Value
+		slotsRequired int
+		employee      bool
+		wantFee       int
+		wantErr       bool
+	}{
+		{"one slot", int64(3), 1, false, 20, false},
+		{"oversized vehicle", int64(3), 2, false, 40, false},
+		{"employee", int64(3), 1, true, 0, false},
+		{"no slot", nil, 1, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _ := newFakeStorage(
+				lotResult(testLot()),
+				fakeResult{match: "FOR UPDATE", rows: [][]driver.Value{{
+					int64(1), "ABC123", tt.slot, int64(tt.slotsRequired), exitTime, int64(200), nil,
+					int64(0), nil, "",
+				}}},
+				fakeResult{match: "FROM fee_strategy_changes"},
+				fakeResult{match: "FROM employee_plates", rows: [][]driver.Value{{tt.employee}}},
+				fakeResult{match: "UPDATE parking_transactions", rowsAffected: 1},
+			)
+
+			refund, err := s.RefundLostTicketDifference(context.Background(), 7, originalEntryTime)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("RefundLostTicketDifference = %+v, want an error", refund)
+				}
+				if n := db.count("UPDATE parking_transactions"); n != 0 {
+					t.Errorf("transaction updated %d times, want none", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RefundLostTicketDifference: %v", err)
+			}
+			if refund.CorrectFee != tt.wantFee || refund.Refund != 200-tt.wantFee {
+				t.Errorf("refund = %+v, want correct fee %d and refund %d", refund, tt.wantFee, 200-tt.wantFee)
+			}
+
+			update, _ := db.last("UPDATE parking_transactions")
+			if fee, employee := update.args[1], update.args[6]; fee != int64(tt.wantFee) || employee != tt.employee {
+				t.Errorf("recorded fee %v and employee %v, want %d and %v", fee, employee, tt.wantFee, tt.employee)
+			}
+		})
+	}
+}
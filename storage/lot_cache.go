@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// lotMeta is the configuration of a parking lot that hot paths read on every call.
+type lotMeta struct {
+	TotalSpaces        int
+	DefaultVehicleType string
+	AllocationStrategy string
+	FeeStrategy        string
+	NegativeFeePolicy  string
+	SlotPriority       string
+	Currency           string
+	// OverflowLotID is 0 when the lot has no overflow lot.
+	OverflowLotID int
+	// MaintenanceGrace is nil when the lot follows the server-wide setting.
+	MaintenanceGrace *bool
+	// OpensAtMinute and ClosesAtMinute are nil when the lot never closes.
+	OpensAtMinute   *int
+	ClosesAtMinute  *int
+	LastCallMinutes int
+	OrgID           int
+	// Location is the lot's timezone. Times in responses about the lot are given in it.
+	Location *time.Location
+}
+
+// lotCache is a concurrency-safe read-through cache of lot metadata keyed by lot ID.
+// Entries are dropped whenever the lot's configuration changes.
+type lotCache struct {
+	mu   sync.RWMutex
+	lots map[int]lotMeta
+}
+
+func newLotCache() *lotCache {
+	return &lotCache{lots: make(map[int]lotMeta)}
+}
+
+func (c *lotCache) get(parkingLotID int) (lotMeta, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	meta, ok := c.lots[parkingLotID]
+	return meta, ok
+}
+
+func (c *lotCache) put(parkingLotID int, meta lotMeta) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lots[parkingLotID] = meta
+}
+
+func (c *lotCache) invalidate(parkingLotID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.lots, parkingLotID)
+}
+
+// lotMeta returns the metadata of the specified lot, querying it only when it is not
+// cached. It fails with ErrLotNotFound when the lot does not exist.
+func (s *ParkingLotStorage) lotMeta(ctx context.Context, parkingLotID int) (lotMeta, error) {
+	if meta, ok := s.lots.get(parkingLotID); ok {
+		return meta, nil
+	}
+
+	var meta lotMeta
+	var timezone string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT total_spaces, default_vehicle_type, allocation_strategy, fee_strategy, negative_fee_policy, COALESCE(overflow_lot_id, 0), maintenance_grace,
+			opens_at_minute, closes_at_minute, last_call_minutes, COALESCE(org_id, 0), slot_priority, currency, timezone
+		FROM parking_lots WHERE id = $1
+	`, parkingLotID).Scan(&meta.TotalSpaces, &meta.DefaultVehicleType, &meta.AllocationStrategy, &meta.FeeStrategy, &meta.NegativeFeePolicy, &meta.OverflowLotID, &meta.MaintenanceGrace,
+		&meta.OpensAtMinute, &meta.ClosesAtMinute, &meta.LastCallMinutes, &meta.OrgID, &meta.SlotPriority, &meta.Currency, &timezone)
+	if err == sql.ErrNoRows {
+		return lotMeta{}, ErrLotNotFound
+	}
+	if err != nil {
+		return lotMeta{}, internalError("failed to retrieve parking lot")
+	}
+	meta.Location, err = time.LoadLocation(timezone)
+	if err != nil {
+		return lotMeta{}, internalError("unknown timezone " + timezone)
+	}
+
+	s.lots.put(parkingLotID, meta)
+	return meta, nil
+}
+
+// maintenanceGraceFor reports whether time spent out of service is excluded from fees in
+// the lot, falling back to the server-wide setting.
+func (s *ParkingLotStorage) maintenanceGraceFor(lot lotMeta) bool {
+	if lot.MaintenanceGrace != nil {
+		return *lot.MaintenanceGrace
+	}
+	return s.maintenanceGrace
+}
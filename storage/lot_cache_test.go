@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// lotMetaQuery is part of the query lotMeta reads uncached lots with.
+const lotMetaQuery = "SELECT total_spaces, default_vehicle_type"
+
+// parkAndUnparkStorage returns a fake storage that parks and unparks one vehicle in lot 1.
+func parkAndUnparkStorage() (*ParkingLotStorage, *fakeDB) {
+	results := []fakeResult{lotResult(testLot()), {match: "UPDATE parking_lots SET allocation_strategy", rowsAffected: 1}}
+	results = append(results, parkResults()...)
+	results = append(results, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)
+	s, db, _ := newFakeStorage(results...)
+	return s, db
+}
+
+func TestLotMetaIsCachedUntilInvalidated(t *testing.T) {
+	ctx := context.Background()
+	s, db := parkAndUnparkStorage()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{}); err != nil {
+			t.Fatalf("ParkVehicle: %v", err)
+		}
+		if _, err := s.UnparkVehicle(ctx, 1, "ABC123", ""); err != nil {
+			t.Fatalf("UnparkVehicle: %v", err)
+		}
+	}
+	if n := db.count(lotMetaQuery); n != 1 {
+		t.Errorf("lot metadata queried %d times across repeated parks and unparks, want 1", n)
+	}
+
+	if err := s.SetAllocationStrategy(ctx, 1, StrategyRoundRobin); err != nil {
+		t.Fatalf("SetAllocationStrategy: %v", err)
+	}
+	if _, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{}); err != nil {
+		t.Fatalf("ParkVehicle: %v", err)
+	}
+	if _, err := s.UnparkVehicle(ctx, 1, "ABC123", ""); err != nil {
+		t.Fatalf("UnparkVehicle: %v", err)
+	}
+	if n := db.count(lotMetaQuery); n != 2 {
+		t.Errorf("lot metadata queried %d times after a configuration change, want 2", n)
+	}
+}
+
+func BenchmarkParkAndUnpark(b *testing.B) {
+	ctx := context.Background()
+	s, db := parkAndUnparkStorage()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{}); err != nil {
+			b.Fatalf("ParkVehicle: %v", err)
+		}
+		if _, err := s.UnparkVehicle(ctx, 1, "ABC123", ""); err != nil {
+			b.Fatalf("UnparkVehicle: %v", err)
+		}
+	}
+	b.ReportMetric(float64(db.count(lotMetaQuery))/float64(b.N), "lotqueries/op")
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// LotConfig holds the configurable settings of a parking lot.
+type LotConfig struct {
+	ParkingLotID       int            `json:"parkingLotID"`
+	TotalSpaces        int            `json:"totalSpaces"`
+	SlotTypeCounts     map[string]int `json:"slotTypeCounts"`
+	DefaultVehicleType string         `json:"defaultVehicleType"`
+	AllocationStrategy string         `json:"allocationStrategy"`
+	FeeStrategy        string         `json:"feeStrategy"`
+	NegativeFeePolicy  string         `json:"negativeFeePolicy"`
+	SlotPriority       string         `json:"slotPriority"`
+	OverflowLotID      int            `json:"overflowLotID,omitempty"`
+	HourlyRate         int            `json:"hourlyRate"`
+	// Currency is empty when the lot charges the rates as they are. Amounts are integers
+	// in the currency's minor unit, which has MinorUnits decimal places.
+	Currency         string `json:"currency,omitempty"`
+	MinorUnits       int    `json:"minorUnits"`
+	MaintenanceGrace bool   `json:"maintenanceGrace"`
+	// Timezone is the zone in which fees are split into calendar days and operating
+	// hours are given.
+	Timezone       string          `json:"timezone"`
+	OperatingHours *OperatingHours `json:"operatingHours,omitempty"`
+}
+
+// GetLotConfig returns the configuration of the specified parking lot.
+func (s *ParkingLotStorage) GetLotConfig(ctx context.Context, parkingLotID int) (*LotConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &LotConfig{
+		ParkingLotID:       parkingLotID,
+		TotalSpaces:        lot.TotalSpaces,
+		SlotTypeCounts:     make(map[string]int),
+		DefaultVehicleType: lot.DefaultVehicleType,
+		AllocationStrategy: lot.AllocationStrategy,
+		FeeStrategy:        lot.FeeStrategy,
+		NegativeFeePolicy:  lot.NegativeFeePolicy,
+		SlotPriority:       lot.SlotPriority,
+		OverflowLotID:      lot.OverflowLotID,
+		HourlyRate:         ParkingFeeperHour * minorUnitScale(lot.Currency),
+		Currency:           lot.Currency,
+		MinorUnits:         minorUnits(lot.Currency),
+		MaintenanceGrace:   s.maintenanceGraceFor(lot),
+		Timezone:           lot.Location.String(),
+		OperatingHours:     operatingHours(lot),
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT vehicle_type, COUNT(*)
+		FROM parking_spaces
+		WHERE lot_id = $1
+		GROUP BY vehicle_type
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve slot types")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vehicleType string
+		var count int
+		if err := rows.Scan(&vehicleType, &count); err != nil {
+			return nil, internalError("failed to read slot types")
+		}
+		config.SlotTypeCounts[vehicleType] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing slot types")
+	}
+
+	return config, nil
+}
+
+// SetOverflowLot sets the lot vehicles are redirected to when the specified lot is full.
+// An overflowLotID of 0 removes the overflow lot.
+func (s *ParkingLotStorage) SetOverflowLot(ctx context.Context, parkingLotID, overflowLotID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if overflowLotID == parkingLotID {
+		return invalidRequest("a lot cannot overflow into itself")
+	}
+	if overflowLotID != 0 {
+		if _, err := s.lotMeta(ctx, overflowLotID); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE parking_lots SET overflow_lot_id = NULLIF($2, 0) WHERE id = $1", parkingLotID, overflowLotID)
+	if err != nil {
+		return internalError("failed to set overflow lot")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// SetLotMaintenanceGrace sets whether time a vehicle's slot spends out of service is
+// excluded from its fee in the specified lot, overriding the server-wide setting.
+func (s *ParkingLotStorage) SetLotMaintenanceGrace(ctx context.Context, parkingLotID int, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE parking_lots SET maintenance_grace = $2 WHERE id = $1", parkingLotID, enabled)
+	if err != nil {
+		return internalError("failed to set maintenance grace")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// SetLotTimezone sets the IANA timezone, e.g. "Asia/Dhaka", that times in responses about
+// the specified lot are given in and its days are counted in. The lot's report snapshots
+// are for days in the old timezone, so they are dropped and those days computed live.
+func (s *ParkingLotStorage) SetLotTimezone(ctx context.Context, parkingLotID int, timezone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timezone == "" || timezone == "Local" {
+		return invalidRequest("unknown timezone %q", timezone)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return invalidRequest("unknown timezone %q", timezone)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError("failed to set timezone")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE parking_lots SET timezone = $2 WHERE id = $1", parkingLotID, timezone)
+	if err != nil {
+		return internalError("failed to set timezone")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM daily_stats WHERE lot_id = $1", parkingLotID); err != nil {
+		return internalError("failed to set timezone")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return internalError("failed to set timezone")
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// inLotZone converts the given times to the lot's timezone, so that responses give them
+// with the lot's offset however the database hands them back. Nil and zero times are
+// left alone.
+func inLotZone(lot lotMeta, times ...*time.Time) {
+	for _, t := range times {
+		if t != nil && !t.IsZero() {
+			*t = t.In(lot.Location)
+		}
+	}
+}
+
+// inZoneOfLot is inLotZone for the lot with the specified ID, for listings spanning lots.
+func (s *ParkingLotStorage) inZoneOfLot(ctx context.Context, parkingLotID int, times ...*time.Time) error {
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return err
+	}
+	inLotZone(lot, times...)
+	return nil
+}
+
+// localDate is the SQL date of the timestamp column on the calendar of zone, an SQL
+// expression naming a timezone such as a parameter or parking_lots.timezone. Timestamps
+// are stored in UTC without a time zone.
+func localDate(column, zone string) string {
+	return "DATE(" + column + " AT TIME ZONE 'UTC' AT TIME ZONE " + zone + ")"
+}
+
+// lotDay turns a date read from the database, which comes back as midnight UTC, into
+// midnight of that day in the lot's timezone.
+func lotDay(lot lotMeta, date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, lot.Location)
+}
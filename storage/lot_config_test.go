@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseTimesInLotTimezone(t *testing.T) {
+	tests := []struct {
+		timezone  string
+		wantEntry string
+	}{
+		{"UTC", "2024-03-01T10:00:00Z"},
+		{"Asia/Dhaka", "2024-03-01T16:00:00+06:00"},
+		{"America/New_York", "2024-03-01T05:00:00-05:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timezone, func(t *testing.T) {
+			ctx := context.Background()
+			lot := testLot()
+			location, err := time.LoadLocation(tt.timezone)
+			if err != nil {
+				t.Fatalf("LoadLocation: %v", err)
+			}
+			lot.Location = location
+			s, _, _ := newFakeStorage(append([]fakeResult{
+				lotResult(lot),
+				{match: "UPPER(TRANSLATE(", rows: [][]driver.Value{{int64(1), int64(1), "ABC123", testTime}}},
+				{match: "parking_lots.version", rows: [][]driver.Value{{int64(10), int64(1), 10.0, int64(1)}}},
+				{match: "LEFT JOIN parked_vehicles", rows: [][]driver.Value{{int64(1), true, testTime, "ABC123", "", ""}}},
+				{match: "SELECT number, in_maintenance", rows: [][]driver.Value{{int64(1), false, "", true, testTime, DefaultVehicleType}}},
+			}, parkResults()...)...)
+
+			ticket, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{})
+			if err != nil {
+				t.Fatalf("ParkVehicle: %v", err)
+			}
+			status, err := s.IsVehicleParked(ctx, "ABC123")
+			if err != nil {
+				t.Fatalf("IsVehicleParked: %v", err)
+			}
+			lotStatus, err := s.ViewParkingLotStatus(ctx, 1)
+			if err != nil {
+				t.Fatalf("ViewParkingLotStatus: %v", err)
+			}
+			parkingLot, err := s.GetParkingLot(ctx, 1)
+			if err != nil {
+				t.Fatalf("GetParkingLot: %v", err)
+			}
+
+			responses := map[string]interface{}{
+				"ticket":       ticket,
+				"plate status": status.Locations[0],
+				"lot status":   lotStatus.ParkedVehicles[1],
+				"parking lot":  parkingLot.Spaces[0],
+			}
+			for name, response := range responses {
+				body, err := json.Marshal(response)
+				if err != nil {
+					t.Fatalf("marshal %s: %v", name, err)
+				}
+				var times struct {
+					EntryTime string `json:"entryTime"`
+				}
+				if err := json.Unmarshal(body, &times); err != nil {
+					t.Fatalf("unmarshal %s: %v", name, err)
+				}
+				if times.EntryTime != tt.wantEntry {
+					t.Errorf("%s entry time = %s, want %s", name, times.EntryTime, tt.wantEntry)
+				}
+				if entry, err := time.Parse(time.RFC3339, times.EntryTime); err != nil || !entry.Equal(testTime) {
+					t.Errorf("%s entry time %s parsed as %v, %v, want %v", name, times.EntryTime, entry, err, testTime)
+				}
+			}
+		})
+	}
+}
+
+func TestSetLotTimezone(t *testing.T) {
+	ctx := context.Background()
+	s, db, _ := newFakeStorage(lotResult(testLot()), fakeResult{match: "SET timezone", rowsAffected: 1}, fakeResult{match: "DELETE FROM daily_stats"})
+
+	for _, timezone := range []string{"", "Local", "Mars/Olympus_Mons", "+06:00"} {
+		var parkingErr *ParkingError
+		if err := s.SetLotTimezone(ctx, 1, timezone); !errors.As(err, &parkingErr) || parkingErr.Code != CodeInvalidRequest {
+			t.Errorf("SetLotTimezone(%q) error = %v, want an invalid request", timezone, err)
+		}
+	}
+	if n := db.count("SET timezone"); n != 0 {
+		t.Errorf("unknown timezone written to the database")
+	}
+
+	if _, err := s.lotMeta(ctx, 1); err != nil {
+		t.Fatalf("lotMeta: %v", err)
+	}
+	if err := s.SetLotTimezone(ctx, 1, "Asia/Dhaka"); err != nil {
+		t.Fatalf("SetLotTimezone: %v", err)
+	}
+	if _, err := s.lotMeta(ctx, 1); err != nil {
+		t.Fatalf("lotMeta: %v", err)
+	}
+	if n := db.count(lotMetaQuery); n != 2 {
+		t.Errorf("lot metadata queried %d times, want it reloaded after the timezone changed", n)
+	}
+	if n := db.count("DELETE FROM daily_stats"); n != 1 {
+		t.Errorf("report snapshots dropped %d times, want them dropped for the old timezone", n)
+	}
+}
+
+func TestLotWithUnknownTimezone(t *testing.T) {
+	result := lotResult(testLot())
+	result.rows[0][len(result.rows[0])-1] = "Mars/Olympus_Mons"
+	s, _, _ := newFakeStorage(result)
+
+	if _, err := s.GetLotConfig(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "timezone") {
+		t.Errorf("GetLotConfig error = %v, want the unknown timezone reported", err)
+	}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+)
+
+// GetLotVersion returns the version of the specified lot, which changes whenever its
+// status does.
+func (s *ParkingLotStorage) GetLotVersion(ctx context.Context, parkingLotID int) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var version int64
+	err := s.db.QueryRowContext(ctx, "SELECT version FROM parking_lots WHERE id = $1", parkingLotID).Scan(&version)
+	if err != nil {
+		return 0, ErrLotNotFound
+	}
+
+	return version, nil
+}
+
+// bumpLotVersion marks the status of a lot as changed. It is called in the transaction
+// that parks or unparks a vehicle or changes the maintenance of a slot.
+func bumpLotVersion(ctx context.Context, q querier, parkingLotID int) error {
+	_, err := q.ExecContext(ctx, "UPDATE parking_lots SET version = version + 1 WHERE id = $1", parkingLotID)
+	return err
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// timeWindow is a half-open interval of time.
+type timeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Maintenance modes. Both keep new vehicles out of a slot and let its current occupant
+// leave, but only time spent out of service counts towards the maintenance grace.
+const (
+	MaintenanceBlockingEntry = "blocking-entry"
+	MaintenanceOutOfService  = "out-of-service"
+)
+
+// MaintenanceDetails describes how a slot is in maintenance, why and until when.
+type MaintenanceDetails struct {
+	Mode         string
+	Reason       string
+	ScheduledEnd time.Time
+}
+
+// MaintenanceSlot is a parking space that is currently in maintenance.
+type MaintenanceSlot struct {
+	SlotNumber   int        `json:"slotNumber"`
+	Mode         string     `json:"mode"`
+	Since        *time.Time `json:"since,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	ScheduledEnd *time.Time `json:"scheduledEnd,omitempty"`
+}
+
+// GetMaintenanceSlots returns the slots of the specified lot that are in maintenance,
+// ordered by slot number.
+func (s *ParkingLotStorage) GetMaintenanceSlots(ctx context.Context, parkingLotID int) ([]*MaintenanceSlot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parking_spaces.number, COALESCE(parking_spaces.maintenance_mode, $2), maintenance_windows.started_at, COALESCE(maintenance_windows.reason, ''), maintenance_windows.scheduled_end
+		FROM parking_spaces
+		LEFT JOIN maintenance_windows ON maintenance_windows.lot_id = parking_spaces.lot_id
+			AND maintenance_windows.slot = parking_spaces.number
+			AND maintenance_windows.ended_at IS NULL
+		WHERE parking_spaces.lot_id = $1 AND parking_spaces.in_maintenance
+		ORDER BY parking_spaces.number
+	`, parkingLotID, MaintenanceOutOfService)
+	if err != nil {
+		return nil, internalError("failed to retrieve maintenance slots")
+	}
+	defer rows.Close()
+
+	slots := []*MaintenanceSlot{}
+	for rows.Next() {
+		var slot MaintenanceSlot
+		var since, scheduledEnd sql.NullTime
+		if err := rows.Scan(&slot.SlotNumber, &slot.Mode, &since, &slot.Reason, &scheduledEnd); err != nil {
+			return nil, internalError("failed to read maintenance slots")
+		}
+		if since.Valid {
+			slot.Since = &since.Time
+		}
+		if scheduledEnd.Valid {
+			slot.ScheduledEnd = &scheduledEnd.Time
+		}
+		inLotZone(lot, slot.Since, slot.ScheduledEnd)
+		slots = append(slots, &slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing maintenance slots")
+	}
+
+	return slots, nil
+}
+
+// ClearAllMaintenance takes every slot of the specified lot out of maintenance, closing
+// their maintenance windows, and returns the number of slots cleared.
+func (s *ParkingLotStorage) ClearAllMaintenance(ctx context.Context, parkingLotID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, internalError("failed to clear maintenance")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE parking_spaces
+		SET in_maintenance = false, maintenance_mode = NULL
+		WHERE lot_id = $1 AND in_maintenance
+		RETURNING number
+	`, parkingLotID)
+	if err != nil {
+		return 0, internalError("failed to clear maintenance")
+	}
+
+	cleared, err := scanSlotNumbers(rows)
+	if err != nil {
+		return 0, internalError("failed to clear maintenance")
+	}
+	if err := recordSlotStates(ctx, tx, parkingLotID, cleared, SlotActionMaintenanceClear, s.now()); err != nil {
+		return 0, internalError("failed to clear maintenance")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE maintenance_windows
+		SET ended_at = $2
+		WHERE lot_id = $1 AND ended_at IS NULL
+	`, parkingLotID, s.now())
+	if err != nil {
+		return 0, internalError("failed to record maintenance window")
+	}
+
+	if len(cleared) > 0 {
+		if err := bumpLotVersion(ctx, tx, parkingLotID); err != nil {
+			return 0, internalError("failed to clear maintenance")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, internalError("failed to clear maintenance")
+	}
+
+	return len(cleared), nil
+}
+
+// recordMaintenanceWindow opens or closes the maintenance window of a slot when its
+// maintenance flag changes. A change of mode while in maintenance starts a new window.
+func recordMaintenanceWindow(ctx context.Context, tx querier, parkingLotID, slotNumber int, wasMode, mode string, details MaintenanceDetails, at time.Time) error {
+	if wasMode == mode {
+		return nil
+	}
+
+	if wasMode != "" {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE maintenance_windows
+			SET ended_at = $3
+			WHERE lot_id = $1 AND slot = $2 AND ended_at IS NULL
+		`, parkingLotID, slotNumber, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mode == "" {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO maintenance_windows (lot_id, slot, started_at, reason, scheduled_end, mode)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+	`, parkingLotID, slotNumber, at, details.Reason, nullTime(details.ScheduledEnd), mode)
+	return err
+}
+
+func validMaintenanceMode(mode string) bool {
+	return mode == MaintenanceBlockingEntry || mode == MaintenanceOutOfService
+}
+
+// slotWindow is an out-of-service window of one slot.
+type slotWindow struct {
+	Slot int
+	timeWindow
+}
+
+// maintenanceWindows returns the out-of-service windows of the lot's slots that overlap
+// [from, to). Windows that are still open end at to.
+func maintenanceWindows(ctx context.Context, q querier, parkingLotID int, from, to time.Time) ([]slotWindow, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT slot, started_at, COALESCE(ended_at, $3)
+		FROM maintenance_windows
+		WHERE lot_id = $1 AND mode = $4
+		AND started_at < $3 AND (ended_at IS NULL OR ended_at > $2)
+	`, parkingLotID, from, to, MaintenanceOutOfService)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []slotWindow
+	for rows.Next() {
+		var window slotWindow
+		if err := rows.Scan(&window.Slot, &window.Start, &window.End); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	return windows, rows.Err()
+}
+
+// windowsOfSlots returns the windows of the slotsRequired slots starting at firstSlot.
+func windowsOfSlots(windows []slotWindow, firstSlot, slotsRequired int) []timeWindow {
+	var of []timeWindow
+	for _, window := range windows {
+		if window.Slot >= firstSlot && window.Slot < firstSlot+slotsRequired {
+			of = append(of, window.timeWindow)
+		}
+	}
+	return of
+}
+
+// maintenanceOverlap returns how much of [from, to) is covered by the given windows.
+// Overlapping windows, e.g. on two slots of an oversized vehicle, are only counted once.
+func maintenanceOverlap(from, to time.Time, windows []timeWindow) time.Duration {
+	var total time.Duration
+	for _, window := range mergeWindows(from, to, windows) {
+		total += window.End.Sub(window.Start)
+	}
+
+	return total
+}
+
+// billableWindows returns the parts of [from, to) not covered by the excluded windows,
+// in order.
+func billableWindows(from, to time.Time, excluded []timeWindow) []timeWindow {
+	var billable []timeWindow
+	start := from
+	for _, window := range mergeWindows(from, to, excluded) {
+		if window.Start.After(start) {
+			billable = append(billable, timeWindow{Start: start, End: window.Start})
+		}
+		start = window.End
+	}
+	if to.After(start) {
+		billable = append(billable, timeWindow{Start: start, End: to})
+	}
+
+	return billable
+}
+
+// mergeWindows clips the given windows to [from, to) and merges the overlapping ones,
+// returning them in order.
+func mergeWindows(from, to time.Time, windows []timeWindow) []timeWindow {
+	var clipped []timeWindow
+	for _, window := range windows {
+		if window.Start.Before(from) {
+			window.Start = from
+		}
+		if window.End.After(to) {
+			window.End = to
+		}
+		if window.End.After(window.Start) {
+			clipped = append(clipped, window)
+		}
+	}
+
+	sort.Slice(clipped, func(i, j int) bool {
+		return clipped[i].Start.Before(clipped[j].Start)
+	})
+
+	var merged []timeWindow
+	for _, window := range clipped {
+		if n := len(merged); n > 0 && !window.Start.After(merged[n-1].End) {
+			if window.End.After(merged[n-1].End) {
+				merged[n-1].End = window.End
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+
+	return merged
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestToggleMaintenanceTwiceIsNoOp(t *testing.T) {
+	s, db, _ := newFakeStorage(
+		lotResult(testLot()),
+		// The slot is in service until the first toggle puts it into maintenance.
+		fakeResult{match: "FOR UPDATE", rows: [][]driver.Value{{""}}, times: 1},
+		fakeResult{match: "FOR UPDATE", rows: [][]driver.Value{{MaintenanceOutOfService}}},
+		fakeResult{match: "SET in_maintenance", rowsAffected: 1},
+		fakeResult{match: "INSERT INTO slot_state_changes", rowsAffected: 1},
+		fakeResult{match: "INSERT INTO maintenance_windows", rowsAffected: 1},
+		fakeResult{match: "UPDATE parking_lots SET version", rowsAffected: 1},
+	)
+
+	for i, want := range []bool{true, false} {
+		changed, err := s.ToggleMaintenance(context.Background(), 1, 1, true, MaintenanceDetails{})
+		if err != nil {
+			t.Fatalf("ToggleMaintenance %d: %v", i+1, err)
+		}
+		if changed != want {
+			t.Errorf("toggle %d changed = %v, want %v", i+1, changed, want)
+		}
+	}
+
+	for _, statement := range []string{"SET in_maintenance", "INSERT INTO maintenance_windows", "INSERT INTO slot_state_changes"} {
+		if n := db.count(statement); n != 1 {
+			t.Errorf("%q executed %d times, want once", statement, n)
+		}
+	}
+}
+
+func TestMaintenanceWinsSlotFromInFlightPark(t *testing.T) {
+	ctx := context.Background()
+	s, db, _ := newFakeStorage(append([]fakeResult{
+		lotResult(testLot()),
+		{match: "FOR UPDATE", rows: [][]driver.Value{{""}}},
+		{match: "SET in_maintenance", rowsAffected: 1},
+		{match: "INSERT INTO slot_state_changes", rowsAffected: 1},
+		{match: "INSERT INTO maintenance_windows", rowsAffected: 1},
+		{match: "UPDATE parking_lots SET version", rowsAffected: 1},
+		// The park picked slot 1 before it entered maintenance, then slot 2.
+		{match: "WITH free_spaces", rows: [][]driver.Value{{int64(1)}}, times: 1},
+		{match: "WITH free_spaces", rows: [][]driver.Value{{int64(2)}}},
+		{match: "SET occupied = true", times: 1},
+	}, parkResults()...)...)
+
+	if _, err := s.ToggleMaintenance(ctx, 1, 1, true, MaintenanceDetails{}); err != nil {
+		t.Fatalf("ToggleMaintenance: %v", err)
+	}
+	ticket, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{})
+	if err != nil {
+		t.Fatalf("ParkVehicle: %v", err)
+	}
+	if ticket.SlotNumber != 2 {
+		t.Errorf("parked in slot %d, want slot 2 after slot 1 entered maintenance", ticket.SlotNumber)
+	}
+
+	// The occupying update re-checks maintenance once the toggle's row lock is released.
+	var occupy []fakeStatement
+	for _, statement := range db.statements {
+		if strings.Contains(statement.query, "SET occupied = true") {
+			occupy = append(occupy, statement)
+		}
+	}
+	if len(occupy) != 2 || !strings.Contains(occupy[0].query, "NOT in_maintenance") {
+		t.Fatalf("occupying statements %+v, want two that skip slots in maintenance", occupy)
+	}
+	if first := occupy[0].args[1]; first != int64(1) {
+		t.Errorf("first attempt occupied slot %v, want 1", first)
+	}
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// maxHeatmapRange bounds the period a heatmap is computed over.
+const maxHeatmapRange = 366 * 24 * time.Hour
+
+// OccupancyHeatmap holds the average occupancy percentage of a parking lot for each
+// weekday (0 is Sunday) and hour of the day in UTC.
+type OccupancyHeatmap struct {
+	ParkingLotID int            `json:"parking_lot_id"`
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	Cells        [7][24]float64 `json:"cells"`
+}
+
+// GetOccupancyHeatmap computes a weekday-by-hour grid of average occupancy from the
+// completed transactions overlapping each hour between from and to.
+func (s *ParkingLotStorage) GetOccupancyHeatmap(ctx context.Context, parkingLotID int, from, to time.Time) (*OccupancyHeatmap, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from.IsZero() || to.IsZero() || !from.Before(to) {
+		return nil, invalidRequest("from must be before to")
+	}
+	if to.Sub(from) > maxHeatmapRange {
+		return nil, invalidRequest("period must not exceed %d days", int(maxHeatmapRange.Hours()/24))
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	totalSpaces := lot.TotalSpaces
+
+	heatmap := &OccupancyHeatmap{ParkingLotID: parkingLotID, From: from, To: to}
+	inLotZone(lot, &heatmap.From, &heatmap.To)
+	if totalSpaces == 0 {
+		return heatmap, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH hours AS (
+			SELECT generate_series(date_trunc('hour', $2::timestamp), $3::timestamp - INTERVAL '1 hour', INTERVAL '1 hour') AS hour_start
+		), usage AS (
+			SELECT
+				hours.hour_start,
+				COALESCE(SUM(EXTRACT(EPOCH FROM
+					LEAST(parking_transactions.exit_time, hours.hour_start + INTERVAL '1 hour') -
+					GREATEST(parking_transactions.entry_time, hours.hour_start)
+				)), 0) AS occupied_seconds
+			FROM hours
+			LEFT JOIN parking_transactions ON parking_transactions.lot_id = $1
+				AND parking_transactions.entry_time < hours.hour_start + INTERVAL '1 hour'
+				AND parking_transactions.exit_time > hours.hour_start
+			GROUP BY hours.hour_start
+		)
+		SELECT
+			EXTRACT(DOW FROM hour_start)::int AS weekday,
+			EXTRACT(HOUR FROM hour_start)::int AS hour,
+			AVG(occupied_seconds) / 3600 / $4 * 100 AS occupancy
+		FROM usage
+		GROUP BY weekday, hour
+	`, parkingLotID, from.UTC(), to.UTC(), totalSpaces)
+	if err != nil {
+		return nil, internalError("failed to compute occupancy heatmap")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var weekday, hour int
+		var occupancy float64
+		if err := rows.Scan(&weekday, &hour, &occupancy); err != nil {
+			return nil, internalError("failed to read occupancy heatmap")
+		}
+		heatmap.Cells[weekday][hour] = occupancy
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing occupancy heatmap")
+	}
+
+	return heatmap, nil
+}
+
+const (
+	// waitEstimateHistory is how far back completed stays are averaged for wait estimates.
+	waitEstimateHistory = 30 * 24 * time.Hour
+	// minWaitEstimateSamples is the fewest completed stays a wait estimate is based on.
+	minWaitEstimateSamples = 5
+)
+
+const (
+	WaitAvailable = "available"
+	WaitEstimated = "estimated"
+	WaitUnknown   = "unknown"
+)
+
+// WaitEstimate is the expected time until a slot frees up in a parking lot.
+type WaitEstimate struct {
+	ParkingLotID       int     `json:"parkingLotID"`
+	Status             string  `json:"status"`
+	WaitMinutes        *int    `json:"waitMinutes,omitempty"`
+	AverageStayMinutes float64 `json:"averageStayMinutes,omitempty"`
+	Reason             string  `json:"reason,omitempty"`
+}
+
+// EstimateWaitTime estimates how long until the next vacancy in the specified lot, assuming
+// the longest parked vehicle leaves once it reaches the lot's recent average stay.
+func (s *ParkingLotStorage) EstimateWaitTime(ctx context.Context, parkingLotID int) (*WaitEstimate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	var freeSlots, samples int
+	var averageStaySeconds sql.NullFloat64
+	var oldestEntry sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM parking_spaces
+				WHERE lot_id = $1 AND NOT occupied AND NOT in_maintenance AND `+notHeld("$2")+`),
+			(SELECT MIN(entry_time) FROM parking_spaces WHERE lot_id = $1 AND occupied),
+			COUNT(*),
+			AVG(EXTRACT(EPOCH FROM (exit_time - entry_time)))
+		FROM parking_transactions
+		WHERE lot_id = $1 AND exit_time >= $3
+	`, parkingLotID, now, now.Add(-waitEstimateHistory)).Scan(&freeSlots, &oldestEntry, &samples, &averageStaySeconds)
+	if err != nil {
+		return nil, internalError("failed to estimate wait time")
+	}
+
+	estimate := &WaitEstimate{ParkingLotID: parkingLotID}
+	if freeSlots > 0 {
+		wait := 0
+		estimate.Status = WaitAvailable
+		estimate.WaitMinutes = &wait
+		return estimate, nil
+	}
+
+	if samples < minWaitEstimateSamples || !averageStaySeconds.Valid {
+		estimate.Status = WaitUnknown
+		estimate.Reason = "not enough recent completed stays to estimate"
+		return estimate, nil
+	}
+	if !oldestEntry.Valid {
+		estimate.Status = WaitUnknown
+		estimate.Reason = "no free slots and no parked vehicles; slots may be in maintenance or held"
+		return estimate, nil
+	}
+
+	averageStay := time.Duration(averageStaySeconds.Float64 * float64(time.Second))
+	remaining := averageStay - now.Sub(oldestEntry.Time)
+	if remaining < 0 {
+		remaining = 0
+	}
+	wait := int(math.Ceil(remaining.Minutes()))
+
+	estimate.Status = WaitEstimated
+	estimate.WaitMinutes = &wait
+	estimate.AverageStayMinutes = averageStay.Minutes()
+	return estimate, nil
+}
+
+// DailyTurnover is the number of vehicles a parking lot served per slot on one day.
+type DailyTurnover struct {
+	Day          time.Time `json:"day"`
+	Transactions int       `json:"transactions"`
+	Turnover     float64   `json:"turnover"`
+}
+
+// TurnoverRate is how many vehicles a parking lot served per slot per day.
+type TurnoverRate struct {
+	ParkingLotID int              `json:"parking_lot_id"`
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	TotalSpaces  int              `json:"total_spaces"`
+	Transactions int              `json:"transactions"`
+	Turnover     float64          `json:"turnover"`
+	Days         []*DailyTurnover `json:"days"`
+}
+
+// GetTurnoverRate divides the transactions completed between from and to by the lot's
+// spaces, for each day in the lot's timezone and for the period as a whole. A lot
+// without spaces has a turnover of zero.
+func (s *ParkingLotStorage) GetTurnoverRate(ctx context.Context, parkingLotID int, from, to time.Time) (*TurnoverRate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from.IsZero() || to.IsZero() || !from.Before(to) {
+		return nil, invalidRequest("from must be before to")
+	}
+	if to.Sub(from) > maxHeatmapRange {
+		return nil, invalidRequest("period must not exceed %d days", int(maxHeatmapRange.Hours()/24))
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+localDate("exit_time", "$4")+` AS day, COUNT(*)
+		FROM parking_transactions
+		WHERE lot_id = $1 AND exit_time >= $2 AND exit_time < $3
+		GROUP BY day
+	`, parkingLotID, from.UTC(), to.UTC(), lot.Location.String())
+	if err != nil {
+		return nil, internalError("failed to retrieve turnover")
+	}
+	defer rows.Close()
+
+	// Transactions per date, as on the lot's calendar.
+	transactions := make(map[string]int)
+	for rows.Next() {
+		var date time.Time
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			return nil, internalError("failed to read turnover")
+		}
+		transactions[date.Format("2006-01-02")] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing turnover")
+	}
+
+	turnover := &TurnoverRate{ParkingLotID: parkingLotID, From: from, To: to, TotalSpaces: lot.TotalSpaces, Days: []*DailyTurnover{}}
+	inLotZone(lot, &turnover.From, &turnover.To)
+	for dayStart := startOfDay(turnover.From); dayStart.Before(to); dayStart = dayStart.AddDate(0, 0, 1) {
+		day := &DailyTurnover{Day: dayStart, Transactions: transactions[dayStart.Format("2006-01-02")]}
+		if lot.TotalSpaces > 0 {
+			day.Turnover = float64(day.Transactions) / float64(lot.TotalSpaces)
+		}
+		turnover.Transactions += day.Transactions
+		turnover.Days = append(turnover.Days, day)
+	}
+
+	if lot.TotalSpaces > 0 {
+		days := to.Sub(from).Hours() / 24
+		turnover.Turnover = float64(turnover.Transactions) / float64(lot.TotalSpaces) / days
+	}
+
+	return turnover, nil
+}
+
+// PointOccupancy is the number of vehicles parked in a lot at one moment.
+type PointOccupancy struct {
+	ParkingLotID int       `json:"parking_lot_id"`
+	At           time.Time `json:"at"`
+	Vehicles     int       `json:"vehicles"`
+	TotalSpaces  int       `json:"total_spaces"`
+}
+
+// GetOccupancyAt reconstructs how many vehicles were parked in the specified lot at the
+// given past time, from the completed transactions spanning it and the vehicles still
+// parked that entered before it.
+func (s *ParkingLotStorage) GetOccupancyAt(ctx context.Context, parkingLotID int, at time.Time) (*PointOccupancy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if at.IsZero() {
+		return nil, invalidRequest("time is required")
+	}
+	if at.After(s.now()) {
+		return nil, invalidRequest("time must not be in the future")
+	}
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	occupancy := &PointOccupancy{ParkingLotID: parkingLotID, At: at, TotalSpaces: lot.TotalSpaces}
+	inLotZone(lot, &occupancy.At)
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM parking_transactions
+				WHERE lot_id = $1 AND entry_time <= $2 AND exit_time > $2) +
+			(SELECT COUNT(*) FROM parked_vehicles
+				WHERE parking_lot_id = $1 AND exit_time IS NULL AND entry_time <= $2)
+	`, parkingLotID, at.UTC()).Scan(&occupancy.Vehicles)
+	if err != nil {
+		return nil, internalError("failed to compute occupancy")
+	}
+
+	return occupancy, nil
+}
+
+// SlotUtilization is how much a parking space was used over a period.
+type SlotUtilization struct {
+	Rank          int     `json:"rank"`
+	SlotNumber    int     `json:"slot_number"`
+	VehicleType   string  `json:"vehicle_type"`
+	OccupiedHours float64 `json:"occupied_hours"`
+	Transactions  int     `json:"transactions"`
+}
+
+// GetSlotUtilizationRank ranks the spaces of the specified lot by the hours completed,
+// non-voided transactions occupied them between from and to, most used first. Stays
+// are clipped to the period. Transactions of oversized vehicles only count for the
+// first slot of their block.
+func (s *ParkingLotStorage) GetSlotUtilizationRank(ctx context.Context, parkingLotID int, from, to time.Time) ([]*SlotUtilization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from.IsZero() || to.IsZero() || !from.Before(to) {
+		return nil, invalidRequest("from must be before to")
+	}
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parking_spaces.number, parking_spaces.vehicle_type,
+			COALESCE(SUM(EXTRACT(EPOCH FROM LEAST(parking_transactions.exit_time, $3) - GREATEST(parking_transactions.entry_time, $2))), 0) / 3600,
+			COUNT(parking_transactions.id)
+		FROM parking_spaces
+		LEFT JOIN parking_transactions ON parking_transactions.lot_id = parking_spaces.lot_id
+			AND parking_transactions.slot = parking_spaces.number
+			AND parking_transactions.voided_at IS NULL
+			AND parking_transactions.entry_time < $3 AND parking_transactions.exit_time > $2
+		WHERE parking_spaces.lot_id = $1
+		GROUP BY parking_spaces.number, parking_spaces.vehicle_type
+		ORDER BY 3 DESC, 4 DESC, parking_spaces.number
+	`, parkingLotID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, internalError("failed to retrieve slot utilization")
+	}
+	defer rows.Close()
+
+	ranked := []*SlotUtilization{}
+	for rows.Next() {
+		slot := SlotUtilization{Rank: len(ranked) + 1}
+		if err := rows.Scan(&slot.SlotNumber, &slot.VehicleType, &slot.OccupiedHours, &slot.Transactions); err != nil {
+			return nil, internalError("failed to read slot utilization")
+		}
+		ranked = append(ranked, &slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing slot utilization")
+	}
+
+	return ranked, nil
+}
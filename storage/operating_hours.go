@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrLotClosed          = &ParkingError{Code: CodeLotClosed, Status: http.StatusConflict, Message: "parking lot is closed"}
+	ErrWouldExceedClosing = &ParkingError{Code: CodeWouldExceedClosing, Status: http.StatusConflict, Message: "parking lot closes too soon to admit vehicles"}
+)
+
+const minutesPerDay = 24 * 60
+
+// OperatingHours are the daily opening and closing times of a parking lot in UTC, as HH:MM.
+// A closing time before the opening time closes the lot on the next day. No vehicles are
+// admitted during the last LastCallMinutes before closing.
+type OperatingHours struct {
+	OpensAt         string `json:"opensAt"`
+	ClosesAt        string `json:"closesAt"`
+	LastCallMinutes int    `json:"lastCallMinutes"`
+}
+
+// SetOperatingHours sets the operating hours of the specified lot. Nil hours keep the lot
+// open around the clock.
+func (s *ParkingLotStorage) SetOperatingHours(ctx context.Context, parkingLotID int, hours *OperatingHours) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var opensAt, closesAt interface{}
+	lastCallMinutes := 0
+	if hours != nil {
+		opens, err := parseMinuteOfDay(hours.OpensAt)
+		if err != nil {
+			return invalidRequest("invalid opening time %q", hours.OpensAt)
+		}
+		closes, err := parseMinuteOfDay(hours.ClosesAt)
+		if err != nil {
+			return invalidRequest("invalid closing time %q", hours.ClosesAt)
+		}
+		if opens == closes {
+			return invalidRequest("opening and closing times must differ")
+		}
+		if hours.LastCallMinutes < 0 || hours.LastCallMinutes >= openMinutes(opens, closes) {
+			return invalidRequest("last call must be between 0 and the opening hours")
+		}
+		opensAt, closesAt, lastCallMinutes = opens, closes, hours.LastCallMinutes
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE parking_lots
+		SET opens_at_minute = $2, closes_at_minute = $3, last_call_minutes = $4
+		WHERE id = $1
+	`, parkingLotID, opensAt, closesAt, lastCallMinutes)
+	if err != nil {
+		return internalError("failed to set operating hours")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+// checkAdmitting fails when the lot is closed at the given time or within its last call.
+func checkAdmitting(lot lotMeta, at time.Time) error {
+	if lot.OpensAtMinute == nil || lot.ClosesAtMinute == nil {
+		return nil
+	}
+
+	at = at.UTC()
+	midnight := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	now := at.Sub(midnight)
+	opens := time.Duration(*lot.OpensAtMinute) * time.Minute
+	closes := time.Duration(*lot.ClosesAtMinute) * time.Minute
+
+	sinceOpening := (now - opens + 24*time.Hour) % (24 * time.Hour)
+	if sinceOpening >= time.Duration(openMinutes(*lot.OpensAtMinute, *lot.ClosesAtMinute))*time.Minute {
+		return ErrLotClosed
+	}
+
+	untilClosing := (closes - now + 24*time.Hour) % (24 * time.Hour)
+	if untilClosing <= time.Duration(lot.LastCallMinutes)*time.Minute {
+		return ErrWouldExceedClosing
+	}
+
+	return nil
+}
+
+// operatingHours formats the lot's operating hours, or returns nil when it never closes.
+func operatingHours(lot lotMeta) *OperatingHours {
+	if lot.OpensAtMinute == nil || lot.ClosesAtMinute == nil {
+		return nil
+	}
+	return &OperatingHours{
+		OpensAt:         formatMinuteOfDay(*lot.OpensAtMinute),
+		ClosesAt:        formatMinuteOfDay(*lot.ClosesAtMinute),
+		LastCallMinutes: lot.LastCallMinutes,
+	}
+}
+
+// openMinutes returns how many minutes a lot opening at opens and closing at closes is open.
+func openMinutes(opens, closes int) int {
+	return (closes - opens + minutesPerDay) % minutesPerDay
+}
+
+func parseMinuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatMinuteOfDay(minute int) string {
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
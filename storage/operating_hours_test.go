@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheckAdmitting(t *testing.T) {
+	hours := func(opens, closes string, lastCall int) lotMeta {
+		lot := testLot()
+		opensAt, _ := parseMinuteOfDay(opens)
+		closesAt, _ := parseMinuteOfDay(closes)
+		lot.OpensAtMinute, lot.ClosesAtMinute, lot.LastCallMinutes = &opensAt, &closesAt, lastCall
+		return lot
+	}
+	day := hours("07:00", "23:00", 30)
+	overnight := hours("20:00", "04:00", 30)
+
+	tests := []struct {
+		name string
+		lot  lotMeta
+		at   string
+		want error
+	}{
+		{"always open", testLot(), "03:00", nil},
+		{"before opening", day, "06:59", ErrLotClosed},
+		{"at opening", day, "07:00", nil},
+		{"before last call", day, "22:29", nil},
+		{"at last call", day, "22:30", ErrWouldExceedClosing},
+		{"near closing", day, "22:55", ErrWouldExceedClosing},
+		{"at closing", day, "23:00", ErrLotClosed},
+		{"overnight after midnight", overnight, "02:00", nil},
+		{"overnight near closing", overnight, "03:45", ErrWouldExceedClosing},
+		{"overnight closed", overnight, "12:00", ErrLotClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock, err := time.Parse("15:04", tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			at := time.Date(2024, 3, 1, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+			if err := checkAdmitting(tt.lot, at); err != tt.want {
+				t.Errorf("checkAdmitting at %s = %v, want %v", tt.at, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParkVehicleNearClosing(t *testing.T) {
+	lot := testLot()
+	opensAt, closesAt := 7*60, 23*60
+	lot.OpensAtMinute, lot.ClosesAtMinute, lot.LastCallMinutes = &opensAt, &closesAt, 30
+
+	s, _, clock := newFakeStorage(append(parkResults(), lotResult(lot))...)
+	clock.Set(time.Date(2024, 3, 1, 22, 40, 0, 0, time.UTC))
+	if _, err := s.ParkVehicle(context.Background(), 1, "ABC123", ParkOptions{}); err != ErrWouldExceedClosing {
+		t.Errorf("ParkVehicle 20 minutes before closing = %v, want %v", err, ErrWouldExceedClosing)
+	}
+
+	clock.Set(time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC))
+	if _, err := s.ParkVehicle(context.Background(), 1, "ABC123", ParkOptions{}); err != nil {
+		t.Errorf("ParkVehicle an hour before closing = %v, want it admitted", err)
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+var ErrOrganizationNotFound = &ParkingError{Code: CodeOrganizationNotFound, Status: http.StatusNotFound, Message: "organization not found"}
+
+// Organization is an operator that owns a group of parking lots. Its API key scopes
+// requests to those lots and is only returned when the organization is created.
+type Organization struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+// LotSummary is a parking lot as listed, without its spaces.
+type LotSummary struct {
+	ID                 int    `json:"id"`
+	OrgID              int    `json:"orgID,omitempty"`
+	TotalSpaces        int    `json:"totalSpaces"`
+	DefaultVehicleType string `json:"defaultVehicleType"`
+}
+
+// CreateOrganization creates an organization with a new API key.
+func (s *ParkingLotStorage) CreateOrganization(ctx context.Context, name string) (*Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" {
+		return nil, invalidRequest("organization name is required")
+	}
+
+	apiKey, err := newToken()
+	if err != nil {
+		return nil, internalError("failed to create organization")
+	}
+
+	org := &Organization{Name: name, APIKey: apiKey}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO organizations (name, api_key, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, name, apiKey, s.now()).Scan(&org.ID)
+	if err != nil {
+		return nil, internalError("failed to create organization")
+	}
+
+	return org, nil
+}
+
+// GetOrganizationByKey returns the organization the given API key belongs to.
+func (s *ParkingLotStorage) GetOrganizationByKey(ctx context.Context, apiKey string) (*Organization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var org Organization
+	err := s.db.QueryRowContext(ctx, "SELECT id, name FROM organizations WHERE api_key = $1", apiKey).Scan(&org.ID, &org.Name)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to look up organization")
+	}
+
+	return &org, nil
+}
+
+// GetLotOrgID returns the organization owning the specified parking lot, or 0 when it
+// belongs to none.
+func (s *ParkingLotStorage) GetLotOrgID(ctx context.Context, parkingLotID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return 0, err
+	}
+	return lot.OrgID, nil
+}
+
+// ListParkingLots returns the parking lots of the specified organization, or every lot
+// when orgID is 0, ordered by id.
+func (s *ParkingLotStorage) ListParkingLots(ctx context.Context, orgID int) ([]*LotSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if orgID != 0 {
+		if err := s.checkOrganizationExists(ctx, orgID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(org_id, 0), total_spaces, default_vehicle_type
+		FROM parking_lots
+		WHERE $1 = 0 OR org_id = $1
+		ORDER BY id
+	`, orgID)
+	if err != nil {
+		return nil, internalError("failed to retrieve parking lots")
+	}
+	defer rows.Close()
+
+	lots := []*LotSummary{}
+	for rows.Next() {
+		var lot LotSummary
+		if err := rows.Scan(&lot.ID, &lot.OrgID, &lot.TotalSpaces, &lot.DefaultVehicleType); err != nil {
+			return nil, internalError("failed to read parking lots")
+		}
+		lots = append(lots, &lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parking lots")
+	}
+
+	return lots, nil
+}
+
+// GetReportsForOrganization retrieves daily statistics for every parking lot of the
+// specified organization. A zero from or to leaves that end of the period open.
+func (s *ParkingLotStorage) GetReportsForOrganization(ctx context.Context, orgID int, from, to time.Time) (_ []*LotReport, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	if err := s.checkOrganizationExists(ctx, orgID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM parking_lots WHERE org_id = $1 ORDER BY id", orgID)
+	if err != nil {
+		return nil, internalError("failed to retrieve parking lots")
+	}
+	defer rows.Close()
+
+	var lotIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, internalError("failed to read parking lots")
+		}
+		lotIDs = append(lotIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parking lots")
+	}
+
+	if len(lotIDs) == 0 {
+		return []*LotReport{}, nil
+	}
+
+	return s.reportsForLots(ctx, lotIDs, from, to)
+}
+
+func (s *ParkingLotStorage) checkOrganizationExists(ctx context.Context, orgID int) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)", orgID).Scan(&exists)
+	if err != nil {
+		return internalError("failed to look up organization")
+	}
+	if !exists {
+		return ErrOrganizationNotFound
+	}
+
+	return nil
+}
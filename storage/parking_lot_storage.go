@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"math"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,26 +19,47 @@ const (
 	dbPassword        = "password"
 	dbName            = "db_vehicle_parking"
 	ParkingFeeperHour = 10
+
+	// DefaultVehicleType is used when a request does not name a vehicle type.
+	DefaultVehicleType = "car"
+
+	// StrategyNearest always assigns the lowest numbered free slot.
+	StrategyNearest = "nearest"
+	// StrategyRoundRobin assigns the next free slot after the last one assigned in the lot.
+	StrategyRoundRobin = "round-robin"
 )
 
 // ParkingLot represents a parking lot with parking spaces.
 type ParkingLot struct {
-	ID          int
-	TotalSpaces int
-	Spaces      []ParkingSpace
+	ID                 int
+	OrgID              int
+	TotalSpaces        int
+	DefaultVehicleType string
+	AllocationStrategy string
+	FeeStrategy        string
+	SlotTypeCounts     map[string]int
+	Spaces             []ParkingSpace
 }
 
 // ParkingSpace represents a parking space in a parking lot.
 type ParkingSpace struct {
 	Number        int
 	InMaintenance bool
-	Occupied      bool
-	EntryTime     time.Time
+	// MaintenanceMode is empty when the space is not in maintenance.
+	MaintenanceMode string
+	Occupied        bool
+	EntryTime       time.Time
+	VehicleType     string
 }
 
 // ParkingLotStatus represents the current status of a parking lot.
 type ParkingLotStatus struct {
-	ParkedVehicles map[int]VehicleStatus
+	TotalSpaces      int
+	OccupiedCount    int
+	OccupancyPercent float64
+	ParkedVehicles   map[int]VehicleStatus
+	// Version is the lot version the status was read at.
+	Version int64 `json:"-"`
 }
 
 // VehicleStatus represents the status of a parked vehicle.
@@ -44,14 +67,22 @@ type VehicleStatus struct {
 	Vehicle    string
 	SlotNumber int
 	EntryTime  time.Time
+	// MaintenanceMode is set when the occupied slot has been put into maintenance.
+	MaintenanceMode string
+	EntryPhotoURL   string
 }
 
-// DailyStats represents the total statistics for a parking lot per day.
+// DailyStats represents the total statistics for a parking lot per day. Day is midnight
+// of the day in the lot's timezone.
+// VoidedVehicles counts the voided transactions included in the totals, if any.
+// EmployeeVehicles counts the free stays of employee plates included in TotalVehicles.
 type DailyStats struct {
 	Day              time.Time `json:"day"`
 	TotalVehicles    int       `json:"total_vehicles"`
+	EmployeeVehicles int       `json:"employee_vehicles"`
 	TotalParkingTime float64   `json:"total_parking_time"`
 	TotalFee         int       `json:"total_fee"`
+	VoidedVehicles   int       `json:"voided_vehicles,omitempty"`
 }
 
 // Vehicle represents a parked vehicle.
@@ -64,167 +95,738 @@ type Vehicle struct {
 
 // ParkingLotStorage provides storage for parking lots and vehicles.
 type ParkingLotStorage struct {
-	db *sql.DB
-	mu sync.RWMutex
+	db    *sql.DB
+	mu    sync.RWMutex
+	clock Clock
+	lots  *lotCache
+
+	// maintenanceGrace excludes time a vehicle's slot spent in maintenance from its fee.
+	maintenanceGrace bool
+	// pendingExitTimeout, when set, keeps unparked vehicles in their slots until their
+	// exit is confirmed or the timeout passes.
+	pendingExitTimeout time.Duration
+	// bulkBatchSize bounds how many records one transaction of a bulk operation writes.
+	bulkBatchSize int
+	// lostTicketRefundWindow bounds how long after exit lost-ticket overcharges are refunded.
+	lostTicketRefundWindow time.Duration
+	// allowMultiLotPlates lets a plate be parked in several lots at the same time.
+	allowMultiLotPlates bool
+	// idempotentUnpark makes a repeated unpark return the fee already charged.
+	idempotentUnpark bool
+	// readTimeout and writeTimeout bound the database work of reports and of parking
+	// and unparking respectively.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 // NewParkingLotStorage creates a new instance of ParkingLotStorage.
-func NewParkingLotStorage() (*ParkingLotStorage, error) {
+// It fails fast when the database cannot be reached within pingTimeout.
+func NewParkingLotStorage(pingTimeout time.Duration) (*ParkingLotStorage, error) {
 	connStr := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", dbUser, dbPassword, dbName)
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("cannot open database: %w", err)
 	}
 
-	return &ParkingLotStorage{db: db}, nil
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+
+	return &ParkingLotStorage{db: db, clock: realClock{}, lots: newLotCache()}, nil
+}
+
+// SetMaintenanceGrace enables or disables the fee-free maintenance grace.
+func (s *ParkingLotStorage) SetMaintenanceGrace(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maintenanceGrace = enabled
+}
+
+// LotOptions holds the optional settings of a new parking lot.
+type LotOptions struct {
+	DefaultVehicleType string
+	AllocationStrategy string
+	FeeStrategy        string
+	NegativeFeePolicy  string
+	// OrgID is the organization owning the lot, or 0 for none.
+	OrgID int
+	// SlotTypes is the number of spaces to create for each vehicle type other than
+	// the default one.
+	SlotTypes map[string]int
 }
 
 // CreateParkingLot creates a new parking lot with the specified total spaces.
-func (s *ParkingLotStorage) CreateParkingLot(totalSpaces int) (*ParkingLot, error) {
+// The lowest numbered spaces take the lot's default vehicle type, followed by the
+// typed spaces in order of type name.
+func (s *ParkingLotStorage) CreateParkingLot(ctx context.Context, totalSpaces int, opts LotOptions) (*ParkingLot, error) {
 	var parkingLotID int
 
-	err := s.db.QueryRow("INSERT INTO parking_lots(total_spaces) VALUES($1) RETURNING id", totalSpaces).Scan(&parkingLotID)
+	defaultVehicleType := opts.DefaultVehicleType
+	if defaultVehicleType == "" {
+		defaultVehicleType = DefaultVehicleType
+	}
+
+	allocationStrategy := opts.AllocationStrategy
+	if allocationStrategy == "" {
+		allocationStrategy = StrategyNearest
+	}
+	if !validAllocationStrategy(allocationStrategy) {
+		return nil, invalidRequest("unknown allocation strategy %q", allocationStrategy)
+	}
+
+	feeStrategy := opts.FeeStrategy
+	if feeStrategy == "" {
+		feeStrategy = FeeStrategyFlat
+	}
+	if _, ok := lookupFeeStrategy(feeStrategy); !ok {
+		return nil, invalidRequest("unknown fee strategy %q", feeStrategy)
+	}
+
+	negativeFeePolicy := opts.NegativeFeePolicy
+	if negativeFeePolicy == "" {
+		negativeFeePolicy = NegativeFeeClamp
+	}
+	if !validNegativeFeePolicy(negativeFeePolicy) {
+		return nil, invalidRequest("unknown negative fee policy %q", negativeFeePolicy)
+	}
+
+	slotTypes, err := spaceVehicleTypes(totalSpaces, defaultVehicleType, opts.SlotTypes)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
+	if opts.OrgID != 0 {
+		if err := s.checkOrganizationExists(ctx, opts.OrgID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to create parking lot")
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO parking_lots(total_spaces, default_vehicle_type, allocation_strategy, fee_strategy, negative_fee_policy, org_id)
+		VALUES($1, $2, $3, $4, $5, NULLIF($6, 0))
+		RETURNING id
+	`, totalSpaces, defaultVehicleType, allocationStrategy, feeStrategy, negativeFeePolicy, opts.OrgID).Scan(&parkingLotID)
+	if err != nil {
+		log.Println(err)
+		return nil, internalError("failed to create parking lot")
+	}
+
+	// The first strategy also covers stays recorded as entering before the lot was created.
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO fee_strategy_changes (lot_id, fee_strategy, effective_from)
+		VALUES ($1, $2, 'epoch')
+	`, parkingLotID, feeStrategy)
+	if err != nil {
+		log.Println(err)
+		return nil, internalError("failed to create parking lot")
+	}
+
 	var parkingSpaces []ParkingSpace
+	slotTypeCounts := make(map[string]int)
 	for i := 1; i <= totalSpaces; i++ {
-		_, err := s.db.Exec(`
-			INSERT INTO parking_spaces(lot_id, number)
-			VALUES($1, $2)
-		`, parkingLotID, i)
+		vehicleType := slotTypes[i-1]
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO parking_spaces(lot_id, number, vehicle_type)
+			VALUES($1, $2, $3)
+		`, parkingLotID, i, vehicleType)
 
 		if err != nil {
-			log.Fatal(err)
-			return nil, err
+			log.Println(err)
+			return nil, internalError("failed to create parking spaces")
 		}
 		parkingSpaces = append(parkingSpaces, ParkingSpace{
-			Number: i,
+			Number:      i,
+			VehicleType: vehicleType,
 		})
+		slotTypeCounts[vehicleType]++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to create parking lot")
 	}
 
 	parkingLot := &ParkingLot{
-		ID:          parkingLotID,
-		TotalSpaces: totalSpaces,
-		Spaces:      parkingSpaces,
+		ID:                 parkingLotID,
+		OrgID:              opts.OrgID,
+		TotalSpaces:        totalSpaces,
+		DefaultVehicleType: defaultVehicleType,
+		AllocationStrategy: allocationStrategy,
+		FeeStrategy:        feeStrategy,
+		SlotTypeCounts:     slotTypeCounts,
+		Spaces:             parkingSpaces,
 	}
 
 	return parkingLot, nil
 }
 
+// spaceVehicleTypes lays out the vehicle type of each of totalSpaces spaces: the default
+// type first, then the requested typed spaces in order of type name.
+func spaceVehicleTypes(totalSpaces int, defaultVehicleType string, slotTypes map[string]int) ([]string, error) {
+	typeNames := make([]string, 0, len(slotTypes))
+	typed := 0
+	for vehicleType, count := range slotTypes {
+		if count < 0 {
+			return nil, invalidRequest("slot count for %q must not be negative", vehicleType)
+		}
+		typed += count
+		typeNames = append(typeNames, vehicleType)
+	}
+	if typed > totalSpaces {
+		return nil, invalidRequest("typed slots (%d) exceed total spaces (%d)", typed, totalSpaces)
+	}
+	sort.Strings(typeNames)
+
+	layout := make([]string, 0, totalSpaces)
+	for i := 0; i < totalSpaces-typed; i++ {
+		layout = append(layout, defaultVehicleType)
+	}
+	for _, vehicleType := range typeNames {
+		for i := 0; i < slotTypes[vehicleType]; i++ {
+			layout = append(layout, vehicleType)
+		}
+	}
+
+	return layout, nil
+}
+
+// GetParkingLot retrieves a parking lot with its spaces and the number of spaces of each type.
+func (s *ParkingLotStorage) GetParkingLot(ctx context.Context, parkingLotID int) (*ParkingLot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	parkingLot := &ParkingLot{
+		ID:                 parkingLotID,
+		OrgID:              lot.OrgID,
+		TotalSpaces:        lot.TotalSpaces,
+		DefaultVehicleType: lot.DefaultVehicleType,
+		AllocationStrategy: lot.AllocationStrategy,
+		FeeStrategy:        lot.FeeStrategy,
+		SlotTypeCounts:     make(map[string]int),
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT number, in_maintenance, COALESCE(maintenance_mode, ''), occupied, entry_time, vehicle_type
+		FROM parking_spaces
+		WHERE lot_id = $1
+		ORDER BY number
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve parking spaces")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var space ParkingSpace
+		var entryTime sql.NullTime
+		if err := rows.Scan(&space.Number, &space.InMaintenance, &space.MaintenanceMode, &space.Occupied, &entryTime, &space.VehicleType); err != nil {
+			return nil, internalError("failed to read parking spaces")
+		}
+		if space.Occupied {
+			space.EntryTime = entryTime.Time
+			inLotZone(lot, &space.EntryTime)
+		}
+		parkingLot.Spaces = append(parkingLot.Spaces, space)
+		parkingLot.SlotTypeCounts[space.VehicleType]++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parking spaces")
+	}
+
+	return parkingLot, nil
+}
+
+// CountParkingLots returns how many parking lots exist.
+func (s *ParkingLotStorage) CountParkingLots(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM parking_lots").Scan(&count)
+	if err != nil {
+		return 0, internalError("failed to count parking lots")
+	}
+
+	return count, nil
+}
+
+// ParkOptions holds the optional parameters of a park request.
+type ParkOptions struct {
+	VehicleType   string
+	HoldToken     string
+	SlotsRequired int
+	// PrepaidAmount is paid upfront for a stay of PrepaidDuration. The unused part is
+	// refunded when the vehicle leaves early.
+	PrepaidAmount   int
+	PrepaidDuration time.Duration
+	// AllowOverflow lets the vehicle be parked in the lot's overflow lot when the lot is full.
+	AllowOverflow bool
+	// ReservationID parks the vehicle in a slot of the given active block reservation.
+	ReservationID int
+	// EntranceID picks the free slot nearest to the entrance the vehicle came in by.
+	EntranceID string
+	// EntryPhotoURL references the photo a gate camera took of the vehicle entering.
+	EntryPhotoURL string
+
+	// entryTime overrides the time the vehicle is recorded as entering; see ParkVehicleAt.
+	entryTime time.Time
+}
+
+// ParkingTicket is issued to a vehicle when it parks.
+type ParkingTicket struct {
+	TicketID     string     `json:"ticketID"`
+	ParkingLotID int        `json:"parkingLotID"`
+	SlotNumber   int        `json:"slotNumber"`
+	EntryTime    time.Time  `json:"entryTime"`
+	PrepaidUntil *time.Time `json:"prepaidUntil,omitempty"`
+	// RedirectedFrom is the full lot the vehicle was turned away from when it was parked
+	// in that lot's overflow lot instead.
+	RedirectedFrom     int  `json:"redirectedFrom,omitempty"`
+	DistanceToEntrance *int `json:"distanceToEntrance,omitempty"`
+}
+
+// maxEntryPhotoURLLength bounds the photo reference stored with a parked vehicle.
+const maxEntryPhotoURLLength = 2048
+
+// maxParkAttempts bounds how often ParkVehicle looks for another slot when the one it
+// picked is taken or put into maintenance before it could be occupied.
+const maxParkAttempts = 3
+
+// errSlotUnavailable reports that a picked slot could no longer be occupied.
+var errSlotUnavailable = errors.New("slot no longer available")
+
 // ParkVehicle parks a vehicle in the nearest available slot in the specified parking lot.
-func (s *ParkingLotStorage) ParkVehicle(parkingLotID int, LicensePlate string) (int, error) {
+// When a hold token is given the vehicle is parked in the held slot instead.
+// When the lot is full and overflow is allowed, the vehicle is parked in the lot's
+// overflow lot if it has one.
+// Oversized vehicles needing several slots are parked in the nearest block of adjacent free slots,
+// and the ticket names the first slot in the block.
+//
+// Maintenance takes precedence over parking: a slot that enters maintenance while a park is in
+// flight is never occupied, and the park retries with another slot.
+func (s *ParkingLotStorage) ParkVehicle(ctx context.Context, parkingLotID int, LicensePlate string, opts ParkOptions) (_ *ParkingTicket, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	ctx, done := s.withWriteTimeout(ctx)
+	defer done(&err)
+
+	if err := validateLicensePlate(LicensePlate); err != nil {
+		return nil, err
+	}
+
+	slotsRequired := opts.SlotsRequired
+	if slotsRequired < 1 {
+		slotsRequired = 1
+	}
+	if opts.HoldToken != "" && slotsRequired > 1 {
+		return nil, invalidRequest("a hold covers a single slot")
+	}
+	if opts.PrepaidAmount < 0 || opts.PrepaidDuration < 0 {
+		return nil, invalidRequest("prepaid amount and duration must not be negative")
+	}
+	if (opts.PrepaidAmount > 0) != (opts.PrepaidDuration > 0) {
+		return nil, invalidRequest("a prepayment needs both an amount and a duration")
+	}
+	if len(opts.EntryPhotoURL) > maxEntryPhotoURLLength {
+		return nil, invalidRequest("entry photo URL must not exceed %d characters", maxEntryPhotoURLLength)
+	}
+
+	opts.SlotsRequired = slotsRequired
+
+	if err := s.checkNotParkedElsewhere(ctx, parkingLotID, LicensePlate); err != nil {
+		return nil, err
+	}
 
-	var totalSpaces int
-	err := s.db.QueryRow("SELECT total_spaces FROM parking_lots WHERE id = $1", parkingLotID).Scan(&totalSpaces)
+	ticket, err := s.parkInLot(ctx, parkingLotID, LicensePlate, opts)
+	if !errors.Is(err, ErrLotFull) || !opts.AllowOverflow || opts.HoldToken != "" || opts.ReservationID != 0 {
+		return ticket, err
+	}
+
+	lot, lotErr := s.lotMeta(ctx, parkingLotID)
+	if lotErr != nil || lot.OverflowLotID == 0 {
+		return nil, err
+	}
+	ticket, overflowErr := s.parkInLot(ctx, lot.OverflowLotID, LicensePlate, opts)
+	if overflowErr != nil {
+		return nil, err
+	}
+	ticket.RedirectedFrom = parkingLotID
+
+	return ticket, nil
+}
+
+// parkInLot parks a vehicle in the specified lot, retrying with another slot when the one
+// picked is taken in the meantime.
+func (s *ParkingLotStorage) parkInLot(ctx context.Context, parkingLotID int, LicensePlate string, opts ParkOptions) (*ParkingTicket, error) {
+	vehicleType, err := s.lotVehicleType(ctx, parkingLotID, opts.VehicleType)
 	if err != nil {
-		return 0, errors.New("parking lot not found")
+		return nil, err
 	}
+	opts.VehicleType = vehicleType
 
-	var nearestSoltID int
-	err = s.db.QueryRow("SELECT id from parking_spaces WHERE lot_id = $1 AND NOT occupied AND NOT in_maintenance ORDER BY number LIMIT 1", parkingLotID).Scan(&nearestSoltID)
+	lot, err := s.lotMeta(ctx, parkingLotID)
 	if err != nil {
-		return 0, errors.New("nearest available slot not found")
+		return nil, err
+	}
+	// Parks recorded after the fact are not subject to the operating hours.
+	if opts.entryTime.IsZero() {
+		if err := checkAdmitting(lot, s.now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.EntranceID != "" {
+		if err := s.checkEntranceExists(ctx, parkingLotID, opts.EntranceID); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; attempt < maxParkAttempts; attempt++ {
+		ticket, err := s.parkOnce(ctx, parkingLotID, LicensePlate, opts)
+		if err == nil {
+			inLotZone(lot, &ticket.EntryTime, ticket.PrepaidUntil)
+		}
+		if err != errSlotUnavailable {
+			return ticket, err
+		}
+		if opts.HoldToken != "" {
+			return nil, newError(CodeHoldNotFound, http.StatusConflict, "held slot is no longer available")
+		}
+	}
+
+	return nil, ErrLotFull
+}
+
+// ParkVehicleAt parks a vehicle like ParkVehicle but records it as having entered at
+// entryTime, for importing historical parks or correcting missed ones.
+func (s *ParkingLotStorage) ParkVehicleAt(ctx context.Context, parkingLotID int, LicensePlate string, entryTime time.Time, opts ParkOptions) (*ParkingTicket, error) {
+	if entryTime.IsZero() {
+		return nil, invalidRequest("entry time is required")
+	}
+	if entryTime.After(s.now()) {
+		return nil, invalidRequest("entry time must not be in the future")
+	}
+
+	opts.entryTime = entryTime.UTC()
+	return s.ParkVehicle(ctx, parkingLotID, LicensePlate, opts)
+}
+
+// parkOnce picks a slot and occupies it in a single transaction. It returns
+// errSlotUnavailable when the slot was taken or put into maintenance in the meantime.
+// The vehicle type and slot count in opts must already be resolved.
+func (s *ParkingLotStorage) parkOnce(ctx context.Context, parkingLotID int, LicensePlate string, opts ParkOptions) (*ParkingTicket, error) {
+	vehicleType, slotsRequired, holdToken := opts.VehicleType, opts.SlotsRequired, opts.HoldToken
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to occupy parking space")
+	}
+	defer tx.Rollback()
+
+	var firstSlot int
+	if holdToken != "" {
+		firstSlot, err = s.claimHold(ctx, tx, parkingLotID, holdToken)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		firstSlot, err = s.nextFreeBlock(ctx, tx, parkingLotID, vehicleType, slotsRequired, opts.ReservationID, opts.EntranceID)
+		if err != nil {
+			if slotsRequired > 1 {
+				return nil, newError(CodeLotFull, http.StatusConflict, "no block of %d adjacent free slots available", slotsRequired)
+			}
+			return nil, ErrLotFull
+		}
 	}
 
-	var slotNumber int
-	err = s.db.QueryRow(`
+	// The row locks taken here wait for a concurrent maintenance toggle, and the
+	// in_maintenance check is re-evaluated once it commits.
+	entryTime := opts.entryTime
+	if entryTime.IsZero() {
+		entryTime = s.now()
+	}
+	res, err := tx.ExecContext(ctx, `
 		UPDATE parking_spaces
-		SET occupied = true, entry_time = NOW()
-		WHERE id = $1
-		RETURNING number
-	`, nearestSoltID).Scan(&slotNumber)
+		SET occupied = true, entry_time = $4
+		WHERE lot_id = $1 AND number BETWEEN $2 AND $3 AND NOT occupied AND NOT in_maintenance
+	`, parkingLotID, firstSlot, firstSlot+slotsRequired-1, entryTime)
+	if err != nil {
+		return nil, internalError("failed to occupy parking space")
+	}
+	if n, _ := res.RowsAffected(); n != int64(slotsRequired) {
+		return nil, errSlotUnavailable
+	}
+	if err := recordSlotStates(ctx, tx, parkingLotID, slotRange(firstSlot, slotsRequired), SlotActionPark, s.now()); err != nil {
+		return nil, internalError("failed to occupy parking space")
+	}
 
+	_, err = tx.ExecContext(ctx, "UPDATE parking_lots SET last_assigned_slot = $2, version = version + 1 WHERE id = $1", parkingLotID, firstSlot+slotsRequired-1)
 	if err != nil {
-		return 0, errors.New("failed to occupy parking space")
+		return nil, internalError("failed to occupy parking space")
 	}
-	var vehicleId int
-	err = s.db.QueryRow("INSERT INTO parked_vehicles(parking_lot_id,slot,license_plate,entry_time) VALUES($1,$2,$3,NOW()) RETURNING id", parkingLotID, slotNumber, LicensePlate).Scan(&vehicleId)
+
+	ticketID, err := newToken()
 	if err != nil {
-		log.Fatal(err)
-		return 0, err
+		return nil, internalError("failed to generate ticket id")
+	}
+
+	ticket := &ParkingTicket{TicketID: ticketID, ParkingLotID: parkingLotID, SlotNumber: firstSlot}
+	if opts.EntranceID != "" {
+		ticket.DistanceToEntrance, err = entranceDistance(ctx, tx, parkingLotID, opts.EntranceID, firstSlot)
+		if err != nil {
+			return nil, internalError("failed to look up entrance distance")
+		}
+	}
+	var prepaidUntil time.Time
+	if opts.PrepaidAmount > 0 {
+		prepaidUntil = entryTime.Add(opts.PrepaidDuration)
+		ticket.PrepaidUntil = &prepaidUntil
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO parked_vehicles(parking_lot_id,slot,slots_required,license_plate,entry_time,ticket_id,vehicle_type,prepaid_amount,prepaid_until,hold_token,entry_photo_url)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,NULLIF($10, ''),NULLIF($11, ''))
+		RETURNING entry_time
+	`, parkingLotID, firstSlot, slotsRequired, LicensePlate, entryTime, ticketID, vehicleType, opts.PrepaidAmount, nullTime(prepaidUntil), holdToken, opts.EntryPhotoURL).Scan(&ticket.EntryTime)
+	if err != nil {
+		log.Println(err)
+		return nil, internalError("failed to record parked vehicle")
 	}
 
-	return slotNumber, nil
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to occupy parking space")
+	}
+
+	return ticket, nil
 }
 
 // UnparkVehicle unparks a vehicle from the specified parking lot.
-// It returns the parking fee calculated based on the entry time.
-func (s *ParkingLotStorage) UnparkVehicle(parkingLotID int, LicensePlate string) (int, error) {
+// It returns the parking fee calculated based on the entry time, broken down per day
+// for stays spanning several days.
+func (s *ParkingLotStorage) UnparkVehicle(ctx context.Context, parkingLotID int, LicensePlate, validationCode string) (_ *FeeBreakdown, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	ctx, done := s.withWriteTimeout(ctx)
+	defer done(&err)
+
+	if err := validateLicensePlate(LicensePlate); err != nil {
+		return nil, err
+	}
+
+	stay, err := scanParkedStay(s.db.QueryRowContext(ctx, `
+		SELECT `+parkedStayColumns+`
+		FROM parked_vehicles
+		WHERE parked_vehicles.parking_lot_id = $1 AND parked_vehicles.license_plate = $2
+		AND parked_vehicles.exit_time IS NULL
+		ORDER BY parked_vehicles.id DESC LIMIT 1
+	`, parkingLotID, LicensePlate))
+	if err == sql.ErrNoRows {
+		return s.repeatedUnpark(ctx, parkingLotID, LicensePlate)
+	}
+	if err != nil {
+		return nil, internalError("failed to look up parked vehicle")
+	}
+
+	if validationCode != "" {
+		if err := checkValidation(ctx, s.db, parkingLotID, validationCode); err != nil {
+			return nil, err
+		}
+		_, err := s.db.ExecContext(ctx, "UPDATE parked_vehicles SET validation_code = $2 WHERE id = $1", stay.ID, validationCode)
+		if err != nil {
+			return nil, internalError("failed to unpark vehicle")
+		}
+		stay.ValidationCode = validationCode
+	}
+
+	if s.pendingExitTimeout > 0 && stay.TicketID.Valid {
+		return s.startPendingExit(ctx, stay)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to unpark vehicle")
+	}
+	defer tx.Rollback()
+
+	fee, err := s.closeStay(ctx, tx, stay, s.now(), false)
+	if errors.Is(err, ErrAlreadyUnparked) {
+		tx.Rollback()
+		return s.repeatedUnpark(ctx, parkingLotID, LicensePlate)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to unpark vehicle")
+	}
+
+	return fee, nil
+}
+
+// parkedStayColumns selects the parked_vehicles columns read by scanParkedStay.
+const parkedStayColumns = `parked_vehicles.id, parked_vehicles.parking_lot_id, parked_vehicles.license_plate, parked_vehicles.slot, parked_vehicles.slots_required,
+	parked_vehicles.ticket_id, parked_vehicles.vehicle_type, parked_vehicles.entry_time, parked_vehicles.exit_time,
+	parked_vehicles.prepaid_amount, parked_vehicles.prepaid_until, parked_vehicles.pending_exit_at, COALESCE(parked_vehicles.entry_photo_url, ''),
+	COALESCE(parked_vehicles.validation_code, '')`
 
-	var parkingSpaceID int
-	err := s.db.QueryRow("SELECT parking_spaces.id FROM parked_vehicles LEFT JOIN parking_spaces ON parking_spaces.lot_id=parked_vehicles.parking_lot_id and parked_vehicles.slot=parking_spaces.number WHERE parking_spaces.lot_id = $1 AND parked_vehicles.license_plate=$2 AND occupied=TRUE", parkingLotID, LicensePlate).Scan(&parkingSpaceID)
+// parkedStay is a parked_vehicles row.
+type parkedStay struct {
+	ID             int
+	ParkingLotID   int
+	LicensePlate   string
+	FirstSlot      int
+	SlotsRequired  int
+	TicketID       sql.NullString
+	VehicleType    string
+	EntryTime      time.Time
+	ExitTime       sql.NullTime
+	PrepaidAmount  int
+	PrepaidUntil   sql.NullTime
+	PendingExitAt  sql.NullTime
+	EntryPhotoURL  string
+	ValidationCode string
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanParkedStay(row rowScanner) (*parkedStay, error) {
+	var stay parkedStay
+	err := row.Scan(&stay.ID, &stay.ParkingLotID, &stay.LicensePlate, &stay.FirstSlot, &stay.SlotsRequired,
+		&stay.TicketID, &stay.VehicleType, &stay.EntryTime, &stay.ExitTime,
+		&stay.PrepaidAmount, &stay.PrepaidUntil, &stay.PendingExitAt, &stay.EntryPhotoURL, &stay.ValidationCode)
+	if err != nil {
+		return nil, err
+	}
+	return &stay, nil
+}
+
+// closeStay frees the slots of a parked vehicle, charges it for its stay until exitTime
+// and records the parking transaction, flagged as abandoned when the vehicle did not leave itself.
+// The fee is charged from the stay's own entry time, so a stay whose slots were already
+// freed, e.g. by maintenance, still closes; slots another vehicle has since taken stay occupied.
+func (s *ParkingLotStorage) closeStay(ctx context.Context, tx *sql.Tx, stay *parkedStay, exitTime time.Time, abandoned bool) (*FeeBreakdown, error) {
+	// Closing the stay first locks its row, so of two concurrent unparks of the same
+	// vehicle only the first closes it and the other fails with ErrAlreadyUnparked.
+	res, err := tx.ExecContext(ctx, "UPDATE parked_vehicles SET exit_time = $2 WHERE id = $1 AND exit_time IS NULL", stay.ID, exitTime)
 	if err != nil {
-		return 0, errors.New("required parked vehicle lot not found")
+		return nil, internalError("failed to unpark vehicle")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrAlreadyUnparked
 	}
 
-	var entryTime time.Time
-	err = s.db.QueryRow(`
+	rows, err := tx.QueryContext(ctx, `
 		UPDATE parking_spaces
 		SET occupied = false
-		WHERE id = $1 
-		RETURNING entry_time
-	`, parkingSpaceID).Scan(&entryTime)
-
+		WHERE lot_id = $1 AND number BETWEEN $2 AND $3
+		AND NOT EXISTS (
+			SELECT 1 FROM parked_vehicles
+			WHERE parked_vehicles.parking_lot_id = parking_spaces.lot_id AND parked_vehicles.id <> $4
+			AND parking_spaces.number BETWEEN parked_vehicles.slot AND parked_vehicles.slot + parked_vehicles.slots_required - 1
+			AND parked_vehicles.exit_time IS NULL
+		)
+		RETURNING number
+	`, stay.ParkingLotID, stay.FirstSlot, stay.FirstSlot+stay.SlotsRequired-1, stay.ID)
 	if err != nil {
-		return 0, errors.New("failed to unpark vehicle")
+		return nil, internalError("failed to unpark vehicle")
+	}
+	freed, err := scanSlotNumbers(rows)
+	if err != nil {
+		return nil, internalError("failed to unpark vehicle")
+	}
+	action := SlotActionUnpark
+	if abandoned {
+		action = SlotActionAbandonedUnpark
+	}
+	if err := recordSlotStates(ctx, tx, stay.ParkingLotID, freed, action, s.now()); err != nil {
+		return nil, internalError("failed to unpark vehicle")
 	}
 
-	// Calculate the parking fee and update the parking transaction
-	exitTime := time.Now().In(entryTime.Location())
-	parkingTime := exitTime.Sub(entryTime)
-	fee := int(math.Ceil(parkingTime.Hours())) * 10
+	if err := bumpLotVersion(ctx, tx, stay.ParkingLotID); err != nil {
+		return nil, internalError("failed to unpark vehicle")
+	}
 
-	log.Println("*******************************")
-	log.Println(exitTime, entryTime, int(math.Ceil(parkingTime.Hours())))
+	// Calculate the parking fee and update the parking transaction
+	fee, err := s.stayFee(ctx, tx, stay, exitTime)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = s.db.Exec(`
-		INSERT INTO parking_transactions (lot_id, vehicle_license_plate,fee, entry_time,exit_time)
-		VALUES ($1, $2, $3, $4, NOW())
-	`, parkingLotID, LicensePlate, fee, entryTime)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO parking_transactions (lot_id, vehicle_license_plate,slot,fee, entry_time,exit_time,ticket_id,vehicle_type,prepaid_amount,refund,fee_before_clamp,entry_photo_url,employee,abandoned,validation_code,validated_amount,slots_required,prepaid_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13, $14, NULLIF($15, ''), $16, $17, $18)
+	`, stay.ParkingLotID, stay.LicensePlate, stay.FirstSlot, fee.Fee, stay.EntryTime, exitTime, stay.TicketID, stay.VehicleType, fee.Prepaid, fee.Refund, fee.feeBeforeClamp(), stay.EntryPhotoURL, fee.Employee, abandoned,
+		fee.ValidationCode, fee.Validated, stay.SlotsRequired, stay.PrepaidUntil)
 
 	if err != nil {
-		log.Fatal(err)
-		return 0, err
+		log.Println(err)
+		return nil, internalError("failed to record parking transaction")
 	}
 
 	return fee, nil
 }
 
 // ViewParkingLotStatus retrieves the current status of the specified parking lot.
-func (s *ParkingLotStorage) ViewParkingLotStatus(parkingLotID int) (*ParkingLotStatus, error) {
+func (s *ParkingLotStorage) ViewParkingLotStatus(ctx context.Context, parkingLotID int) (*ParkingLotStatus, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var totalSpaces int
-	err := s.db.QueryRow("SELECT total_spaces FROM parking_lots WHERE id = $1", parkingLotID).Scan(&totalSpaces)
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ParkingLotStatus{
+		ParkedVehicles: make(map[int]VehicleStatus),
+	}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			parking_lots.total_spaces,
+			COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied),
+			COALESCE(100.0 * COUNT(parking_spaces.id) FILTER (WHERE parking_spaces.occupied) / NULLIF(parking_lots.total_spaces, 0), 0),
+			parking_lots.version
+		FROM parking_lots
+		LEFT JOIN parking_spaces ON parking_spaces.lot_id = parking_lots.id
+		WHERE parking_lots.id = $1
+		GROUP BY parking_lots.id
+	`, parkingLotID).Scan(&status.TotalSpaces, &status.OccupiedCount, &status.OccupancyPercent, &status.Version)
 	if err != nil {
-		return nil, errors.New("parking lot not found")
+		return nil, ErrLotNotFound
 	}
 
-	rows, err := s.db.Query(`
-		SELECT number, occupied, parking_spaces.entry_time,license_plate
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT number, occupied, parking_spaces.entry_time,license_plate, COALESCE(maintenance_mode, ''), COALESCE(entry_photo_url, '')
 		FROM parking_spaces
-		LEFT JOIN parked_vehicles ON parking_spaces.lot_id=parked_vehicles.parking_lot_id and parked_vehicles.slot=parking_spaces.number
+		LEFT JOIN parked_vehicles ON parking_spaces.lot_id=parked_vehicles.parking_lot_id
+			AND parking_spaces.number BETWEEN parked_vehicles.slot AND parked_vehicles.slot + parked_vehicles.slots_required - 1
+			AND parked_vehicles.exit_time IS NULL
 		WHERE lot_id = $1 and occupied =TRUE
 	`, parkingLotID)
 
 	if err != nil {
-		return nil, errors.New("failed to retrieve parking lot status")
+		return nil, internalError("failed to retrieve parking lot status")
 	}
 	defer rows.Close()
 
-	status := &ParkingLotStatus{
-		ParkedVehicles: make(map[int]VehicleStatus),
-	}
 	index := 0
 	for rows.Next() {
 		index++
@@ -232,18 +834,22 @@ func (s *ParkingLotStorage) ViewParkingLotStatus(parkingLotID int) (*ParkingLotS
 		var spaceNumber int
 		var occupied bool
 		var entryTime time.Time
+		var maintenanceMode, entryPhotoURL string
 
-		err := rows.Scan(&spaceNumber, &occupied, &entryTime, &vehicle)
+		err := rows.Scan(&spaceNumber, &occupied, &entryTime, &vehicle, &maintenanceMode, &entryPhotoURL)
 		if err != nil {
 			log.Println(err)
-			return nil, errors.New("failed to  parking lot status")
+			return nil, internalError("failed to read parking lot status")
 		}
 
 		if occupied {
+			inLotZone(lot, &entryTime)
 			status.ParkedVehicles[index] = VehicleStatus{
-				Vehicle:    vehicle,
-				SlotNumber: spaceNumber,
-				EntryTime:  entryTime,
+				Vehicle:         vehicle,
+				SlotNumber:      spaceNumber,
+				EntryTime:       entryTime,
+				MaintenanceMode: maintenanceMode,
+				EntryPhotoURL:   entryPhotoURL,
 			}
 		}
 	}
@@ -252,68 +858,207 @@ func (s *ParkingLotStorage) ViewParkingLotStatus(parkingLotID int) (*ParkingLotS
 }
 
 // ToggleMaintenance toggles the maintenance mode of a parking space in the specified parking lot.
-func (s *ParkingLotStorage) ToggleMaintenance(parkingLotID, slotNumber int, inMaintenance bool) error {
+// The details are recorded when the space enters maintenance; without a mode it is taken
+// out of service. Requesting the state the space is already in changes nothing, and the
+// returned flag reports whether anything changed.
+func (s *ParkingLotStorage) ToggleMaintenance(ctx context.Context, parkingLotID, slotNumber int, inMaintenance bool, details MaintenanceDetails) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var totalSpaces int
-	err := s.db.QueryRow("SELECT total_spaces FROM parking_lots WHERE id = $1", parkingLotID).Scan(&totalSpaces)
+	mode := ""
+	if inMaintenance {
+		mode = details.Mode
+		if mode == "" {
+			mode = MaintenanceOutOfService
+		}
+		if !validMaintenanceMode(mode) {
+			return false, invalidRequest("unknown maintenance mode %q", mode)
+		}
+	}
+
+	if _, err := s.lotMeta(ctx, parkingLotID); err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, internalError("failed to toggle maintenance mode")
+	}
+	defer tx.Rollback()
+
+	var wasMode string
+	err = tx.QueryRowContext(ctx, `
+		SELECT CASE WHEN in_maintenance THEN COALESCE(maintenance_mode, $3) ELSE '' END
+		FROM parking_spaces
+		WHERE lot_id = $1 AND number = $2
+		FOR UPDATE
+	`, parkingLotID, slotNumber, MaintenanceOutOfService).Scan(&wasMode)
 	if err != nil {
-		return errors.New("parking lot not found")
+		return false, internalError("failed to toggle maintenance mode")
 	}
-	log.Println(inMaintenance, parkingLotID, slotNumber)
-	_, err = s.db.Exec(`
+	if wasMode == mode {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		UPDATE parking_spaces
-		SET in_maintenance = $1
+		SET in_maintenance = $1, maintenance_mode = NULLIF($4, '')
 		WHERE lot_id = $2 AND number = $3
-	`, inMaintenance, parkingLotID, slotNumber)
+	`, inMaintenance, parkingLotID, slotNumber, mode)
 
 	if err != nil {
-		return errors.New("failed to toggle maintenance mode")
+		return false, internalError("failed to toggle maintenance mode")
 	}
 
-	return nil
+	action := SlotActionMaintenanceEnd
+	if inMaintenance {
+		action = SlotActionMaintenanceStart
+	}
+	if err := recordSlotStates(ctx, tx, parkingLotID, []int{slotNumber}, action, s.now()); err != nil {
+		return false, internalError("failed to toggle maintenance mode")
+	}
+
+	if err := recordMaintenanceWindow(ctx, tx, parkingLotID, slotNumber, wasMode, mode, details, s.now()); err != nil {
+		return false, internalError("failed to record maintenance window")
+	}
+
+	if err := bumpLotVersion(ctx, tx, parkingLotID); err != nil {
+		return false, internalError("failed to toggle maintenance mode")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, internalError("failed to toggle maintenance mode")
+	}
+
+	return true, nil
 }
 
-// GetReports retrieves total statistics for the specified parking lot.
-func (s *ParkingLotStorage) GetReports(parkingLotID int) ([]*DailyStats, error) {
+// GetReports retrieves total statistics for the specified parking lot. Voided transactions
+// are left out unless includeVoided is set, in which case they count towards the totals.
+// Snapshotted days are read from their snapshot; the others are computed live.
+func (s *ParkingLotStorage) GetReports(ctx context.Context, parkingLotID int, includeVoided bool) (_ []*DailyStats, err error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
 
-	var totalSpaces int
-	err := s.db.QueryRow("SELECT total_spaces FROM parking_lots WHERE id = $1", parkingLotID).Scan(&totalSpaces)
+	lot, err := s.lotMeta(ctx, parkingLotID)
 	if err != nil {
-		return nil, errors.New("parking lot not found")
+		return nil, err
 	}
 
-	rows, err := s.db.Query(`
-		SELECT
-			DATE(parking_transactions.exit_time) AS day,
-			COUNT(*) AS total_vehicles,
-			COALESCE(SUM(EXTRACT(EPOCH FROM (parking_transactions.exit_time - parking_transactions.entry_time)) / 3600), 0) AS total_parking_time,
-			COALESCE(SUM(parking_transactions.fee), 0) AS total_fee
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT day, total_vehicles, total_parking_time, total_fee, voided_vehicles, employee_vehicles
+		FROM daily_stats
+		WHERE lot_id = $1 AND include_voided = $2
+		UNION ALL
+		SELECT `+localDate("parking_transactions.exit_time", "$3")+` AS day, `+dailyStatsColumns+`
 		FROM parking_transactions
-		WHERE lot_id = $1
+		WHERE lot_id = $1 AND ($2 OR parking_transactions.voided_at IS NULL)
+		AND `+localDate("parking_transactions.exit_time", "$3")+` NOT IN (SELECT day FROM daily_stats WHERE lot_id = $1 AND include_voided = $2)
 		GROUP BY day
 		ORDER BY day
-	`, parkingLotID)
+	`, parkingLotID, includeVoided, lot.Location.String())
 	if err != nil {
-		return nil, errors.New("failed to retrieve dawise total statistics")
+		return nil, internalError("failed to retrieve daywise total statistics")
 	}
 	defer rows.Close()
 
-	var dailyStatsList []*DailyStats
+	dailyStatsList := []*DailyStats{}
 	for rows.Next() {
 		var dailyStats DailyStats
-		if err := rows.Scan(&dailyStats.Day, &dailyStats.TotalVehicles, &dailyStats.TotalParkingTime, &dailyStats.TotalFee); err != nil {
-			return nil, errors.New("failed to day wise total statitics")
+		if err := rows.Scan(&dailyStats.Day, &dailyStats.TotalVehicles, &dailyStats.TotalParkingTime, &dailyStats.TotalFee, &dailyStats.VoidedVehicles, &dailyStats.EmployeeVehicles); err != nil {
+			return nil, internalError("failed to read daywise total statistics")
 		}
+		dailyStats.Day = lotDay(lot, dailyStats.Day)
 		dailyStatsList = append(dailyStatsList, &dailyStats)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, errors.New("error processing daywise total statitics")
+		return nil, internalError("error processing daywise total statistics")
 	}
 
 	return dailyStatsList, nil
 }
+
+// SetAllocationStrategy changes how new vehicles are assigned slots in the specified parking lot.
+func (s *ParkingLotStorage) SetAllocationStrategy(ctx context.Context, parkingLotID int, strategy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validAllocationStrategy(strategy) {
+		return invalidRequest("unknown allocation strategy %q", strategy)
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE parking_lots SET allocation_strategy = $2 WHERE id = $1", parkingLotID, strategy)
+	if err != nil {
+		return internalError("failed to set allocation strategy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
+
+func validAllocationStrategy(strategy string) bool {
+	return strategy == StrategyNearest || strategy == StrategyRoundRobin
+}
+
+// lotVehicleType returns vehicleType, or the lot's default vehicle type when it is empty.
+// It fails with ErrLotNotFound when the lot does not exist.
+func (s *ParkingLotStorage) lotVehicleType(ctx context.Context, parkingLotID int, vehicleType string) (string, error) {
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return "", err
+	}
+
+	if vehicleType == "" {
+		return lot.DefaultVehicleType, nil
+	}
+	return vehicleType, nil
+}
+
+// nextFreeBlock returns the number of the first slot of a run of slotsRequired adjacent
+// parking spaces that are free, not in maintenance, not held and compatible with the given
+// vehicle type. Slots in an active block reservation are only considered when parking under
+// that reservation, and then no others are. When an entrance is given the run starting
+// nearest to it is chosen, falling back to the strategy for slots without a distance. Under
+// the nearest strategy the lowest numbered run is chosen; under round-robin the search
+// starts after the lot's last assigned slot and wraps around.
+func (s *ParkingLotStorage) nextFreeBlock(ctx context.Context, q querier, parkingLotID int, vehicleType string, slotsRequired, reservationID int, entranceID string) (int, error) {
+	if vehicleType == "" {
+		vehicleType = DefaultVehicleType
+	}
+
+	var firstSlot int
+	err := q.QueryRowContext(ctx, `
+		WITH free_spaces AS (
+			SELECT number
+			FROM parking_spaces
+			WHERE lot_id = $1 AND vehicle_type = $2 AND NOT occupied AND NOT in_maintenance
+			AND `+notHeld("$5")+`
+			AND `+notReserved("$5", "$6")+`
+			AND `+inReservation("$5", "$6")+`
+		), blocks AS (
+			SELECT number, LEAD(number, $3 - 1) OVER (ORDER BY number) AS last_number
+			FROM free_spaces
+		), lot AS (
+			SELECT CASE WHEN allocation_strategy = $4 THEN last_assigned_slot ELSE 0 END AS start_after
+			FROM parking_lots WHERE id = $1
+		)
+		SELECT number FROM blocks, lot
+		WHERE last_number = number + $3 - 1
+		ORDER BY (
+			SELECT distance FROM slot_entrance_distances
+			WHERE lot_id = $1 AND entrance_id = $7 AND slot = blocks.number
+		) NULLS LAST, number <= lot.start_after, number
+		LIMIT 1
+	`, parkingLotID, vehicleType, slotsRequired, StrategyRoundRobin, s.now(), reservationID, entranceID).Scan(&firstSlot)
+	if err != nil {
+		return 0, err
+	}
+
+	return firstSlot, nil
+}
@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnparkVehicleChargesUntilClockTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		parked time.Duration
+		want   int
+	}{
+		{"first hour", 40 * time.Minute, 10},
+		{"two and a half hours", 150 * time.Minute, 30},
+		{"exactly three hours", 3 * time.Hour, 30},
+		{"three hours and a second", 3*time.Hour + time.Second, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, clock := newFakeStorage(append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(testLot()))...)
+			clock.Advance(tt.parked)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.want {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.want)
+			}
+
+			transaction, ok := db.last("INSERT INTO parking_transactions")
+			if !ok {
+				t.Fatal("no parking transaction recorded")
+			}
+			if exitTime := transaction.args[5].(time.Time); !exitTime.Equal(clock.Now()) {
+				t.Errorf("recorded exit time = %v, want %v", exitTime, clock.Now())
+			}
+		})
+	}
+}
+
+func TestParkVehicleRetriesSlotTakenInFlight(t *testing.T) {
+	tests := []struct {
+		name         string
+		unavailable  int
+		wantSlot     int
+		wantAttempts int
+		wantErr      error
+	}{
+		{"slot entered maintenance once", 1, 2, 2, nil},
+		{"every picked slot taken", maxParkAttempts, 0, maxParkAttempts, ErrLotFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _ := newFakeStorage(append([]fakeResult{
+				lotResult(testLot()),
+				{match: "WITH free_spaces", rows: [][]driver.Value{{int64(1)}}, times: 1},
+				{match: "WITH free_spaces", rows: [][]driver.Value{{int64(2)}}},
+				// The picked slot was occupied or put into maintenance in the meantime.
+				{match: "SET occupied = true", times: tt.unavailable},
+			}, parkResults()...)...)
+
+			ticket, err := s.ParkVehicle(context.Background(), 1, "ABC123", ParkOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParkVehicle error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && ticket.SlotNumber != tt.wantSlot {
+				t.Errorf("slot = %d, want %d", ticket.SlotNumber, tt.wantSlot)
+			}
+			if n := db.count("SET occupied = true"); n != tt.wantAttempts {
+				t.Errorf("%d attempts to occupy a slot, want %d", n, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestConcurrentMaintenanceAndPark(t *testing.T) {
+	parker := newIntegrationStorage(t)
+	operator := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		lot, err := parker.CreateParkingLot(ctx, 2, LotOptions{})
+		if err != nil {
+			t.Fatalf("CreateParkingLot: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		var ticket *ParkingTicket
+		var parkErr, toggleErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ticket, parkErr = parker.ParkVehicle(ctx, lot.ID, fmt.Sprintf("RACE-%d", lot.ID), ParkOptions{})
+		}()
+		go func() {
+			defer wg.Done()
+			_, toggleErr = operator.ToggleMaintenance(ctx, lot.ID, 1, true, MaintenanceDetails{})
+		}()
+		wg.Wait()
+
+		if toggleErr != nil {
+			t.Fatalf("ToggleMaintenance: %v", toggleErr)
+		}
+		// Slot 2 stays free, so losing slot 1 to maintenance must not fail the park.
+		if parkErr != nil {
+			t.Fatalf("ParkVehicle: %v", parkErr)
+		}
+		if ticket.SlotNumber != 1 {
+			continue
+		}
+
+		history, err := parker.GetSlotStateHistory(ctx, lot.ID, 1, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("GetSlotStateHistory: %v", err)
+		}
+		if len(history) == 0 || history[0].Action != SlotActionPark {
+			t.Errorf("vehicle parked in slot 1 of lot %d after it entered maintenance", lot.ID)
+		}
+	}
+}
+
+func TestUnparkVehicleRecordsPrepayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		parked     time.Duration
+		wantRefund int
+		wantDue    int
+	}{
+		{"early exit", 2 * time.Hour, 10, 0},
+		{"late exit", 5 * time.Hour, 0, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stay := parkedStayRow(1, "ABC123", 1, testTime)
+			stay[9], stay[10] = int64(30), testTime.Add(3*time.Hour)
+			s, db, clock := newFakeStorage(append(unparkResults(stay), lotResult(testLot()))...)
+			clock.Advance(tt.parked)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Prepaid != 30 || fee.Refund != tt.wantRefund || fee.AmountDue != tt.wantDue {
+				t.Errorf("fee = %+v, want prepaid 30, refund %d and %d due", fee, tt.wantRefund, tt.wantDue)
+			}
+
+			transaction, _ := db.last("INSERT INTO parking_transactions")
+			if prepaid, refund := transaction.args[8], transaction.args[9]; prepaid != int64(30) || refund != int64(tt.wantRefund) {
+				t.Errorf("recorded prepaid %v and refund %v, want 30 and %d", prepaid, refund, tt.wantRefund)
+			}
+		})
+	}
+}
+
+func TestParkAndImmediatelyUnparkIsFree(t *testing.T) {
+	ctx := context.Background()
+	results := append(parkResults(), unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)
+	s, _, clock := newFakeStorage(append(results, lotResult(testLot()))...)
+
+	if _, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{}); err != nil {
+		t.Fatalf("ParkVehicle: %v", err)
+	}
+	clock.Advance(2 * time.Second)
+	fee, err := s.UnparkVehicle(ctx, 1, "ABC123", "")
+	if err != nil {
+		t.Fatalf("UnparkVehicle: %v", err)
+	}
+	if fee.Fee != 0 {
+		t.Errorf("fee for a 2 second stay = %d, want 0", fee.Fee)
+	}
+}
+
+func TestUnparkVehicleLookupFailure(t *testing.T) {
+	// The parked vehicle lookup is not answered, so it fails like a lost connection.
+	s, _, _ := newFakeStorage(lotResult(testLot()))
+
+	_, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+	if errors.Is(err, ErrVehicleNotFound) {
+		t.Fatalf("UnparkVehicle error = %v, want the failure reported rather than a missing vehicle", err)
+	}
+	var parkingErr *ParkingError
+	if !errors.As(err, &parkingErr) || parkingErr.Code != CodeInternal {
+		t.Errorf("UnparkVehicle error = %v, want an internal error", err)
+	}
+}
+
+func TestUnparkVehicleRecordsClampedDiscount(t *testing.T) {
+	RegisterFeeStrategy("test_discount", discountFeeStrategy{FeeStrategy: FlatFeeStrategy{HourlyRate: 10}, Discount: 25})
+
+	tests := []struct {
+		policy        string
+		wantFee       int
+		wantRecorded  int64
+		wantBeforeFee int64
+	}{
+		{NegativeFeeClamp, 0, 0, -5},
+		{NegativeFeeCredit, -5, -5, -5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			lot := testLot()
+			lot.FeeStrategy, lot.NegativeFeePolicy = "test_discount", tt.policy
+			s, db, clock := newFakeStorage(append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(lot))...)
+			clock.Advance(90 * time.Minute)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.wantFee {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.wantFee)
+			}
+
+			transaction, _ := db.last("INSERT INTO parking_transactions")
+			if recorded, before := transaction.args[3], transaction.args[10]; recorded != tt.wantRecorded || before != tt.wantBeforeFee {
+				t.Errorf("recorded fee %v and fee before clamp %v, want %d and %d", recorded, before, tt.wantRecorded, tt.wantBeforeFee)
+			}
+		})
+	}
+}
+
+func TestUnparkVehicleProratesMaintenance(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name        string
+		lotGrace    *bool
+		serverGrace bool
+		want        int
+	}{
+		{"enabled for the lot", &enabled, false, 30},
+		{"disabled for the lot", &disabled, true, 40},
+		{"server-wide setting", nil, true, 30},
+		{"not enabled", nil, false, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lot := testLot()
+			lot.MaintenanceGrace = tt.lotGrace
+			results := append(unparkResults(parkedStayRow(1, "ABC123", 1, testTime)), lotResult(lot),
+				// The slot was out of service for the second hour of the four hour stay.
+				fakeResult{match: "FROM maintenance_windows", rows: [][]driver.Value{{int64(1), at(time.Hour), at(2 * time.Hour)}}})
+			s, _, clock := newFakeStorage(results...)
+			s.SetMaintenanceGrace(tt.serverGrace)
+			clock.Advance(4 * time.Hour)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != tt.want {
+				t.Errorf("fee = %d, want %d", fee.Fee, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnparkVehicleFromSlotFreedByMaintenance(t *testing.T) {
+	// The slot was force-freed while the vehicle was still in it, so no slot is freed now.
+	results := append([]fakeResult{{match: "SET occupied = false"}}, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)
+	s, db, clock := newFakeStorage(append(results, lotResult(testLot()))...)
+	clock.Advance(150 * time.Minute)
+
+	fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+	if err != nil {
+		t.Fatalf("UnparkVehicle: %v", err)
+	}
+	if fee.Fee != 30 {
+		t.Errorf("fee = %d, want 30 charged from the stored entry time", fee.Fee)
+	}
+	if n := db.count("INSERT INTO parking_transactions"); n != 1 {
+		t.Errorf("%d transactions recorded, want 1", n)
+	}
+	if n := db.count("INSERT INTO slot_state_changes"); n != 0 {
+		t.Errorf("%d slot state changes recorded for a slot that was already free", n)
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var ErrExitNotPending = &ParkingError{Code: CodeExitNotPending, Status: http.StatusConflict, Message: "ticket has no pending exit"}
+
+// PendingExit is an unparked vehicle that keeps its slot until its exit is confirmed.
+// It is charged until ExitTime and confirmed automatically at ConfirmBy.
+type PendingExit struct {
+	TicketID  string    `json:"ticketID"`
+	ExitTime  time.Time `json:"exitTime"`
+	ConfirmBy time.Time `json:"confirmBy"`
+}
+
+// SetPendingExitTimeout enables pay-then-exit: unparking a vehicle charges it but keeps
+// its slot occupied until ConfirmExit or until the timeout passes. Zero frees slots on unpark.
+func (s *ParkingLotStorage) SetPendingExitTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pendingExitTimeout = timeout
+}
+
+// startPendingExit marks the exit of a parked vehicle as pending and returns its fee.
+// Unparking a vehicle whose exit is already pending returns the same fee again.
+// The caller must hold s.mu.
+func (s *ParkingLotStorage) startPendingExit(ctx context.Context, stay *parkedStay) (*FeeBreakdown, error) {
+	exitTime := stay.PendingExitAt.Time
+	if !stay.PendingExitAt.Valid {
+		exitTime = s.now()
+		_, err := s.db.ExecContext(ctx, "UPDATE parked_vehicles SET pending_exit_at = $2 WHERE id = $1", stay.ID, exitTime)
+		if err != nil {
+			return nil, internalError("failed to unpark vehicle")
+		}
+	}
+
+	fee, err := s.stayFee(ctx, s.db, stay, exitTime)
+	if err != nil {
+		return nil, err
+	}
+	lot, err := s.lotMeta(ctx, stay.ParkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	fee.PendingExit = &PendingExit{
+		TicketID:  stay.TicketID.String,
+		ExitTime:  exitTime,
+		ConfirmBy: exitTime.Add(s.pendingExitTimeout),
+	}
+	inLotZone(lot, &fee.PendingExit.ExitTime, &fee.PendingExit.ConfirmBy)
+
+	return fee, nil
+}
+
+// ConfirmExit frees the slots of a vehicle whose exit is pending and records its
+// transaction, charged until the time it was unparked.
+func (s *ParkingLotStorage) ConfirmExit(ctx context.Context, ticketID string) (*FeeBreakdown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stay, err := scanParkedStay(s.db.QueryRowContext(ctx, `
+		SELECT `+parkedStayColumns+`
+		FROM parked_vehicles
+		WHERE parked_vehicles.ticket_id = $1
+	`, ticketID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to look up ticket")
+	}
+	if stay.ExitTime.Valid {
+		return nil, ErrTicketClosed
+	}
+	if !stay.PendingExitAt.Valid {
+		return nil, ErrExitNotPending
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError("failed to confirm exit")
+	}
+	defer tx.Rollback()
+
+	fee, err := s.closeStay(ctx, tx, stay, stay.PendingExitAt.Time, false)
+	if errors.Is(err, ErrAlreadyUnparked) {
+		return nil, ErrTicketClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, internalError("failed to confirm exit")
+	}
+
+	return fee, nil
+}
+
+// confirmTimedOutExits confirms the pending exits older than the pending exit timeout
+// and returns how many were confirmed. The caller must hold s.mu.
+func (s *ParkingLotStorage) confirmTimedOutExits(ctx context.Context) (int64, error) {
+	if s.pendingExitTimeout <= 0 {
+		return 0, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+parkedStayColumns+`
+		FROM parked_vehicles
+		WHERE parked_vehicles.exit_time IS NULL AND parked_vehicles.pending_exit_at <= $1
+	`, s.now().Add(-s.pendingExitTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	var stays []*parkedStay
+	for rows.Next() {
+		stay, err := scanParkedStay(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stays = append(stays, stay)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var confirmed int64
+	for _, stay := range stays {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return confirmed, err
+		}
+		_, err = s.closeStay(ctx, tx, stay, stay.PendingExitAt.Time, false)
+		if errors.Is(err, ErrAlreadyUnparked) {
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return confirmed, err
+		}
+		if err := tx.Commit(); err != nil {
+			return confirmed, err
+		}
+		confirmed++
+	}
+
+	return confirmed, nil
+}
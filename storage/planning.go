@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// planningHistory is how far back completed stays are averaged for planning figures.
+const planningHistory = 90 * 24 * time.Hour
+
+// BreakEvenAssumptions are the inputs a break-even estimate was based on.
+type BreakEvenAssumptions struct {
+	TotalSpaces            int     `json:"totalSpaces"`
+	HourlyRate             int     `json:"hourlyRate"`
+	AverageStayHours       float64 `json:"averageStayHours"`
+	EffectiveHourlyRevenue float64 `json:"effectiveHourlyRevenue"`
+	SampleSize             int     `json:"sampleSize"`
+	HistoryDays            int     `json:"historyDays"`
+}
+
+// BreakEven is the occupancy a parking lot needs to cover its daily operating cost.
+type BreakEven struct {
+	ParkingLotID     int                  `json:"parkingLotID"`
+	DailyCost        int                  `json:"dailyCost"`
+	OccupancyPercent float64              `json:"occupancyPercent"`
+	Achievable       bool                 `json:"achievable"`
+	Assumptions      BreakEvenAssumptions `json:"assumptions"`
+}
+
+// GetBreakEvenOccupancy estimates the occupancy percentage at which the specified lot's
+// revenue covers dailyCost. Because fees are charged per started hour, an occupied slot
+// earns more than the hourly rate per hour actually parked; the lot's recent average stay
+// is used to estimate that effective hourly revenue.
+func (s *ParkingLotStorage) GetBreakEvenOccupancy(ctx context.Context, parkingLotID int, dailyCost int) (*BreakEven, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if dailyCost < 0 {
+		return nil, invalidRequest("daily cost must not be negative")
+	}
+
+	var totalSpaces, samples int
+	var averageStaySeconds sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			parking_lots.total_spaces,
+			COUNT(parking_transactions.id),
+			AVG(EXTRACT(EPOCH FROM (parking_transactions.exit_time - parking_transactions.entry_time)))
+		FROM parking_lots
+		LEFT JOIN parking_transactions ON parking_transactions.lot_id = parking_lots.id
+			AND parking_transactions.exit_time >= $2
+			AND parking_transactions.exit_time > parking_transactions.entry_time
+		WHERE parking_lots.id = $1
+		GROUP BY parking_lots.id
+	`, parkingLotID, s.now().Add(-planningHistory)).Scan(&totalSpaces, &samples, &averageStaySeconds)
+	if err == sql.ErrNoRows {
+		return nil, ErrLotNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to compute break-even occupancy")
+	}
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	hourlyRate := ParkingFeeperHour * minorUnitScale(lot.Currency)
+
+	assumptions := BreakEvenAssumptions{
+		TotalSpaces:            totalSpaces,
+		HourlyRate:             hourlyRate,
+		EffectiveHourlyRevenue: float64(hourlyRate),
+		SampleSize:             samples,
+		HistoryDays:            int(planningHistory.Hours() / 24),
+	}
+	if averageStaySeconds.Valid {
+		averageStay := averageStaySeconds.Float64 / 3600
+		assumptions.AverageStayHours = averageStay
+		assumptions.EffectiveHourlyRevenue = math.Ceil(averageStay) * float64(hourlyRate) / averageStay
+	}
+
+	breakEven := &BreakEven{ParkingLotID: parkingLotID, DailyCost: dailyCost, Assumptions: assumptions}
+	maxDailyRevenue := float64(totalSpaces) * 24 * assumptions.EffectiveHourlyRevenue
+	if maxDailyRevenue == 0 {
+		breakEven.Achievable = dailyCost == 0
+		return breakEven, nil
+	}
+
+	breakEven.OccupancyPercent = float64(dailyCost) / maxDailyRevenue * 100
+	breakEven.Achievable = breakEven.OccupancyPercent <= 100
+	return breakEven, nil
+}
+
+// MaxDailyRevenueAssumptions are the inputs a maximum daily revenue was based on: every
+// space occupied by one single-slot vehicle for the whole of Day, charged by FeeStrategy.
+type MaxDailyRevenueAssumptions struct {
+	TotalSpaces     int    `json:"totalSpaces"`
+	FeeStrategy     string `json:"feeStrategy"`
+	Day             string `json:"day"`
+	HoursPerSpace   int    `json:"hoursPerSpace"`
+	RevenuePerSpace int    `json:"revenuePerSpace"`
+}
+
+// MaxDailyRevenue is an upper bound on what a parking lot can earn in a day.
+type MaxDailyRevenue struct {
+	ParkingLotID int                        `json:"parkingLotID"`
+	MaxRevenue   int                        `json:"maxRevenue"`
+	Assumptions  MaxDailyRevenueAssumptions `json:"assumptions"`
+}
+
+// GetMaxDailyRevenue computes the revenue of the specified lot if every space were
+// occupied all of today in the lot's timezone, using the lot's fee strategy so peak rates are included.
+func (s *ParkingLotStorage) GetMaxDailyRevenue(ctx context.Context, parkingLotID int) (*MaxDailyRevenue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	strategy, ok := lookupFeeStrategy(lot.FeeStrategy)
+	if !ok {
+		return nil, internalError("unknown fee strategy " + lot.FeeStrategy)
+	}
+
+	now := s.now().In(lot.Location)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, lot.Location)
+	stay := Stay{ParkingLotID: parkingLotID, SlotsRequired: 1, EntryTime: dayStart, ExitTime: dayStart.Add(24 * time.Hour), Location: lot.Location}
+	fee, err := strategy.Compute(ctx, stay)
+	if err != nil {
+		return nil, err
+	}
+	scaleFee(fee, lot.Currency)
+
+	return &MaxDailyRevenue{
+		ParkingLotID: parkingLotID,
+		MaxRevenue:   fee.Fee * lot.TotalSpaces,
+		Assumptions: MaxDailyRevenueAssumptions{
+			TotalSpaces:     lot.TotalSpaces,
+			FeeStrategy:     lot.FeeStrategy,
+			Day:             dayStart.Format("2006-01-02"),
+			HoursPerSpace:   24,
+			RevenuePerSpace: fee.Fee,
+		},
+	}, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// MaxLicensePlateLength is the longest license plate accepted.
+const MaxLicensePlateLength = 16
+
+// normalizedPlateColumn is parked_vehicles.license_plate normalized like normalizePlate.
+const normalizedPlateColumn = `UPPER(TRANSLATE(parked_vehicles.license_plate, ' -', ''))`
+
+// validateLicensePlate rejects empty or overlong plates and plates with characters other
+// than letters, digits, spaces and hyphens.
+func validateLicensePlate(plate string) error {
+	if plate == "" {
+		return invalidRequest("license plate is required")
+	}
+	if len(plate) > MaxLicensePlateLength {
+		return invalidRequest("license plate must be at most %d characters", MaxLicensePlateLength)
+	}
+	for _, c := range plate {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == ' ', c == '-':
+		default:
+			return invalidRequest("license plate may only contain letters, digits, spaces and hyphens")
+		}
+	}
+	return nil
+}
+
+// normalizePlate uppercases a plate and drops its spaces and hyphens, so that plates
+// read or typed differently still match.
+func normalizePlate(plate string) string {
+	plate = strings.ToUpper(plate)
+	return strings.NewReplacer(" ", "", "-", "").Replace(plate)
+}
+
+// ParkedLocation is where a vehicle is parked.
+type ParkedLocation struct {
+	ParkingLotID int       `json:"parkingLotID"`
+	SlotNumber   int       `json:"slotNumber"`
+	LicensePlate string    `json:"licensePlate"`
+	EntryTime    time.Time `json:"entryTime"`
+}
+
+// PlateStatus tells whether a plate is parked and where. A plate is only parked in more
+// than one lot when multi-lot plates are allowed.
+type PlateStatus struct {
+	LicensePlate string            `json:"licensePlate"`
+	Parked       bool              `json:"parked"`
+	Locations    []*ParkedLocation `json:"locations"`
+}
+
+// IsVehicleParked looks the plate up across all lots, ignoring case, spaces and hyphens.
+func (s *ParkingLotStorage) IsVehicleParked(ctx context.Context, licensePlate string) (*PlateStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := validateLicensePlate(licensePlate); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parked_vehicles.parking_lot_id, parked_vehicles.slot, parked_vehicles.license_plate, parked_vehicles.entry_time
+		FROM parked_vehicles
+		WHERE `+normalizedPlateColumn+` = $1 AND parked_vehicles.exit_time IS NULL
+		ORDER BY parked_vehicles.entry_time, parked_vehicles.id
+	`, normalizePlate(licensePlate))
+	if err != nil {
+		return nil, internalError("failed to look up parked vehicle")
+	}
+	defer rows.Close()
+
+	status := &PlateStatus{LicensePlate: licensePlate, Locations: []*ParkedLocation{}}
+	for rows.Next() {
+		var location ParkedLocation
+		if err := rows.Scan(&location.ParkingLotID, &location.SlotNumber, &location.LicensePlate, &location.EntryTime); err != nil {
+			return nil, internalError("failed to read parked vehicle")
+		}
+		status.Locations = append(status.Locations, &location)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parked vehicle")
+	}
+
+	for _, location := range status.Locations {
+		if err := s.inZoneOfLot(ctx, location.ParkingLotID, &location.EntryTime); err != nil {
+			return nil, err
+		}
+	}
+
+	status.Parked = len(status.Locations) > 0
+	return status, nil
+}
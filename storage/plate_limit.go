@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+)
+
+// ErrPlateParkedElsewhere matches the error returned when a plate is already parked in
+// another lot; the returned error names that lot.
+var ErrPlateParkedElsewhere = &ParkingError{Code: CodePlateParkedElsewhere, Status: http.StatusConflict, Message: "vehicle is already parked in another lot"}
+
+// SetAllowMultiLotPlates controls whether a plate may be parked in several lots at the
+// same time. By default it may only be parked in one, which stops cloned tickets being
+// used in many lots at once.
+func (s *ParkingLotStorage) SetAllowMultiLotPlates(allowed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowMultiLotPlates = allowed
+}
+
+// checkNotParkedElsewhere fails when the plate is parked in a lot other than the given one,
+// unless plates may be parked in several lots. The caller must hold s.mu.
+func (s *ParkingLotStorage) checkNotParkedElsewhere(ctx context.Context, parkingLotID int, licensePlate string) error {
+	if s.allowMultiLotPlates {
+		return nil
+	}
+
+	var otherLotID int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT parking_lot_id
+		FROM parked_vehicles
+		WHERE license_plate = $1 AND exit_time IS NULL AND parking_lot_id <> $2
+		ORDER BY entry_time
+		LIMIT 1
+	`, licensePlate, parkingLotID).Scan(&otherLotID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return internalError("failed to look up parked vehicle")
+	}
+
+	return newError(CodePlateParkedElsewhere, http.StatusConflict, "vehicle is already parked in lot %d", otherLotID)
+}
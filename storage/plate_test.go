@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateLicensePlate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plate   string
+		wantErr bool
+	}{
+		{"letters and digits", "ABC123", false},
+		{"spaces and hyphens", "DHA-12 34", false},
+		{"longest allowed", strings.Repeat("A", MaxLicensePlateLength), false},
+		{"empty", "", true},
+		{"oversized", strings.Repeat("A", MaxLicensePlateLength+1), true},
+		{"megabyte", strings.Repeat("A", 1<<20), true},
+		{"SQL", "A'; DROP TABLE--", true},
+		{"quote", `AB"C`, true},
+		{"newline", "ABC\n123", true},
+		{"non-ASCII letter", "ÄBC123", true},
+		{"emoji", "ABC🚗", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLicensePlate(tt.plate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateLicensePlate(%q) = %v, want error %v", tt.plate, err, tt.wantErr)
+			}
+			var parkingErr *ParkingError
+			if err != nil && (!errors.As(err, &parkingErr) || parkingErr.Status != http.StatusBadRequest) {
+				t.Errorf("validateLicensePlate(%q) = %v, want a bad request", tt.plate, err)
+			}
+		})
+	}
+}
+
+func TestInvalidPlatesNeverReachTheDatabase(t *testing.T) {
+	ctx := context.Background()
+	plate := strings.Repeat("A", 1<<20)
+	s, db, _ := newFakeStorage()
+
+	if _, err := s.ParkVehicle(ctx, 1, plate, ParkOptions{}); err == nil {
+		t.Error("ParkVehicle accepted an oversized plate")
+	}
+	if _, err := s.UnparkVehicle(ctx, 1, plate, ""); err == nil {
+		t.Error("UnparkVehicle accepted an oversized plate")
+	}
+	if n := len(db.statements); n != 0 {
+		t.Errorf("%d statements executed for an invalid plate", n)
+	}
+}
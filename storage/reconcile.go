@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons a slot is suspected of ghost occupancy.
+const (
+	SuspectNoParkedVehicle = "no_parked_vehicle"
+	SuspectLongestParked   = "longest_parked"
+)
+
+// SuspectSlot is a slot that may be occupied in the system but empty in the lot.
+type SuspectSlot struct {
+	SlotNumber   int       `json:"slotNumber"`
+	Reason       string    `json:"reason"`
+	LicensePlate string    `json:"licensePlate,omitempty"`
+	EntryTime    time.Time `json:"entryTime"`
+}
+
+// Reconciliation compares the vehicles a lot's sensors count with those the system
+// has parked. Orphaned slots count as one vehicle each. A positive discrepancy means
+// the system sees more vehicles than the sensors do.
+type Reconciliation struct {
+	ParkingLotID   int            `json:"parkingLotID"`
+	SensorOccupied int            `json:"sensorOccupied"`
+	SystemOccupied int            `json:"systemOccupied"`
+	Discrepancy    int            `json:"discrepancy"`
+	SuspectSlots   []*SuspectSlot `json:"suspectSlots"`
+	FreedSlots     []int          `json:"freedSlots,omitempty"`
+}
+
+// Reconcile compares sensorOccupied with the number of vehicles parked in the specified
+// lot. When the system sees more, the suspects are the orphaned slots and then, for any
+// remaining difference, the vehicles parked longest. Nothing is changed unless fix is
+// set, in which case the orphaned slots are freed; parked vehicles are never unparked.
+func (s *ParkingLotStorage) Reconcile(ctx context.Context, parkingLotID, sensorOccupied int, fix bool) (*Reconciliation, error) {
+	if fix {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	if sensorOccupied < 0 {
+		return nil, invalidRequest("sensor occupied count must not be negative")
+	}
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	orphanedSlots, err := findOrphanedSlots(ctx, s.db, parkingLotID, lot)
+	if err != nil {
+		return nil, err
+	}
+
+	var parked int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM parked_vehicles
+		WHERE parking_lot_id = $1 AND exit_time IS NULL
+	`, parkingLotID).Scan(&parked)
+	if err != nil {
+		return nil, internalError("failed to count parked vehicles")
+	}
+
+	reconciliation := &Reconciliation{
+		ParkingLotID:   parkingLotID,
+		SensorOccupied: sensorOccupied,
+		SystemOccupied: parked + len(orphanedSlots),
+		SuspectSlots:   []*SuspectSlot{},
+	}
+	reconciliation.Discrepancy = reconciliation.SystemOccupied - sensorOccupied
+
+	if reconciliation.Discrepancy > 0 {
+		for _, slot := range orphanedSlots {
+			reconciliation.SuspectSlots = append(reconciliation.SuspectSlots, &SuspectSlot{SlotNumber: slot.SlotNumber, Reason: SuspectNoParkedVehicle, EntryTime: slot.EntryTime})
+		}
+
+		if remaining := reconciliation.Discrepancy - len(orphanedSlots); remaining > 0 {
+			rows, err := s.db.QueryContext(ctx, `
+				SELECT slot, license_plate, entry_time
+				FROM parked_vehicles
+				WHERE parking_lot_id = $1 AND exit_time IS NULL
+				ORDER BY entry_time, id
+				LIMIT $2
+			`, parkingLotID, remaining)
+			if err != nil {
+				return nil, internalError("failed to retrieve parked vehicles")
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				suspect := SuspectSlot{Reason: SuspectLongestParked}
+				if err := rows.Scan(&suspect.SlotNumber, &suspect.LicensePlate, &suspect.EntryTime); err != nil {
+					return nil, internalError("failed to read parked vehicles")
+				}
+				inLotZone(lot, &suspect.EntryTime)
+				reconciliation.SuspectSlots = append(reconciliation.SuspectSlots, &suspect)
+			}
+
+			if err := rows.Err(); err != nil {
+				return nil, internalError("error processing parked vehicles")
+			}
+		}
+	}
+
+	if fix && len(orphanedSlots) > 0 {
+		reconciliation.FreedSlots, err = repairOrphanedSlots(ctx, s.db, parkingLotID, s.now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reconciliation, nil
+}
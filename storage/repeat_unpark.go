@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+var ErrAlreadyUnparked = &ParkingError{Code: CodeAlreadyUnparked, Status: http.StatusConflict, Message: "vehicle already unparked"}
+
+// repeatUnparkWindow is how long after a vehicle left another unpark of it is treated
+// as a repeat, e.g. from a second gate terminal scanning the same car.
+const repeatUnparkWindow = 5 * time.Minute
+
+// SetIdempotentUnpark controls what a repeated unpark of a vehicle that just left returns:
+// the fee it was charged, flagged AlreadyUnparked, or ErrAlreadyUnparked. Either way it is
+// only charged once.
+func (s *ParkingLotStorage) SetIdempotentUnpark(idempotent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotentUnpark = idempotent
+}
+
+// repeatedUnpark answers an unpark of a vehicle that is no longer parked in the lot. It
+// fails with ErrVehicleNotFound unless the vehicle left within repeatUnparkWindow.
+// The caller must hold s.mu.
+func (s *ParkingLotStorage) repeatedUnpark(ctx context.Context, parkingLotID int, licensePlate string) (*FeeBreakdown, error) {
+	transaction, err := scanTransaction(s.db.QueryRowContext(ctx, `
+		SELECT `+transactionColumns+`
+		FROM parking_transactions
+		WHERE lot_id = $1 AND vehicle_license_plate = $2 AND exit_time >= $3
+		ORDER BY exit_time DESC, id DESC
+		LIMIT 1
+	`, parkingLotID, licensePlate, s.now().Add(-repeatUnparkWindow)))
+	if err == sql.ErrNoRows {
+		return nil, ErrVehicleNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to look up last transaction")
+	}
+	if !s.idempotentUnpark {
+		return nil, ErrAlreadyUnparked
+	}
+
+	fee := &FeeBreakdown{
+		Fee:             transaction.Fee,
+		Prepaid:         transaction.PrepaidAmount,
+		Refund:          transaction.Refund,
+		ValidationCode:  transaction.ValidationCode,
+		Validated:       transaction.Validated,
+		AlreadyUnparked: true,
+	}
+	if transaction.Validated > 0 {
+		fee.CustomerFee = transaction.Fee - transaction.Validated
+	}
+
+	return fee, nil
+}
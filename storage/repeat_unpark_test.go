@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnparkVehicleLosingTheRace(t *testing.T) {
+	tests := []struct {
+		name       string
+		idempotent bool
+		wantErr    error
+	}{
+		{"repeat rejected", false, ErrAlreadyUnparked},
+		{"idempotent", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Another terminal closed the stay between reading and closing it.
+			s, db, clock := newFakeStorage(append([]fakeResult{
+				{match: "UPDATE parked_vehicles SET exit_time", rowsAffected: 0},
+				{match: "FROM parking_transactions", rows: [][]driver.Value{{
+					int64(7), int64(1), "ABC123", int64(1), nil, testTime, at(time.Hour), int64(10), int64(0), int64(0), "", false, "", int64(0),
+				}}},
+			}, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)...)
+			s.SetIdempotentUnpark(tt.idempotent)
+			clock.Advance(time.Hour)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnparkVehicle error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (!fee.AlreadyUnparked || fee.Fee != 10) {
+				t.Errorf("fee = %+v, want the 10 already charged", fee)
+			}
+			for _, statement := range []string{"SET occupied = false", "INSERT INTO parking_transactions"} {
+				if n := db.count(statement); n != 0 {
+					t.Errorf("%q executed %d times by the losing unpark", statement, n)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentUnpark(t *testing.T) {
+	terminals := []*ParkingLotStorage{newIntegrationStorage(t), newIntegrationStorage(t)}
+	ctx := context.Background()
+
+	lot, err := terminals[0].CreateParkingLot(ctx, 1, LotOptions{})
+	if err != nil {
+		t.Fatalf("CreateParkingLot: %v", err)
+	}
+	plate := fmt.Sprintf("TWICE-%d", lot.ID)
+	if _, err := terminals[0].ParkVehicle(ctx, lot.ID, plate, ParkOptions{}); err != nil {
+		t.Fatalf("ParkVehicle: %v", err)
+	}
+
+	errs := make([]error, len(terminals))
+	var wg sync.WaitGroup
+	for i, terminal := range terminals {
+		wg.Add(1)
+		go func(i int, terminal *ParkingLotStorage) {
+			defer wg.Done()
+			_, errs[i] = terminal.UnparkVehicle(ctx, lot.ID, plate, "")
+		}(i, terminal)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrAlreadyUnparked):
+			t.Errorf("UnparkVehicle: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of two concurrent unparks succeeded, want 1", succeeded)
+	}
+
+	transactions, err := terminals[0].ListTransactions(ctx, TransactionFilter{ParkingLotID: lot.ID})
+	if err != nil {
+		t.Fatalf("ListTransactions: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Errorf("%d transactions recorded, want the vehicle charged once", len(transactions))
+	}
+}
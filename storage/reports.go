@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LotReport holds the daily statistics of one parking lot.
+type LotReport struct {
+	ParkingLotID int           `json:"parking_lot_id"`
+	DailyStats   []*DailyStats `json:"daily_stats"`
+}
+
+// GetReportsForLots retrieves daily statistics for several parking lots in one query.
+// A zero from or to leaves that end of the period open.
+func (s *ParkingLotStorage) GetReportsForLots(ctx context.Context, lotIDs []int, from, to time.Time) (_ []*LotReport, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	return s.reportsForLots(ctx, lotIDs, from, to)
+}
+
+func (s *ParkingLotStorage) reportsForLots(ctx context.Context, lotIDs []int, from, to time.Time) ([]*LotReport, error) {
+	if len(lotIDs) == 0 {
+		return nil, invalidRequest("no parking lots requested")
+	}
+
+	if err := s.checkLotsExist(ctx, lotIDs); err != nil {
+		return nil, err
+	}
+	lots := make(map[int]lotMeta, len(lotIDs))
+	for _, id := range lotIDs {
+		lot, err := s.lotMeta(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		lots[id] = lot
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			parking_transactions.lot_id,
+			`+localDate("parking_transactions.exit_time", "parking_lots.timezone")+` AS day,
+			COUNT(*) AS total_vehicles,
+			COALESCE(SUM(EXTRACT(EPOCH FROM (parking_transactions.exit_time - parking_transactions.entry_time)) / 3600), 0) AS total_parking_time,
+			COALESCE(SUM(parking_transactions.fee), 0) AS total_fee,
+			COUNT(*) FILTER (WHERE parking_transactions.employee) AS employee_vehicles
+		FROM parking_transactions
+		JOIN parking_lots ON parking_lots.id = parking_transactions.lot_id
+		WHERE parking_transactions.lot_id = ANY($1) AND parking_transactions.voided_at IS NULL
+		AND ($2::timestamp IS NULL OR parking_transactions.exit_time >= $2)
+		AND ($3::timestamp IS NULL OR parking_transactions.exit_time < $3)
+		GROUP BY parking_transactions.lot_id, day
+		ORDER BY parking_transactions.lot_id, day
+	`, pq.Array(lotIDs), nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, internalError("failed to retrieve daywise total statistics")
+	}
+	defer rows.Close()
+
+	reports := make(map[int]*LotReport, len(lotIDs))
+	for _, id := range lotIDs {
+		reports[id] = &LotReport{ParkingLotID: id}
+	}
+	for rows.Next() {
+		var lotID int
+		var dailyStats DailyStats
+		if err := rows.Scan(&lotID, &dailyStats.Day, &dailyStats.TotalVehicles, &dailyStats.TotalParkingTime, &dailyStats.TotalFee, &dailyStats.EmployeeVehicles); err != nil {
+			return nil, internalError("failed to read daywise total statistics")
+		}
+		dailyStats.Day = lotDay(lots[lotID], dailyStats.Day)
+		reports[lotID].DailyStats = append(reports[lotID].DailyStats, &dailyStats)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing daywise total statistics")
+	}
+
+	lotReports := []*LotReport{}
+	seen := make(map[int]bool, len(lotIDs))
+	for _, id := range lotIDs {
+		if !seen[id] {
+			seen[id] = true
+			lotReports = append(lotReports, reports[id])
+		}
+	}
+
+	return lotReports, nil
+}
+
+// checkLotsExist returns an error naming every id in lotIDs that is not a parking lot.
+func (s *ParkingLotStorage) checkLotsExist(ctx context.Context, lotIDs []int) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM parking_lots WHERE id = ANY($1)", pq.Array(lotIDs))
+	if err != nil {
+		return internalError("failed to look up parking lots")
+	}
+	defer rows.Close()
+
+	found := make(map[int]bool, len(lotIDs))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return internalError("failed to look up parking lots")
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return internalError("failed to look up parking lots")
+	}
+
+	var missing []string
+	for _, id := range lotIDs {
+		if !found[id] {
+			missing = append(missing, fmt.Sprint(id))
+		}
+	}
+	if len(missing) > 0 {
+		return newError(CodeLotNotFound, http.StatusNotFound, "parking lot not found: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// nullTime maps the zero time to NULL so queries can treat it as an open bound.
+// Other times are converted to UTC, matching how timestamps are stored.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// VehicleTypeStats represents the statistics of one vehicle type in a parking lot for a day.
+type VehicleTypeStats struct {
+	Day           time.Time `json:"day"`
+	VehicleType   string    `json:"vehicle_type"`
+	TotalVehicles int       `json:"total_vehicles"`
+	TotalFee      int       `json:"total_fee"`
+}
+
+// GetReportsByVehicleType retrieves daily vehicle counts and revenue per vehicle type.
+// A zero from or to leaves that end of the period open.
+func (s *ParkingLotStorage) GetReportsByVehicleType(ctx context.Context, parkingLotID int, from, to time.Time) (_ []*VehicleTypeStats, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			`+localDate("exit_time", "$4")+` AS day,
+			vehicle_type,
+			COUNT(*) AS total_vehicles,
+			COALESCE(SUM(fee), 0) AS total_fee
+		FROM parking_transactions
+		WHERE lot_id = $1 AND voided_at IS NULL
+		AND ($2::timestamp IS NULL OR exit_time >= $2)
+		AND ($3::timestamp IS NULL OR exit_time < $3)
+		GROUP BY day, vehicle_type
+		ORDER BY day, vehicle_type
+	`, parkingLotID, nullTime(from), nullTime(to), lot.Location.String())
+	if err != nil {
+		return nil, internalError("failed to retrieve vehicle type statistics")
+	}
+	defer rows.Close()
+
+	statsList := []*VehicleTypeStats{}
+	for rows.Next() {
+		var stats VehicleTypeStats
+		if err := rows.Scan(&stats.Day, &stats.VehicleType, &stats.TotalVehicles, &stats.TotalFee); err != nil {
+			return nil, internalError("failed to read vehicle type statistics")
+		}
+		stats.Day = lotDay(lot, stats.Day)
+		statsList = append(statsList, &stats)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing vehicle type statistics")
+	}
+
+	return statsList, nil
+}
+
+// SlotRevenue is the revenue earned by one slot of a parking lot.
+type SlotRevenue struct {
+	SlotNumber   int `json:"slot_number"`
+	Transactions int `json:"transactions"`
+	TotalFee     int `json:"total_fee"`
+}
+
+// GetRevenueBySlot attributes the revenue of each transaction to the slot it used.
+// Transactions of oversized vehicles are attributed to the first slot of their block.
+// A zero from or to leaves that end of the period open.
+func (s *ParkingLotStorage) GetRevenueBySlot(ctx context.Context, parkingLotID int, from, to time.Time) (_ []*SlotRevenue, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT slot, COUNT(*), COALESCE(SUM(fee), 0)
+		FROM parking_transactions
+		WHERE lot_id = $1 AND slot IS NOT NULL AND voided_at IS NULL
+		AND ($2::timestamp IS NULL OR exit_time >= $2)
+		AND ($3::timestamp IS NULL OR exit_time < $3)
+		GROUP BY slot
+		ORDER BY slot
+	`, parkingLotID, nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, internalError("failed to retrieve revenue by slot")
+	}
+	defer rows.Close()
+
+	revenues := []*SlotRevenue{}
+	for rows.Next() {
+		var revenue SlotRevenue
+		if err := rows.Scan(&revenue.SlotNumber, &revenue.Transactions, &revenue.TotalFee); err != nil {
+			return nil, internalError("failed to read revenue by slot")
+		}
+		revenues = append(revenues, &revenue)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing revenue by slot")
+	}
+
+	return revenues, nil
+}
+
+// AverageFee is the mean and median fee of a parking lot's transactions over a period.
+// Both are zero when there were no transactions.
+type AverageFee struct {
+	ParkingLotID int     `json:"parking_lot_id"`
+	Transactions int     `json:"transactions"`
+	TotalFee     int     `json:"total_fee"`
+	AverageFee   float64 `json:"average_fee"`
+	MedianFee    float64 `json:"median_fee"`
+}
+
+// GetAverageFee computes the average and median fee of the non-voided transactions that
+// exited the specified lot in the period. A zero from or to leaves that end open.
+func (s *ParkingLotStorage) GetAverageFee(ctx context.Context, parkingLotID int, from, to time.Time) (_ *AverageFee, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	average := &AverageFee{ParkingLotID: parkingLotID}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(fee), 0),
+			COALESCE(AVG(fee), 0),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY fee), 0)
+		FROM parking_transactions
+		WHERE lot_id = $1 AND fee IS NOT NULL AND voided_at IS NULL
+		AND ($2::timestamp IS NULL OR exit_time >= $2)
+		AND ($3::timestamp IS NULL OR exit_time < $3)
+	`, parkingLotID, nullTime(from), nullTime(to)).Scan(&average.Transactions, &average.TotalFee, &average.AverageFee, &average.MedianFee)
+	if err != nil {
+		return nil, internalError("failed to compute average fee")
+	}
+
+	return average, nil
+}
+
+// DurationStats summarizes how long vehicles of one type stayed in a parking lot, in minutes.
+type DurationStats struct {
+	VehicleType    string  `json:"vehicle_type"`
+	Samples        int     `json:"samples"`
+	AverageMinutes float64 `json:"average_minutes"`
+	MinMinutes     float64 `json:"min_minutes"`
+	MaxMinutes     float64 `json:"max_minutes"`
+}
+
+// GetDurationStatsByType computes the average, shortest and longest stay per vehicle type
+// of the transactions that exited the specified lot in the period. A zero from or to
+// leaves that end of the period open.
+func (s *ParkingLotStorage) GetDurationStatsByType(ctx context.Context, parkingLotID int, from, to time.Time) (_ []*DurationStats, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			vehicle_type,
+			COUNT(*),
+			AVG(EXTRACT(EPOCH FROM (exit_time - entry_time)) / 60),
+			MIN(EXTRACT(EPOCH FROM (exit_time - entry_time)) / 60),
+			MAX(EXTRACT(EPOCH FROM (exit_time - entry_time)) / 60)
+		FROM parking_transactions
+		WHERE lot_id = $1 AND exit_time IS NOT NULL AND voided_at IS NULL
+		AND ($2::timestamp IS NULL OR exit_time >= $2)
+		AND ($3::timestamp IS NULL OR exit_time < $3)
+		GROUP BY vehicle_type
+		ORDER BY vehicle_type
+	`, parkingLotID, nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, internalError("failed to retrieve duration statistics")
+	}
+	defer rows.Close()
+
+	statsList := []*DurationStats{}
+	for rows.Next() {
+		var stats DurationStats
+		if err := rows.Scan(&stats.VehicleType, &stats.Samples, &stats.AverageMinutes, &stats.MinMinutes, &stats.MaxMinutes); err != nil {
+			return nil, internalError("failed to read duration statistics")
+		}
+		statsList = append(statsList, &stats)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing duration statistics")
+	}
+
+	return statsList, nil
+}
+
+// RevenueTrend compares the revenue of a parking lot so far in the current period with
+// the same stretch of the previous period. ChangePercent is omitted when the previous
+// period earned nothing.
+type RevenueTrend struct {
+	ParkingLotID    int       `json:"parking_lot_id"`
+	Period          string    `json:"period"`
+	CurrentFrom     time.Time `json:"current_from"`
+	CurrentTo       time.Time `json:"current_to"`
+	CurrentRevenue  int       `json:"current_revenue"`
+	PreviousFrom    time.Time `json:"previous_from"`
+	PreviousTo      time.Time `json:"previous_to"`
+	PreviousRevenue int       `json:"previous_revenue"`
+	ChangePercent   *float64  `json:"change_percent,omitempty"`
+}
+
+// GetRevenueTrend returns the revenue of the non-voided transactions that exited the
+// specified lot since the start of the current day, week (from Monday) or month in the
+// lot's timezone, next to the revenue of the previous period over the same elapsed time.
+func (s *ParkingLotStorage) GetRevenueTrend(ctx context.Context, parkingLotID int, period string) (_ *RevenueTrend, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ctx, done := s.withReadTimeout(ctx)
+	defer done(&err)
+
+	if period != "day" && period != "week" && period != "month" {
+		return nil, invalidRequest("period must be day, week or month")
+	}
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().In(lot.Location)
+	currentFrom := startOfDay(now)
+	var previousFrom time.Time
+	switch period {
+	case "day":
+		previousFrom = currentFrom.AddDate(0, 0, -1)
+	case "week":
+		currentFrom = currentFrom.AddDate(0, 0, -(int(currentFrom.Weekday())+6)%7)
+		previousFrom = currentFrom.AddDate(0, 0, -7)
+	case "month":
+		currentFrom = currentFrom.AddDate(0, 0, 1-currentFrom.Day())
+		previousFrom = currentFrom.AddDate(0, -1, 0)
+	}
+
+	previousTo := previousFrom.Add(now.Sub(currentFrom))
+	if previousTo.After(currentFrom) {
+		previousTo = currentFrom
+	}
+
+	trend := &RevenueTrend{
+		ParkingLotID: parkingLotID,
+		Period:       period,
+		CurrentFrom:  currentFrom,
+		CurrentTo:    now,
+		PreviousFrom: previousFrom,
+		PreviousTo:   previousTo,
+	}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(fee) FILTER (WHERE exit_time >= $2 AND exit_time < $3), 0),
+			COALESCE(SUM(fee) FILTER (WHERE exit_time >= $4 AND exit_time < $5), 0)
+		FROM parking_transactions
+		WHERE lot_id = $1 AND voided_at IS NULL
+	`, parkingLotID, currentFrom.UTC(), now.UTC(), previousFrom.UTC(), previousTo.UTC()).Scan(&trend.CurrentRevenue, &trend.PreviousRevenue)
+	if err != nil {
+		return nil, internalError("failed to compute revenue trend")
+	}
+
+	if trend.PreviousRevenue != 0 {
+		change := 100 * float64(trend.CurrentRevenue-trend.PreviousRevenue) / float64(trend.PreviousRevenue)
+		trend.ChangePercent = &change
+	}
+
+	return trend, nil
+}
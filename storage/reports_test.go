@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyListsMarshalAsArrays(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		list func(s *ParkingLotStorage) (interface{}, error)
+	}{
+		{"reports", func(s *ParkingLotStorage) (interface{}, error) {
+			return s.GetReports(ctx, 1, false)
+		}},
+		{"parking lots", func(s *ParkingLotStorage) (interface{}, error) {
+			return s.ListParkingLots(ctx, 0)
+		}},
+		{"maintenance slots", func(s *ParkingLotStorage) (interface{}, error) {
+			return s.GetMaintenanceSlots(ctx, 1)
+		}},
+		{"rate history", func(s *ParkingLotStorage) (interface{}, error) {
+			return s.GetRateHistory(ctx, 1)
+		}},
+		{"slot state history", func(s *ParkingLotStorage) (interface{}, error) {
+			return s.GetSlotStateHistory(ctx, 1, 1, time.Time{}, time.Time{})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newFakeStorage(
+				lotResult(testLot()),
+				fakeResult{match: "SELECT id FROM parking_lots WHERE id = ANY($1)", rows: [][]driver.Value{{int64(1)}}},
+				fakeResult{match: "SELECT"},
+			)
+
+			list, err := tt.list(s)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			body, err := json.Marshal(list)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(body) != "[]" {
+				t.Errorf("empty list marshalled as %s, want []", body)
+			}
+		})
+	}
+}
+
+func TestGetReportsIncludeVoided(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	lot, err := s.CreateParkingLot(ctx, 1, LotOptions{})
+	if err != nil {
+		t.Fatalf("CreateParkingLot: %v", err)
+	}
+	exitTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	transactions := []struct {
+		fee    int
+		voided bool
+	}{{30, false}, {20, true}, {10, false}}
+	for i, transaction := range transactions {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO parking_transactions (lot_id, vehicle_license_plate, slot, fee, entry_time, exit_time, voided_at)
+			VALUES ($1, $2, 1, $3, $4, $5, $6)
+		`, lot.ID, fmt.Sprintf("VOID-%d", i), transaction.fee, exitTime.Add(-time.Hour), exitTime, sql.NullTime{Time: exitTime, Valid: transaction.voided})
+		if err != nil {
+			t.Fatalf("insert transaction: %v", err)
+		}
+	}
+
+	tests := []struct {
+		includeVoided bool
+		want          DailyStats
+	}{
+		{false, DailyStats{TotalVehicles: 2, TotalFee: 40}},
+		{true, DailyStats{TotalVehicles: 3, TotalFee: 60, VoidedVehicles: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("includeVoided=%v", tt.includeVoided), func(t *testing.T) {
+			reports, err := s.GetReports(ctx, lot.ID, tt.includeVoided)
+			if err != nil {
+				t.Fatalf("GetReports: %v", err)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("%d days reported, want 1", len(reports))
+			}
+			got := reports[0]
+			if got.TotalVehicles != tt.want.TotalVehicles || got.TotalFee != tt.want.TotalFee || got.VoidedVehicles != tt.want.VoidedVehicles {
+				t.Errorf("report = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportDaysInLotTimezone(t *testing.T) {
+	ctx := context.Background()
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	lot := testLot()
+	lot.Location = newYork
+	// The database hands dates back as midnight UTC.
+	march1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	s, db, _ := newFakeStorage(
+		lotResult(lot),
+		fakeResult{match: "FROM daily_stats", rows: [][]driver.Value{{march1, int64(2), 3.5, int64(40), int64(0), int64(0)}}},
+		fakeResult{match: "SELECT DATE(exit_time AT TIME ZONE 'UTC' AT TIME ZONE $4) AS day, COUNT(*)", rows: [][]driver.Value{{march1, int64(5)}}},
+		fakeResult{match: "FILTER (WHERE exit_time >= $2", rows: [][]driver.Value{{int64(0), int64(0)}}},
+	)
+
+	reports, err := s.GetReports(ctx, 1, false)
+	if err != nil {
+		t.Fatalf("GetReports: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Day.Format(time.RFC3339) != "2024-03-01T00:00:00-05:00" {
+		t.Errorf("reported days %+v, want local midnight of 2024-03-01", reports)
+	}
+	query, _ := db.last("FROM daily_stats")
+	if zone := query.args[2]; zone != "America/New_York" {
+		t.Errorf("days grouped in %v, want the lot's timezone", zone)
+	}
+
+	// 2024-03-01 03:00 to 2024-03-02 18:00 in New York.
+	turnover, err := s.GetTurnoverRate(ctx, 1, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 23, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetTurnoverRate: %v", err)
+	}
+	if len(turnover.Days) != 2 {
+		t.Fatalf("%d turnover days, want 2", len(turnover.Days))
+	}
+	if day := turnover.Days[0]; day.Day.Format(time.RFC3339) != "2024-03-01T00:00:00-05:00" || day.Transactions != 5 {
+		t.Errorf("first turnover day = %+v, want the 5 transactions of 2024-03-01 in New York", day)
+	}
+	if day := turnover.Days[1]; day.Day.Format(time.RFC3339) != "2024-03-02T00:00:00-05:00" || day.Transactions != 0 {
+		t.Errorf("second turnover day = %+v, want 2024-03-02 in New York without transactions", day)
+	}
+
+	// 10:00 UTC is 05:00 in New York, so the day began at 05:00 UTC.
+	trend, err := s.GetRevenueTrend(ctx, 1, "day")
+	if err != nil {
+		t.Fatalf("GetRevenueTrend: %v", err)
+	}
+	if trend.CurrentFrom.Format(time.RFC3339) != "2024-03-01T00:00:00-05:00" {
+		t.Errorf("current period from %v, want local midnight", trend.CurrentFrom)
+	}
+	query, _ = db.last("FILTER (WHERE exit_time >= $2")
+	if from := query.args[1].(time.Time); !from.Equal(time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC)) || from.Location() != time.UTC {
+		t.Errorf("current period queried from %v, want 05:00 UTC", from)
+	}
+}
+
+func TestReportsGroupByLocalDay(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	lot, err := s.CreateParkingLot(ctx, 1, LotOptions{})
+	if err != nil {
+		t.Fatalf("CreateParkingLot: %v", err)
+	}
+	if err := s.SetLotTimezone(ctx, lot.ID, "America/New_York"); err != nil {
+		t.Fatalf("SetLotTimezone: %v", err)
+	}
+	// 02:00 UTC on March 2 is still March 1 in New York.
+	exitTime := time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO parking_transactions (lot_id, vehicle_license_plate, slot, fee, entry_time, exit_time)
+		VALUES ($1, $2, 1, 10, $3, $4)
+	`, lot.ID, fmt.Sprintf("LOCAL-%d", lot.ID), exitTime.Add(-time.Hour), exitTime)
+	if err != nil {
+		t.Fatalf("insert transaction: %v", err)
+	}
+
+	reports, err := s.GetReports(ctx, lot.ID, false)
+	if err != nil {
+		t.Fatalf("GetReports: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Day.Format(time.RFC3339) != "2024-03-01T00:00:00-05:00" {
+		t.Errorf("reported days %+v, want 2024-03-01 in New York", reports)
+	}
+}
+
+func TestRevenueReportsLeaveOutVoidedTransactions(t *testing.T) {
+	reports := map[string]func(s *ParkingLotStorage, ctx context.Context) error{
+		"GetReportsForLots": func(s *ParkingLotStorage, ctx context.Context) error {
+			_, err := s.GetReportsForLots(ctx, []int{1}, time.Time{}, time.Time{})
+			return err
+		},
+		"GetReportsByVehicleType": func(s *ParkingLotStorage, ctx context.Context) error {
+			_, err := s.GetReportsByVehicleType(ctx, 1, time.Time{}, time.Time{})
+			return err
+		},
+		"GetRevenueBySlot": func(s *ParkingLotStorage, ctx context.Context) error {
+			_, err := s.GetRevenueBySlot(ctx, 1, time.Time{}, time.Time{})
+			return err
+		},
+		"GetDurationStatsByType": func(s *ParkingLotStorage, ctx context.Context) error {
+			_, err := s.GetDurationStatsByType(ctx, 1, time.Time{}, time.Time{})
+			return err
+		},
+		"GetKPIs": func(s *ParkingLotStorage, ctx context.Context) error {
+			_, err := s.GetKPIs(ctx)
+			return err
+		},
+	}
+
+	for name, report := range reports {
+		t.Run(name, func(t *testing.T) {
+			s, db, _ := newFakeStorage(
+				lotResult(testLot()),
+				fakeResult{match: "SELECT id FROM parking_lots", rows: [][]driver.Value{{int64(1)}}},
+				fakeResult{match: "COALESCE(SUM(occupied), 0)", rows: [][]driver.Value{{int64(0), 0.0}}},
+				fakeResult{match: "SELECT COUNT(*), COALESCE(SUM(fee), 0)", rows: [][]driver.Value{{int64(0), int64(0)}}},
+				fakeResult{match: "FROM parking_transactions"},
+			)
+
+			if err := report(s, context.Background()); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			statement, ok := db.last("FROM parking_transactions")
+			if !ok {
+				t.Fatalf("%s did not query the transactions", name)
+			}
+			if !strings.Contains(statement.query, "voided_at IS NULL") {
+				t.Errorf("%s counts voided transactions: %s", name, statement.query)
+			}
+		})
+	}
+}
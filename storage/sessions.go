@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ActiveSession is a vehicle parked in any lot right now, with the fee it has accrued.
+type ActiveSession struct {
+	ParkingLotID int       `json:"parkingLotID"`
+	SlotNumber   int       `json:"slotNumber"`
+	LicensePlate string    `json:"licensePlate"`
+	TicketID     string    `json:"ticketID,omitempty"`
+	EntryTime    time.Time `json:"entryTime"`
+	StayMinutes  int       `json:"stayMinutes"`
+	AccruedFee   int       `json:"accruedFee"`
+}
+
+// exportBatchSize is how many parked vehicles ExportActiveSessions reads at a time.
+const exportBatchSize = 500
+
+// ActiveSessionPage is one page of the active sessions and the number of active
+// sessions in total.
+type ActiveSessionPage struct {
+	Total    int              `json:"total"`
+	Sessions []*ActiveSession `json:"sessions"`
+}
+
+// GetAllActiveSessions returns one page of the vehicles parked across all lots, earliest
+// entry first. Vehicles whose exit is pending accrue fees until they were unparked, and
+// employees accrue nothing.
+func (s *ParkingLotStorage) GetAllActiveSessions(ctx context.Context, limit, offset int) (*ActiveSessionPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if limit < 0 || offset < 0 {
+		return nil, invalidRequest("limit and offset must not be negative")
+	}
+	if limit == 0 {
+		limit = defaultTransactionPageSize
+	}
+	if limit > maxTransactionPageSize {
+		limit = maxTransactionPageSize
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+activeSessionColumns+`, COUNT(*) OVER ()
+		FROM parked_vehicles
+		WHERE parked_vehicles.exit_time IS NULL
+		ORDER BY parked_vehicles.entry_time, parked_vehicles.id
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, internalError("failed to retrieve active sessions")
+	}
+
+	page := &ActiveSessionPage{}
+	page.Sessions, _, err = s.readActiveSessions(ctx, rows, &page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// activeSessionColumns are the columns of parked_vehicles read by readActiveSessions.
+const activeSessionColumns = `parked_vehicles.id, parked_vehicles.parking_lot_id, parked_vehicles.slot, parked_vehicles.slots_required,
+	parked_vehicles.license_plate, parked_vehicles.ticket_id, parked_vehicles.entry_time, parked_vehicles.pending_exit_at,
+	EXISTS (
+		SELECT 1 FROM employee_plates
+		WHERE employee_plates.lot_id = parked_vehicles.parking_lot_id AND employee_plates.license_plate = parked_vehicles.license_plate
+	)`
+
+// sessionCursor is the position of the last session read, ordered by entry time and ID.
+// The entry time is kept as stored rather than in the lot's timezone, since entry_time
+// has no time zone and comparing it with a zoned time would shift the position.
+type sessionCursor struct {
+	entryTime time.Time
+	id        int
+}
+
+// readActiveSessions reads the parked vehicles of rows, which selects activeSessionColumns
+// followed by the columns scanned into extra, and charges each the fee it has accrued.
+// Vehicles whose exit is pending accrue fees until they were unparked, and employees
+// accrue nothing. The fee inputs of each lot are read once for all of its vehicles.
+// It returns the sessions and the position of the last one, and closes rows.
+func (s *ParkingLotStorage) readActiveSessions(ctx context.Context, rows *sql.Rows, extra ...interface{}) ([]*ActiveSession, sessionCursor, error) {
+	type activeStay struct {
+		session       *ActiveSession
+		slotsRequired int
+		accruedUntil  time.Time
+		employee      bool
+	}
+
+	var stays []activeStay
+	var last sessionCursor
+	now := s.now()
+	for rows.Next() {
+		var session ActiveSession
+		var stay activeStay
+		var ticketID sql.NullString
+		var pendingExitAt sql.NullTime
+		dest := append([]interface{}{&last.id, &session.ParkingLotID, &session.SlotNumber, &stay.slotsRequired, &session.LicensePlate,
+			&ticketID, &session.EntryTime, &pendingExitAt, &stay.employee}, extra...)
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, sessionCursor{}, internalError("failed to read active sessions")
+		}
+		session.TicketID = ticketID.String
+		last.entryTime = session.EntryTime
+		stay.session = &session
+		stay.accruedUntil = now
+		if pendingExitAt.Valid {
+			stay.accruedUntil = pendingExitAt.Time
+		}
+		session.StayMinutes = int(stay.accruedUntil.Sub(session.EntryTime).Minutes())
+		stays = append(stays, stay)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, sessionCursor{}, internalError("error processing active sessions")
+	}
+
+	// Each lot's fee inputs cover the period from its earliest entry to its latest exit.
+	periods := make(map[int]*timeWindow)
+	for _, stay := range stays {
+		if stay.employee {
+			continue
+		}
+		period, ok := periods[stay.session.ParkingLotID]
+		if !ok {
+			periods[stay.session.ParkingLotID] = &timeWindow{Start: stay.session.EntryTime, End: stay.accruedUntil}
+			continue
+		}
+		if stay.session.EntryTime.Before(period.Start) {
+			period.Start = stay.session.EntryTime
+		}
+		if stay.accruedUntil.After(period.End) {
+			period.End = stay.accruedUntil
+		}
+	}
+
+	inputs := make(map[int]*feeInputs, len(periods))
+	sessions := make([]*ActiveSession, 0, len(stays))
+	for _, stay := range stays {
+		sessions = append(sessions, stay.session)
+		if stay.employee {
+			continue
+		}
+		lotInputs, ok := inputs[stay.session.ParkingLotID]
+		if !ok {
+			period := periods[stay.session.ParkingLotID]
+			var err error
+			lotInputs, err = s.loadFeeInputs(ctx, s.db, stay.session.ParkingLotID, period.Start, period.End)
+			if err != nil {
+				return nil, sessionCursor{}, err
+			}
+			inputs[stay.session.ParkingLotID] = lotInputs
+		}
+		fee, err := lotInputs.fee(ctx, stay.session.SlotNumber, stay.slotsRequired, stay.session.EntryTime, stay.accruedUntil)
+		if err != nil {
+			return nil, sessionCursor{}, err
+		}
+		stay.session.AccruedFee = fee.Fee
+	}
+	for _, session := range sessions {
+		if err := s.inZoneOfLot(ctx, session.ParkingLotID, &session.EntryTime); err != nil {
+			return nil, sessionCursor{}, err
+		}
+	}
+
+	return sessions, last, nil
+}
+
+// ExportActiveSessions passes each vehicle parked in the specified lot to write, earliest
+// entry first. The vehicles are read in batches so that large lots are not held in memory,
+// and the lot is only locked while a batch is read, not while it is written.
+func (s *ParkingLotStorage) ExportActiveSessions(ctx context.Context, parkingLotID int, write func(*ActiveSession) error) error {
+	s.mu.RLock()
+	err := s.checkLotsExist(ctx, []int{parkingLotID})
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var after sessionCursor
+	for {
+		sessions, last, err := s.activeSessionBatch(ctx, parkingLotID, after)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if err := write(session); err != nil {
+				return err
+			}
+		}
+		if len(sessions) < exportBatchSize {
+			return nil
+		}
+		after = last
+	}
+}
+
+// activeSessionBatch returns up to exportBatchSize vehicles parked in the specified lot
+// after the position after, and the position of the last one.
+func (s *ParkingLotStorage) activeSessionBatch(ctx context.Context, parkingLotID int, after sessionCursor) ([]*ActiveSession, sessionCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+activeSessionColumns+`
+		FROM parked_vehicles
+		WHERE parked_vehicles.parking_lot_id = $1 AND parked_vehicles.exit_time IS NULL
+		AND (parked_vehicles.entry_time, parked_vehicles.id) > ($2, $3)
+		ORDER BY parked_vehicles.entry_time, parked_vehicles.id
+		LIMIT $4
+	`, parkingLotID, after.entryTime, after.id, exportBatchSize)
+	if err != nil {
+		return nil, sessionCursor{}, internalError("failed to retrieve active sessions")
+	}
+
+	return s.readActiveSessions(ctx, rows)
+}
+
+// RevenueRunRate is what the vehicles parked in a lot right now earn per hour on average.
+type RevenueRunRate struct {
+	ParkingLotID   int     `json:"parkingLotID"`
+	ParkedVehicles int     `json:"parkedVehicles"`
+	AccruedFee     int     `json:"accruedFee"`
+	ParkedHours    float64 `json:"parkedHours"`
+	RatePerHour    float64 `json:"ratePerHour"`
+}
+
+// GetRevenueRunRate divides the fees accrued by the vehicles parked in the specified lot
+// by the hours they have been parked. The rate is zero when the lot is empty.
+func (s *ParkingLotStorage) GetRevenueRunRate(ctx context.Context, parkingLotID int) (*RevenueRunRate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkLotsExist(ctx, []int{parkingLotID}); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT parked_vehicles.slot, parked_vehicles.slots_required, parked_vehicles.entry_time, parked_vehicles.pending_exit_at,
+			EXISTS (
+				SELECT 1 FROM employee_plates
+				WHERE employee_plates.lot_id = parked_vehicles.parking_lot_id AND employee_plates.license_plate = parked_vehicles.license_plate
+			)
+		FROM parked_vehicles
+		WHERE parked_vehicles.parking_lot_id = $1 AND parked_vehicles.exit_time IS NULL
+	`, parkingLotID)
+	if err != nil {
+		return nil, internalError("failed to retrieve parked vehicles")
+	}
+
+	type parkedVehicle struct {
+		slot, slotsRequired int
+		entryTime, until    time.Time
+		employee            bool
+	}
+
+	var vehicles []parkedVehicle
+	now := s.now()
+	for rows.Next() {
+		var vehicle parkedVehicle
+		var pendingExitAt sql.NullTime
+		if err := rows.Scan(&vehicle.slot, &vehicle.slotsRequired, &vehicle.entryTime, &pendingExitAt, &vehicle.employee); err != nil {
+			rows.Close()
+			return nil, internalError("failed to read parked vehicles")
+		}
+		vehicle.until = now
+		if pendingExitAt.Valid {
+			vehicle.until = pendingExitAt.Time
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing parked vehicles")
+	}
+
+	runRate := &RevenueRunRate{ParkingLotID: parkingLotID, ParkedVehicles: len(vehicles)}
+	for _, vehicle := range vehicles {
+		runRate.ParkedHours += vehicle.until.Sub(vehicle.entryTime).Hours()
+		if vehicle.employee {
+			continue
+		}
+		fee, err := s.parkingFee(ctx, s.db, parkingLotID, vehicle.slot, vehicle.slotsRequired, vehicle.entryTime, vehicle.until)
+		if err != nil {
+			return nil, err
+		}
+		runRate.AccruedFee += fee.Fee
+	}
+
+	if runRate.ParkedHours > 0 {
+		runRate.RatePerHour = float64(runRate.AccruedFee) / runRate.ParkedHours
+	}
+
+	return runRate, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// activeSessionRow is a one-slot stay as read by readActiveSessions.
+func activeSessionRow(id, parkingLotID, slot int, entryTime time.Time, pendingExitAt driver.Value, employee bool) []driver.Value {
+	return []driver.Value{int64(id), int64(parkingLotID), int64(slot), int64(1), "ABC123", nil, entryTime, pendingExitAt, employee}
+}
+
+func TestGetAllActiveSessionsReadsFeeInputsOncePerLot(t *testing.T) {
+	graced := true
+	lot := testLot()
+	lot.MaintenanceGrace = &graced
+	s, db, clock := newFakeStorage(
+		fakeResult{match: "FROM parked_vehicles", rows: [][]driver.Value{
+			append(activeSessionRow(1, 1, 1, at(0), nil, false), int64(4)),
+			append(activeSessionRow(2, 1, 2, at(time.Hour), nil, false), int64(4)),
+			append(activeSessionRow(3, 2, 1, at(2*time.Hour), nil, true), int64(4)),
+			append(activeSessionRow(4, 2, 3, at(30*time.Minute), at(90*time.Minute), false), int64(4)),
+		}},
+		lotResult(lot),
+		fakeResult{match: "FROM fee_strategy_changes"},
+		// Slot 2 of every lot was out of service for an hour.
+		fakeResult{match: "FROM maintenance_windows", rows: [][]driver.Value{{int64(2), at(2 * time.Hour), at(3 * time.Hour)}}},
+	)
+	clock.Advance(4 * time.Hour)
+
+	page, err := s.GetAllActiveSessions(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("GetAllActiveSessions: %v", err)
+	}
+	if page.Total != 4 {
+		t.Errorf("total = %d, want 4", page.Total)
+	}
+
+	// The stay in slot 2 is not charged for the maintenance, the employee is not charged
+	// at all, and the pending exit is charged until the vehicle was unparked.
+	want := []int{40, 20, 0, 10}
+	if len(page.Sessions) != len(want) {
+		t.Fatalf("%d sessions, want %d", len(page.Sessions), len(want))
+	}
+	for i, session := range page.Sessions {
+		if session.AccruedFee != want[i] {
+			t.Errorf("session %d accrued %d, want %d", i, session.AccruedFee, want[i])
+		}
+	}
+
+	for _, query := range []string{lotMetaQuery, "FROM fee_strategy_changes", "FROM maintenance_windows"} {
+		if n := db.count(query); n != 2 {
+			t.Errorf("%q queried %d times for two lots, want 2", query, n)
+		}
+	}
+	changes, _ := db.last("FROM fee_strategy_changes")
+	if from, to := changes.args[1].(time.Time), changes.args[2].(time.Time); !from.Equal(at(30*time.Minute)) || !to.Equal(at(90*time.Minute)) {
+		t.Errorf("fee inputs of lot 2 read for %v to %v, want the charged stay only", from, to)
+	}
+}
+
+func TestExportActiveSessionsReadsFeeInputsOncePerBatch(t *testing.T) {
+	s, db, clock := newFakeStorage(
+		fakeResult{match: "SELECT id FROM parking_lots", rows: [][]driver.Value{{int64(1)}}},
+		fakeResult{match: "FROM parked_vehicles", rows: [][]driver.Value{
+			activeSessionRow(1, 1, 1, at(0), nil, false),
+			activeSessionRow(2, 1, 2, at(time.Hour), nil, false),
+			activeSessionRow(3, 1, 3, at(2*time.Hour), nil, true),
+		}},
+		lotResult(testLot()),
+		fakeResult{match: "FROM fee_strategy_changes"},
+	)
+	clock.Advance(4 * time.Hour)
+
+	var fees []int
+	err := s.ExportActiveSessions(context.Background(), 1, func(session *ActiveSession) error {
+		fees = append(fees, session.AccruedFee)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportActiveSessions: %v", err)
+	}
+
+	want := []int{40, 30, 0}
+	if len(fees) != len(want) {
+		t.Fatalf("exported fees %v, want %v", fees, want)
+	}
+	for i := range want {
+		if fees[i] != want[i] {
+			t.Errorf("exported fees %v, want %v", fees, want)
+			break
+		}
+	}
+	if n := db.count("FROM fee_strategy_changes"); n != 1 {
+		t.Errorf("fee strategy changes queried %d times for one batch, want 1", n)
+	}
+}
+
+func TestExportActiveSessionsPagesByStoredEntryTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	lot := testLot()
+	lot.Location = newYork
+
+	// A full batch, then the rest of the lot.
+	var first, rest [][]driver.Value
+	for i := 0; i < exportBatchSize; i++ {
+		first = append(first, activeSessionRow(i+1, 1, i+1, at(time.Duration(i)*time.Second), nil, true))
+	}
+	for i := exportBatchSize; i < exportBatchSize+3; i++ {
+		rest = append(rest, activeSessionRow(i+1, 1, i+1, at(time.Duration(i)*time.Second), nil, true))
+	}
+	s, db, _ := newFakeStorage(
+		fakeResult{match: "SELECT id FROM parking_lots", rows: [][]driver.Value{{int64(1)}}},
+		fakeResult{match: "FROM parked_vehicles", rows: first, times: 1},
+		fakeResult{match: "FROM parked_vehicles", rows: rest, times: 1},
+		lotResult(lot),
+	)
+
+	var entries []time.Time
+	err = s.ExportActiveSessions(context.Background(), 1, func(session *ActiveSession) error {
+		entries = append(entries, session.EntryTime)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportActiveSessions: %v", err)
+	}
+	if len(entries) != exportBatchSize+3 {
+		t.Fatalf("exported %d sessions, want %d", len(entries), exportBatchSize+3)
+	}
+	if entries[0].Location().String() != newYork.String() {
+		t.Errorf("exported entry time %v, want it in the lot's timezone", entries[0])
+	}
+
+	// entry_time has no time zone, so the cursor must be the stored UTC entry time
+	// rather than the converted one.
+	batch, _ := db.last("FROM parked_vehicles")
+	want := at(time.Duration(exportBatchSize-1) * time.Second)
+	if after := batch.args[1].(time.Time); !after.Equal(want) || after.Location() != time.UTC {
+		t.Errorf("second batch read after entry time %v, want %v", after, want)
+	}
+	if after := batch.args[2]; after != int64(exportBatchSize) {
+		t.Errorf("second batch read after ID %v, want %d", after, exportBatchSize)
+	}
+}
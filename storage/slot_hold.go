@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// HoldTTL is how long an unconfirmed slot hold stays valid.
+const HoldTTL = 2 * time.Minute
+
+// ConfirmedHoldTTL is how long a slot hold stays valid once confirmed, so a paid hold
+// whose vehicle never arrives does not block its slot forever.
+const ConfirmedHoldTTL = 30 * time.Minute
+
+// SlotHold represents a parking space held for a vehicle that has not parked yet.
+type SlotHold struct {
+	Token        string    `json:"token"`
+	ParkingLotID int       `json:"parkingLotID"`
+	SlotNumber   int       `json:"slotNumber"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// HoldSlot reserves the nearest available slot for the given vehicle type for HoldTTL.
+func (s *ParkingLotStorage) HoldSlot(ctx context.Context, parkingLotID int, vehicleType string) (*SlotHold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vehicleType, err := s.lotVehicleType(ctx, parkingLotID, vehicleType)
+	if err != nil {
+		return nil, err
+	}
+
+	slotNumber, err := s.nextFreeBlock(ctx, s.db, parkingLotID, vehicleType, 1, 0, "")
+	if err != nil {
+		return nil, ErrLotFull
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, internalError("failed to generate hold token")
+	}
+
+	hold := &SlotHold{Token: token, ParkingLotID: parkingLotID, SlotNumber: slotNumber}
+	now := s.now()
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO slot_holds (token, lot_id, slot, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING expires_at
+	`, token, parkingLotID, slotNumber, now.Add(HoldTTL), now).Scan(&hold.ExpiresAt)
+	if err != nil {
+		return nil, internalError("failed to hold parking space")
+	}
+	if err := s.inZoneOfLot(ctx, parkingLotID, &hold.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// ConfirmHold marks a hold as paid, extending it to ConfirmedHoldTTL from now. Confirming
+// a hold again does not extend it further.
+func (s *ParkingLotStorage) ConfirmHold(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE slot_holds
+		SET confirmed = true, expires_at = CASE WHEN confirmed THEN expires_at ELSE $3 END
+		WHERE token = $1 AND NOT released AND expires_at > $2
+	`, token, now, now.Add(ConfirmedHoldTTL))
+	if err != nil {
+		return internalError("failed to confirm hold")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrHoldNotFound
+	}
+
+	return nil
+}
+
+// ReleaseHold gives a held slot back to the lot.
+func (s *ParkingLotStorage) ReleaseHold(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE slot_holds SET released = true WHERE token = $1 AND NOT released", token)
+	if err != nil {
+		return internalError("failed to release hold")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrHoldNotFound
+	}
+
+	return nil
+}
+
+// StartHoldSweeper periodically releases holds whose TTL has passed and
+// block reservations whose window has ended, and confirms timed out pending exits,
+// until ctx is cancelled.
+func (s *ParkingLotStorage) StartHoldSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			s.mu.Lock()
+			res, err := s.db.ExecContext(ctx, `
+				UPDATE slot_holds
+				SET released = true
+				WHERE NOT released AND expires_at <= $1
+			`, s.now())
+			var reservations, exits int64
+			if err == nil {
+				reservations, err = s.releaseEndedReservations(ctx)
+			}
+			if err == nil {
+				exits, err = s.confirmTimedOutExits(ctx)
+			}
+			s.mu.Unlock()
+			if err != nil {
+				log.Println("failed to sweep expired holds:", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				log.Println("released expired holds:", n)
+			}
+			if reservations > 0 {
+				log.Println("released ended reservations:", reservations)
+			}
+			if exits > 0 {
+				log.Println("confirmed timed out exits:", exits)
+			}
+		}
+	}()
+}
+
+// claimHold consumes an active hold in the given lot and returns the held slot number.
+// When reservations come first, a hold on a slot in an active block reservation cannot
+// be claimed. The caller must hold s.mu.
+func (s *ParkingLotStorage) claimHold(ctx context.Context, q querier, parkingLotID int, token string) (int, error) {
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return 0, err
+	}
+
+	var slotNumber int
+	err = q.QueryRowContext(ctx, `
+		UPDATE slot_holds
+		SET released = true
+		FROM parking_spaces
+		WHERE slot_holds.token = $1 AND slot_holds.lot_id = $2
+		AND NOT slot_holds.released AND slot_holds.expires_at > $3
+		AND parking_spaces.lot_id = slot_holds.lot_id AND parking_spaces.number = slot_holds.slot
+		AND NOT parking_spaces.occupied
+		AND (NOT $4 OR `+notReserved("$3", "0")+`)
+		RETURNING slot_holds.slot
+	`, token, parkingLotID, s.now(), lot.SlotPriority == PriorityReservationsFirst).Scan(&slotNumber)
+	if err != nil {
+		return 0, ErrHoldNotFound
+	}
+
+	return slotNumber, nil
+}
+
+// notHeld returns a condition on parking_spaces excluding spaces with an active hold,
+// where nowParam is the placeholder bound to the current time.
+func notHeld(nowParam string) string {
+	return `NOT EXISTS (
+		SELECT 1 FROM slot_holds
+		WHERE slot_holds.lot_id = parking_spaces.lot_id AND slot_holds.slot = parking_spaces.number
+		AND NOT slot_holds.released AND slot_holds.expires_at > ` + nowParam + `
+	)`
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
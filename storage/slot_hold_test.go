@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmHoldExtendsExpiry(t *testing.T) {
+	s, db, _ := newFakeStorage(fakeResult{match: "UPDATE slot_holds", rowsAffected: 1})
+
+	if err := s.ConfirmHold(context.Background(), "token"); err != nil {
+		t.Fatalf("ConfirmHold: %v", err)
+	}
+	statement, _ := db.last("UPDATE slot_holds")
+	if expiresAt := statement.args[2]; expiresAt != testTime.Add(ConfirmedHoldTTL) {
+		t.Errorf("confirmed hold expires at %v, want %v", expiresAt, testTime.Add(ConfirmedHoldTTL))
+	}
+	if strings.Contains(notHeld("$1"), "confirmed") {
+		t.Errorf("confirmed holds block their slot regardless of expiry: %s", notHeld("$1"))
+	}
+}
+
+func TestStaleConfirmedHoldStopsBlockingSlot(t *testing.T) {
+	s := newIntegrationStorage(t)
+	clock := newFakeClock(time.Now())
+	s.clock = clock
+	ctx := context.Background()
+
+	lot, err := s.CreateParkingLot(ctx, 1, LotOptions{})
+	if err != nil {
+		t.Fatalf("CreateParkingLot: %v", err)
+	}
+	hold, err := s.HoldSlot(ctx, lot.ID, DefaultVehicleType)
+	if err != nil {
+		t.Fatalf("HoldSlot: %v", err)
+	}
+	if err := s.ConfirmHold(ctx, hold.Token); err != nil {
+		t.Fatalf("ConfirmHold: %v", err)
+	}
+
+	// A confirmed hold outlives the unconfirmed TTL.
+	clock.Advance(2 * HoldTTL)
+	if _, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("EARLY-%d", lot.ID), ParkOptions{}); !errors.Is(err, ErrLotFull) {
+		t.Errorf("walk-in error while the hold is confirmed = %v, want %v", err, ErrLotFull)
+	}
+
+	clock.Advance(ConfirmedHoldTTL)
+	if err := s.ConfirmHold(ctx, hold.Token); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("ConfirmHold after expiry error = %v, want %v", err, ErrHoldNotFound)
+	}
+	ticket, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("LATE-%d", lot.ID), ParkOptions{})
+	if err != nil {
+		t.Fatalf("walk-in after the confirmed hold expired: %v", err)
+	}
+	if ticket.SlotNumber != hold.SlotNumber {
+		t.Errorf("walk-in parked in slot %d, want the formerly held slot %d", ticket.SlotNumber, hold.SlotNumber)
+	}
+}
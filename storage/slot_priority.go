@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+)
+
+// Slot priorities decide whether a block reservation or a hold gets a slot both claim.
+//
+// Slots are resolved in this order whatever the priority:
+//  1. A vehicle parking under an active block reservation gets one of its slots.
+//  2. A vehicle presenting a hold token gets the held slot.
+//  3. A walk-in gets the nearest slot that is neither held nor in an active reservation,
+//     and is turned away when only such slots are left.
+//
+// With PriorityReservationsFirst a new reservation may take slots that are held, and a
+// hold on a slot whose reservation window has started can no longer be claimed. With
+// PriorityHoldsFirst reservations skip slots held when they are made.
+const (
+	PriorityReservationsFirst = "reservations-first"
+	PriorityHoldsFirst        = "holds-first"
+)
+
+func validSlotPriority(priority string) bool {
+	return priority == PriorityReservationsFirst || priority == PriorityHoldsFirst
+}
+
+// SetSlotPriority changes whether block reservations or holds win a contested slot in
+// the specified parking lot.
+func (s *ParkingLotStorage) SetSlotPriority(ctx context.Context, parkingLotID int, priority string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validSlotPriority(priority) {
+		return invalidRequest("unknown slot priority %q", priority)
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE parking_lots SET slot_priority = $2 WHERE id = $1", parkingLotID, priority)
+	if err != nil {
+		return internalError("failed to set slot priority")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLotNotFound
+	}
+	s.lots.invalidate(parkingLotID)
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSetSlotPriority(t *testing.T) {
+	ctx := context.Background()
+	s, db, _ := newFakeStorage(lotResult(testLot()), fakeResult{match: "SET slot_priority", rowsAffected: 1})
+
+	var parkingErr *ParkingError
+	if err := s.SetSlotPriority(ctx, 1, "first-come"); !errors.As(err, &parkingErr) || parkingErr.Code != CodeInvalidRequest {
+		t.Errorf("SetSlotPriority with an unknown priority error = %v, want an invalid request", err)
+	}
+	if n := db.count("SET slot_priority"); n != 0 {
+		t.Errorf("unknown priority written to the database")
+	}
+
+	if _, err := s.lotMeta(ctx, 1); err != nil {
+		t.Fatalf("lotMeta: %v", err)
+	}
+	if err := s.SetSlotPriority(ctx, 1, PriorityHoldsFirst); err != nil {
+		t.Fatalf("SetSlotPriority: %v", err)
+	}
+	if _, err := s.lotMeta(ctx, 1); err != nil {
+		t.Fatalf("lotMeta: %v", err)
+	}
+	if n := db.count(lotMetaQuery); n != 2 {
+		t.Errorf("lot metadata queried %d times, want it reloaded after the priority changed", n)
+	}
+}
+
+func TestReservationAndWalkInContention(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	lot, err := s.CreateParkingLot(ctx, 2, LotOptions{})
+	if err != nil {
+		t.Fatalf("CreateParkingLot: %v", err)
+	}
+	now := time.Now()
+	reservation, err := s.ReserveBlock(ctx, lot.ID, 1, now.Add(-time.Minute), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ReserveBlock: %v", err)
+	}
+	if len(reservation.SlotNumbers) != 1 || reservation.SlotNumbers[0] != 1 {
+		t.Fatalf("reserved slots %v, want [1]", reservation.SlotNumbers)
+	}
+
+	ticket, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("WALK-%d", lot.ID), ParkOptions{})
+	if err != nil {
+		t.Fatalf("ParkVehicle: %v", err)
+	}
+	if ticket.SlotNumber != 2 {
+		t.Errorf("walk-in parked in slot %d, want the unreserved slot 2", ticket.SlotNumber)
+	}
+	if _, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("LATE-%d", lot.ID), ParkOptions{}); !errors.Is(err, ErrLotFull) {
+		t.Errorf("walk-in with only the reserved slot left error = %v, want %v", err, ErrLotFull)
+	}
+
+	ticket, err = s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("RSVD-%d", lot.ID), ParkOptions{ReservationID: reservation.ID})
+	if err != nil {
+		t.Fatalf("ParkVehicle under the reservation: %v", err)
+	}
+	if ticket.SlotNumber != 1 {
+		t.Errorf("reservation holder parked in slot %d, want the reserved slot 1", ticket.SlotNumber)
+	}
+}
+
+func TestReservationAndHoldContention(t *testing.T) {
+	tests := []struct {
+		priority     string
+		wantReserved int
+	}{
+		{PriorityReservationsFirst, 1},
+		{PriorityHoldsFirst, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.priority, func(t *testing.T) {
+			s := newIntegrationStorage(t)
+			ctx := context.Background()
+
+			lot, err := s.CreateParkingLot(ctx, 2, LotOptions{})
+			if err != nil {
+				t.Fatalf("CreateParkingLot: %v", err)
+			}
+			if err := s.SetSlotPriority(ctx, lot.ID, tt.priority); err != nil {
+				t.Fatalf("SetSlotPriority: %v", err)
+			}
+			hold, err := s.HoldSlot(ctx, lot.ID, DefaultVehicleType)
+			if err != nil {
+				t.Fatalf("HoldSlot: %v", err)
+			}
+			if hold.SlotNumber != 1 {
+				t.Fatalf("held slot %d, want 1", hold.SlotNumber)
+			}
+
+			now := time.Now()
+			reservation, err := s.ReserveBlock(ctx, lot.ID, 1, now.Add(-time.Minute), now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("ReserveBlock: %v", err)
+			}
+			if len(reservation.SlotNumbers) != 1 || reservation.SlotNumbers[0] != tt.wantReserved {
+				t.Errorf("reserved slots %v, want [%d]", reservation.SlotNumbers, tt.wantReserved)
+			}
+
+			// Both slots are either held or reserved, so walk-ins are turned away.
+			if _, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("WALK-%d", lot.ID), ParkOptions{}); !errors.Is(err, ErrLotFull) {
+				t.Errorf("walk-in error = %v, want %v", err, ErrLotFull)
+			}
+
+			if tt.priority == PriorityHoldsFirst {
+				ticket, err := s.ParkVehicle(ctx, lot.ID, fmt.Sprintf("HOLD-%d", lot.ID), ParkOptions{HoldToken: hold.Token})
+				if err != nil {
+					t.Fatalf("ParkVehicle with the hold: %v", err)
+				}
+				if ticket.SlotNumber != hold.SlotNumber {
+					t.Errorf("hold holder parked in slot %d, want the held slot %d", ticket.SlotNumber, hold.SlotNumber)
+				}
+			}
+		})
+	}
+}
+
+func TestHeldSlotContentionFollowsPriority(t *testing.T) {
+	for _, priority := range []string{PriorityReservationsFirst, PriorityHoldsFirst} {
+		t.Run(priority, func(t *testing.T) {
+			ctx := context.Background()
+			lot := testLot()
+			lot.SlotPriority = priority
+			s, db, _ := newFakeStorage(append([]fakeResult{
+				lotResult(lot),
+				// Both contenders lose the slot: the reservation finds none to take and
+				// the hold can no longer be claimed.
+				{match: "SELECT COALESCE(array_agg(number ORDER BY number), '{}')", rows: [][]driver.Value{{"{}"}}},
+				{match: "UPDATE slot_holds"},
+			}, parkResults()...)...)
+
+			now := testTime
+			if _, err := s.ReserveBlock(ctx, 1, 1, now, now.Add(time.Hour)); !errors.Is(err, ErrLotFull) {
+				t.Errorf("ReserveBlock error = %v, want %v", err, ErrLotFull)
+			}
+			reserve, _ := db.last("SELECT COALESCE(array_agg")
+			if skipsHeld := reserve.args[6]; skipsHeld != (priority == PriorityHoldsFirst) {
+				t.Errorf("reservation skips held slots = %v under %s", skipsHeld, priority)
+			}
+
+			if _, err := s.ParkVehicle(ctx, 1, "ABC123", ParkOptions{HoldToken: "token"}); !errors.Is(err, ErrHoldNotFound) {
+				t.Errorf("ParkVehicle with the hold error = %v, want %v", err, ErrHoldNotFound)
+			}
+			claim, _ := db.last("UPDATE slot_holds")
+			if yieldsToReservations := claim.args[3]; yieldsToReservations != (priority == PriorityReservationsFirst) {
+				t.Errorf("hold yields to reservations = %v under %s", yieldsToReservations, priority)
+			}
+			if n := db.count("SET occupied = true"); n != 0 {
+				t.Errorf("slot occupied %d times with an unclaimable hold", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// States a slot can be in. A slot in maintenance is in maintenance whether or not a
+// vehicle is still in it.
+const (
+	SlotFree        = "free"
+	SlotOccupied    = "occupied"
+	SlotMaintenance = "maintenance"
+)
+
+// Actions that change the state of a slot.
+const (
+	SlotActionPark             = "park"
+	SlotActionUnpark           = "unpark"
+	SlotActionAbandonedUnpark  = "abandoned_unpark"
+	SlotActionMaintenanceStart = "maintenance_start"
+	SlotActionMaintenanceEnd   = "maintenance_end"
+	SlotActionMaintenanceClear = "maintenance_clear"
+	SlotActionOrphanRepair     = "orphan_repair"
+	SlotActionDuplicateRepair  = "duplicate_repair"
+)
+
+// SlotStateChange is a slot entering State at Time because of Action.
+type SlotStateChange struct {
+	Time   time.Time `json:"time"`
+	State  string    `json:"state"`
+	Action string    `json:"action"`
+}
+
+// GetSlotStateHistory returns the state changes of a slot between from and to, oldest
+// first. Zero times leave the period open on that side.
+func (s *ParkingLotStorage) GetSlotStateHistory(ctx context.Context, parkingLotID, slotNumber int, from, to time.Time) ([]*SlotStateChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return nil, invalidRequest("to must be after from")
+	}
+	lot, err := s.lotMeta(ctx, parkingLotID)
+	if err != nil {
+		return nil, err
+	}
+	if slotNumber < 1 || slotNumber > lot.TotalSpaces {
+		return nil, invalidRequest("slot %d is not in the lot", slotNumber)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT changed_at, state, action
+		FROM slot_state_changes
+		WHERE lot_id = $1 AND slot = $2
+		AND ($3::timestamp IS NULL OR changed_at >= $3)
+		AND ($4::timestamp IS NULL OR changed_at < $4)
+		ORDER BY changed_at, id
+	`, parkingLotID, slotNumber, nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, internalError("failed to retrieve slot state history")
+	}
+	defer rows.Close()
+
+	history := []*SlotStateChange{}
+	for rows.Next() {
+		var change SlotStateChange
+		if err := rows.Scan(&change.Time, &change.State, &change.Action); err != nil {
+			return nil, internalError("failed to read slot state history")
+		}
+		inLotZone(lot, &change.Time)
+		history = append(history, &change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing slot state history")
+	}
+
+	return history, nil
+}
+
+// recordSlotStates logs the current state of the given slots as changed by action at
+// the given time. It must run in the transaction that changed them.
+func recordSlotStates(ctx context.Context, q querier, parkingLotID int, slots []int, action string, at time.Time) error {
+	if len(slots) == 0 {
+		return nil
+	}
+
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO slot_state_changes (lot_id, slot, state, action, changed_at)
+		SELECT lot_id, number,
+			CASE WHEN in_maintenance THEN $3 WHEN occupied THEN $4 ELSE $5 END,
+			$6, $7
+		FROM parking_spaces
+		WHERE lot_id = $1 AND number = ANY($2)
+	`, parkingLotID, pq.Array(slots), SlotMaintenance, SlotOccupied, SlotFree, action, at)
+	return err
+}
+
+// slotRange returns the slot numbers of a block of count slots starting at first.
+func slotRange(first, count int) []int {
+	slots := make([]int, count)
+	for i := range slots {
+		slots[i] = first + i
+	}
+	return slots
+}
+
+// scanSlotNumbers reads and closes rows of slot numbers.
+func scanSlotNumbers(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+
+	var slots []int
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		slots = append(slots, number)
+	}
+
+	return slots, rows.Err()
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrStatementTimeout is returned when the database work of a call takes longer than
+// its statement timeout.
+var ErrStatementTimeout = &ParkingError{Code: CodeStatementTimeout, Status: http.StatusGatewayTimeout, Message: "database statement timed out"}
+
+// SetStatementTimeouts bounds the database work of report queries by read and of parking
+// and unparking by write, independently of the request timeout. Zero leaves it unbounded.
+func (s *ParkingLotStorage) SetStatementTimeouts(read, write time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readTimeout = read
+	s.writeTimeout = write
+}
+
+// withReadTimeout bounds ctx by the read statement timeout. The returned function must be
+// deferred with the caller's error, which it replaces with ErrStatementTimeout when the
+// timeout was hit.
+func (s *ParkingLotStorage) withReadTimeout(ctx context.Context) (context.Context, func(*error)) {
+	return withStatementTimeout(ctx, s.readTimeout)
+}
+
+// withWriteTimeout is withReadTimeout for the write statement timeout.
+func (s *ParkingLotStorage) withWriteTimeout(ctx context.Context) (context.Context, func(*error)) {
+	return withStatementTimeout(ctx, s.writeTimeout)
+}
+
+func withStatementTimeout(parent context.Context, timeout time.Duration) (context.Context, func(*error)) {
+	if timeout <= 0 {
+		return parent, func(*error) {}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return ctx, func(err *error) {
+		cancel()
+		// Failed statements are reported as internal errors, so the context tells whether
+		// it was this timeout, rather than the request's, that cut them short.
+		if *err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			*err = ErrStatementTimeout
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	ErrTicketNotFound = &ParkingError{Code: CodeTicketNotFound, Status: http.StatusNotFound, Message: "ticket not found"}
+	ErrTicketClosed   = &ParkingError{Code: CodeTicketClosed, Status: http.StatusConflict, Message: "ticket already closed"}
+)
+
+// TicketFee is the fee accrued so far by an active ticket. Leaving before NextIncrementAt
+// costs Fee, and leaving after it NextIncrementAmount more; both are omitted when the fee
+// does not go up within the next day.
+type TicketFee struct {
+	TicketID            string     `json:"ticketID"`
+	ParkingLotID        int        `json:"parkingLotID"`
+	SlotNumber          int        `json:"slotNumber"`
+	EntryTime           time.Time  `json:"entryTime"`
+	ParkedMinutes       int        `json:"parkedMinutes"`
+	Fee                 int        `json:"fee"`
+	Days                []DailyFee `json:"days,omitempty"`
+	NextIncrementAt     *time.Time `json:"nextIncrementAt,omitempty"`
+	NextIncrementAmount int        `json:"nextIncrementAmount,omitempty"`
+}
+
+// GetTicketFee returns the fee an active ticket would be charged if the vehicle left now.
+func (s *ParkingLotStorage) GetTicketFee(ctx context.Context, ticketID string) (*TicketFee, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ticketFee := &TicketFee{TicketID: ticketID}
+	var slotsRequired int
+	var exitTime sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT parking_lot_id, slot, slots_required, entry_time, exit_time
+		FROM parked_vehicles
+		WHERE ticket_id = $1
+	`, ticketID).Scan(&ticketFee.ParkingLotID, &ticketFee.SlotNumber, &slotsRequired, &ticketFee.EntryTime, &exitTime)
+	if err == sql.ErrNoRows {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to look up ticket")
+	}
+	if exitTime.Valid {
+		return nil, ErrTicketClosed
+	}
+
+	now := s.now()
+	ticketFee.ParkedMinutes = int(now.Sub(ticketFee.EntryTime).Minutes())
+	fee, err := s.parkingFee(ctx, s.db, ticketFee.ParkingLotID, ticketFee.SlotNumber, slotsRequired, ticketFee.EntryTime, now)
+	if err != nil {
+		return nil, err
+	}
+	ticketFee.Fee = fee.Fee
+	ticketFee.Days = fee.Days
+
+	nextAt, amount, ok, err := s.nextFeeIncrement(ctx, s.db, ticketFee.ParkingLotID, ticketFee.SlotNumber, slotsRequired, ticketFee.EntryTime, now)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ticketFee.NextIncrementAt = &nextAt
+		ticketFee.NextIncrementAmount = amount
+	}
+	if err := s.inZoneOfLot(ctx, ticketFee.ParkingLotID, &ticketFee.EntryTime, ticketFee.NextIncrementAt); err != nil {
+		return nil, err
+	}
+
+	return ticketFee, nil
+}
+
+// Types of ticket lifecycle events.
+const (
+	TicketHeld               = "held"
+	TicketParked             = "parked"
+	TicketMaintenanceStarted = "maintenance_started"
+	TicketMaintenanceEnded   = "maintenance_ended"
+	TicketUnparked           = "unparked"
+	TicketFeeCharged         = "fee_charged"
+	TicketRefunded           = "refunded"
+	TicketNoted              = "noted"
+)
+
+// TicketEvent is one step in the life of a ticket.
+type TicketEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	SlotNumber int       `json:"slotNumber,omitempty"`
+	Amount     *int      `json:"amount,omitempty"`
+	Details    string    `json:"details,omitempty"`
+}
+
+// TicketLifecycle is the ordered timeline of a ticket.
+type TicketLifecycle struct {
+	TicketID     string         `json:"ticketID"`
+	ParkingLotID int            `json:"parkingLotID"`
+	LicensePlate string         `json:"licensePlate"`
+	Events       []*TicketEvent `json:"events"`
+}
+
+// GetTicketLifecycle returns every recorded event of a ticket in time order: the slot hold
+// it was parked from, the park, maintenance on its slots during the stay, the unpark, the
+// fee charged and operator notes on its transaction.
+func (s *ParkingLotStorage) GetTicketLifecycle(ctx context.Context, ticketID string) (*TicketLifecycle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lifecycle := &TicketLifecycle{TicketID: ticketID, Events: []*TicketEvent{}}
+	var firstSlot, slotsRequired int
+	var entryTime time.Time
+	var exitTime sql.NullTime
+	var holdToken sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT parking_lot_id, license_plate, slot, slots_required, entry_time, exit_time, hold_token
+		FROM parked_vehicles
+		WHERE ticket_id = $1
+	`, ticketID).Scan(&lifecycle.ParkingLotID, &lifecycle.LicensePlate, &firstSlot, &slotsRequired, &entryTime, &exitTime, &holdToken)
+	if err == sql.ErrNoRows {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to look up ticket")
+	}
+
+	addEvent := func(event *TicketEvent) {
+		lifecycle.Events = append(lifecycle.Events, event)
+	}
+
+	if holdToken.Valid {
+		var heldAt sql.NullTime
+		var heldSlot int
+		err := s.db.QueryRowContext(ctx, "SELECT created_at, slot FROM slot_holds WHERE token = $1", holdToken.String).Scan(&heldAt, &heldSlot)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, internalError("failed to look up slot hold")
+		}
+		if heldAt.Valid {
+			addEvent(&TicketEvent{Time: heldAt.Time, Type: TicketHeld, SlotNumber: heldSlot})
+		}
+	}
+
+	addEvent(&TicketEvent{Time: entryTime, Type: TicketParked, SlotNumber: firstSlot})
+
+	stayEnd := s.now()
+	if exitTime.Valid {
+		stayEnd = exitTime.Time
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT slot, started_at, ended_at, mode, COALESCE(reason, '')
+		FROM maintenance_windows
+		WHERE lot_id = $1 AND slot BETWEEN $2 AND $3
+		AND started_at < $5 AND (ended_at IS NULL OR ended_at > $4)
+	`, lifecycle.ParkingLotID, firstSlot, firstSlot+slotsRequired-1, entryTime, stayEnd)
+	if err != nil {
+		return nil, internalError("failed to retrieve maintenance windows")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slot int
+		var startedAt time.Time
+		var endedAt sql.NullTime
+		var mode, reason string
+		if err := rows.Scan(&slot, &startedAt, &endedAt, &mode, &reason); err != nil {
+			return nil, internalError("failed to read maintenance windows")
+		}
+		details := mode
+		if reason != "" {
+			details += ": " + reason
+		}
+		if !startedAt.Before(entryTime) {
+			addEvent(&TicketEvent{Time: startedAt, Type: TicketMaintenanceStarted, SlotNumber: slot, Details: details})
+		}
+		if endedAt.Valid && !endedAt.Time.After(stayEnd) {
+			addEvent(&TicketEvent{Time: endedAt.Time, Type: TicketMaintenanceEnded, SlotNumber: slot, Details: details})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing maintenance windows")
+	}
+
+	if exitTime.Valid {
+		addEvent(&TicketEvent{Time: exitTime.Time, Type: TicketUnparked, SlotNumber: firstSlot})
+
+		var transactionID, fee, refund int
+		err := s.db.QueryRowContext(ctx, `
+			SELECT id, COALESCE(fee, 0), refund
+			FROM parking_transactions
+			WHERE ticket_id = $1
+		`, ticketID).Scan(&transactionID, &fee, &refund)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, internalError("failed to look up ticket transaction")
+		}
+		if err == nil {
+			addEvent(&TicketEvent{Time: exitTime.Time, Type: TicketFeeCharged, Amount: &fee})
+			if refund > 0 {
+				addEvent(&TicketEvent{Time: exitTime.Time, Type: TicketRefunded, Amount: &refund})
+			}
+
+			notes, err := transactionNotes(ctx, s.db, []int{transactionID})
+			if err != nil {
+				return nil, err
+			}
+			for _, note := range notes[transactionID] {
+				addEvent(&TicketEvent{Time: note.CreatedAt, Type: TicketNoted, Details: note.Note})
+			}
+		}
+	}
+
+	sort.SliceStable(lifecycle.Events, func(i, j int) bool {
+		return lifecycle.Events[i].Time.Before(lifecycle.Events[j].Time)
+	})
+	for _, event := range lifecycle.Events {
+		if err := s.inZoneOfLot(ctx, lifecycle.ParkingLotID, &event.Time); err != nil {
+			return nil, err
+		}
+	}
+
+	return lifecycle, nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxTransactionNoteLength is the longest operator note accepted.
+const MaxTransactionNoteLength = 1000
+
+// TransactionNote is an operator's note on a transaction. Notes cannot be changed or
+// removed once added.
+type TransactionNote struct {
+	ID        int       `json:"id"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AddTransactionNote appends an operator note to the specified transaction.
+func (s *ParkingLotStorage) AddTransactionNote(ctx context.Context, transactionID int, note string) (*TransactionNote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return nil, invalidRequest("note is required")
+	}
+	if len(note) > MaxTransactionNoteLength {
+		return nil, invalidRequest("note must be at most %d characters", MaxTransactionNoteLength)
+	}
+
+	added := &TransactionNote{Note: note, CreatedAt: s.now()}
+	var parkingLotID int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO transaction_notes (transaction_id, note, created_at)
+		SELECT id, $2, $3 FROM parking_transactions WHERE id = $1
+		RETURNING id, (SELECT lot_id FROM parking_transactions WHERE id = $1)
+	`, transactionID, note, added.CreatedAt).Scan(&added.ID, &parkingLotID)
+	if err == sql.ErrNoRows {
+		return nil, newError(CodeTransactionNotFound, http.StatusNotFound, "transaction %d not found", transactionID)
+	}
+	if err != nil {
+		return nil, internalError("failed to add transaction note")
+	}
+	if err := s.inZoneOfLot(ctx, parkingLotID, &added.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
+
+// transactionNotes returns the notes of the given transactions keyed by transaction ID,
+// oldest first.
+func transactionNotes(ctx context.Context, q querier, transactionIDs []int) (map[int][]*TransactionNote, error) {
+	notes := make(map[int][]*TransactionNote)
+	if len(transactionIDs) == 0 {
+		return notes, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT transaction_id, id, note, created_at
+		FROM transaction_notes
+		WHERE transaction_id = ANY($1)
+		ORDER BY created_at, id
+	`, pq.Array(transactionIDs))
+	if err != nil {
+		return nil, internalError("failed to retrieve transaction notes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionID int
+		var note TransactionNote
+		if err := rows.Scan(&transactionID, &note.ID, &note.Note, &note.CreatedAt); err != nil {
+			return nil, internalError("failed to read transaction notes")
+		}
+		notes[transactionID] = append(notes[transactionID], &note)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing transaction notes")
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+var ErrTransactionNotFound = &ParkingError{Code: CodeTransactionNotFound, Status: http.StatusNotFound, Message: "no transactions found for vehicle"}
+
+// Transaction is a completed stay of a vehicle.
+type Transaction struct {
+	ID            int       `json:"id"`
+	ParkingLotID  int       `json:"parkingLotID"`
+	LicensePlate  string    `json:"licensePlate"`
+	SlotNumber    *int      `json:"slotNumber,omitempty"`
+	TicketID      string    `json:"ticketID,omitempty"`
+	EntryTime     time.Time `json:"entryTime"`
+	ExitTime      time.Time `json:"exitTime"`
+	Fee           int       `json:"fee"`
+	PrepaidAmount int       `json:"prepaidAmount"`
+	Refund        int       `json:"refund"`
+	EntryPhotoURL string    `json:"entryPhotoURL,omitempty"`
+	Abandoned     bool      `json:"abandoned,omitempty"`
+	// ValidationCode and Validated record the merchant validation that paid part of Fee.
+	ValidationCode string `json:"validationCode,omitempty"`
+	Validated      int    `json:"validated,omitempty"`
+	// Notes are the operator notes on the transaction, oldest first.
+	Notes []*TransactionNote `json:"notes,omitempty"`
+}
+
+// GetLastTransaction returns the most recent completed stay of a vehicle across all lots.
+func (s *ParkingLotStorage) GetLastTransaction(ctx context.Context, licensePlate string) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := validateLicensePlate(licensePlate); err != nil {
+		return nil, err
+	}
+
+	transaction, err := scanTransaction(s.db.QueryRowContext(ctx, `
+		SELECT `+transactionColumns+`
+		FROM parking_transactions
+		WHERE vehicle_license_plate = $1 AND exit_time IS NOT NULL
+		ORDER BY exit_time DESC, id DESC
+		LIMIT 1
+	`, licensePlate))
+	if err == sql.ErrNoRows {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, internalError("failed to retrieve last transaction")
+	}
+	if err := s.inZoneOfLot(ctx, transaction.ParkingLotID, &transaction.EntryTime, &transaction.ExitTime); err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// Page sizes of transaction listings.
+const (
+	defaultTransactionPageSize = 50
+	maxTransactionPageSize     = 500
+)
+
+// TransactionFilter selects transactions to list. Zero values leave a filter out.
+// Listings filtered by fee are sorted by fee, highest first, and otherwise by exit time,
+// latest first.
+type TransactionFilter struct {
+	ParkingLotID int
+	LicensePlate string
+	From         time.Time
+	To           time.Time
+	MinFee       *int
+	MaxFee       *int
+	Limit        int
+	Offset       int
+}
+
+// ListTransactions returns one page of the completed transactions matching the filter.
+func (s *ParkingLotStorage) ListTransactions(ctx context.Context, filter TransactionFilter) ([]*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if filter.LicensePlate != "" {
+		if err := validateLicensePlate(filter.LicensePlate); err != nil {
+			return nil, err
+		}
+	}
+	if filter.MinFee != nil && filter.MaxFee != nil && *filter.MinFee > *filter.MaxFee {
+		return nil, invalidRequest("minimum fee must not exceed maximum fee")
+	}
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, invalidRequest("limit and offset must not be negative")
+	}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultTransactionPageSize
+	}
+	if limit > maxTransactionPageSize {
+		limit = maxTransactionPageSize
+	}
+
+	order := "exit_time DESC, id DESC"
+	if filter.MinFee != nil || filter.MaxFee != nil {
+		order = "fee DESC, exit_time DESC, id DESC"
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+transactionColumns+`
+		FROM parking_transactions
+		WHERE exit_time IS NOT NULL
+		AND ($1 = 0 OR lot_id = $1)
+		AND ($2 = '' OR vehicle_license_plate = $2)
+		AND ($3::timestamp IS NULL OR exit_time >= $3)
+		AND ($4::timestamp IS NULL OR exit_time < $4)
+		AND ($5::int IS NULL OR fee >= $5)
+		AND ($6::int IS NULL OR fee <= $6)
+		ORDER BY `+order+`
+		LIMIT $7 OFFSET $8
+	`, filter.ParkingLotID, filter.LicensePlate, nullTime(filter.From), nullTime(filter.To), filter.MinFee, filter.MaxFee, limit, filter.Offset)
+	if err != nil {
+		return nil, internalError("failed to retrieve transactions")
+	}
+	defer rows.Close()
+
+	transactions := []*Transaction{}
+	var ids []int
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, internalError("failed to read transactions")
+		}
+		transactions = append(transactions, transaction)
+		ids = append(ids, transaction.ID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing transactions")
+	}
+
+	notes, err := transactionNotes(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		transaction.Notes = notes[transaction.ID]
+		if err := s.inZoneOfLot(ctx, transaction.ParkingLotID, &transaction.EntryTime, &transaction.ExitTime); err != nil {
+			return nil, err
+		}
+		for _, note := range transaction.Notes {
+			if err := s.inZoneOfLot(ctx, transaction.ParkingLotID, &note.CreatedAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+// transactionColumns selects the parking_transactions columns read by scanTransaction.
+const transactionColumns = `id, lot_id, vehicle_license_plate, slot, ticket_id, entry_time, exit_time, COALESCE(fee, 0), prepaid_amount, refund, COALESCE(entry_photo_url, ''), abandoned,
+	COALESCE(validation_code, ''), validated_amount`
+
+func scanTransaction(row rowScanner) (*Transaction, error) {
+	var transaction Transaction
+	var slot sql.NullInt64
+	var ticketID sql.NullString
+	err := row.Scan(&transaction.ID, &transaction.ParkingLotID, &transaction.LicensePlate, &slot, &ticketID, &transaction.EntryTime, &transaction.ExitTime,
+		&transaction.Fee, &transaction.PrepaidAmount, &transaction.Refund, &transaction.EntryPhotoURL, &transaction.Abandoned, &transaction.ValidationCode, &transaction.Validated)
+	if err != nil {
+		return nil, err
+	}
+
+	if slot.Valid {
+		slotNumber := int(slot.Int64)
+		transaction.SlotNumber = &slotNumber
+	}
+	transaction.TicketID = ticketID.String
+
+	return &transaction, nil
+}
+
+// SlotUsage is how often a vehicle was parked in a slot.
+type SlotUsage struct {
+	ParkingLotID int `json:"parkingLotID"`
+	SlotNumber   int `json:"slotNumber"`
+	Uses         int `json:"uses"`
+}
+
+// GetSlotsUsedByVehicle returns the slots a vehicle left between from and to, either of
+// which may be zero for an open bound, with how often it used each. Transactions
+// recorded without a slot are left out.
+func (s *ParkingLotStorage) GetSlotsUsedByVehicle(ctx context.Context, licensePlate string, from, to time.Time) ([]*SlotUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := validateLicensePlate(licensePlate); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT lot_id, slot, COUNT(*) AS uses
+		FROM parking_transactions
+		WHERE vehicle_license_plate = $1 AND slot IS NOT NULL
+		AND ($2::timestamp IS NULL OR exit_time >= $2)
+		AND ($3::timestamp IS NULL OR exit_time < $3)
+		GROUP BY lot_id, slot
+		ORDER BY uses DESC, lot_id, slot
+	`, licensePlate, nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, internalError("failed to retrieve slots used by vehicle")
+	}
+	defer rows.Close()
+
+	usages := []*SlotUsage{}
+	for rows.Next() {
+		var usage SlotUsage
+		if err := rows.Scan(&usage.ParkingLotID, &usage.SlotNumber, &usage.Uses); err != nil {
+			return nil, internalError("failed to read slots used by vehicle")
+		}
+		usages = append(usages, &usage)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, internalError("error processing slots used by vehicle")
+	}
+
+	return usages, nil
+}
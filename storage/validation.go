@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrValidationNotFound = &ParkingError{Code: CodeValidationNotFound, Status: http.StatusNotFound, Message: "validation not found"}
+
+// maxValidationCodeLength bounds the codes merchants can choose.
+const maxValidationCodeLength = 64
+
+// Validation lets a merchant pay for part of a customer's stay in a lot: either the first
+// Hours of it or up to Amount.
+type Validation struct {
+	Code         string `json:"code"`
+	ParkingLotID int    `json:"parkingLotID"`
+	Merchant     string `json:"merchant"`
+	Hours        int    `json:"hours,omitempty"`
+	Amount       int    `json:"amount,omitempty"`
+}
+
+// CreateValidation registers a validation for a lot. A code is generated when none is given.
+func (s *ParkingLotStorage) CreateValidation(ctx context.Context, validation Validation) (*Validation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if validation.Merchant == "" {
+		return nil, invalidRequest("merchant is required")
+	}
+	if validation.Hours < 0 || validation.Amount < 0 {
+		return nil, invalidRequest("hours and amount must not be negative")
+	}
+	if (validation.Hours > 0) == (validation.Amount > 0) {
+		return nil, invalidRequest("exactly one of hours and amount must be set")
+	}
+	if len(validation.Code) > maxValidationCodeLength {
+		return nil, invalidRequest("code must be at most %d characters", maxValidationCodeLength)
+	}
+
+	if err := s.checkLotsExist(ctx, []int{validation.ParkingLotID}); err != nil {
+		return nil, err
+	}
+
+	if validation.Code == "" {
+		code, err := newToken()
+		if err != nil {
+			return nil, internalError("failed to generate validation code")
+		}
+		validation.Code = code
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO parking_validations (code, lot_id, merchant, hours, amount, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), $6)
+	`, validation.Code, validation.ParkingLotID, validation.Merchant, validation.Hours, validation.Amount, s.now())
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, newError(CodeInvalidRequest, http.StatusConflict, "validation code %q already exists", validation.Code)
+	}
+	if err != nil {
+		return nil, internalError("failed to create validation")
+	}
+
+	return &validation, nil
+}
+
+// checkValidation fails with ErrValidationNotFound unless code is a validation of the lot.
+func checkValidation(ctx context.Context, q querier, parkingLotID int, code string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM parking_validations WHERE code = $1 AND lot_id = $2)
+	`, code, parkingLotID).Scan(&exists)
+	if err != nil {
+		return internalError("failed to look up validation")
+	}
+	if !exists {
+		return ErrValidationNotFound
+	}
+
+	return nil
+}
+
+// applyValidation splits the fee of a stay ending at exitTime between the merchant that
+// validated it and the customer. Hours validations cover what the first hours of the stay
+// would have cost on their own.
+func (s *ParkingLotStorage) applyValidation(ctx context.Context, q querier, stay *parkedStay, fee *FeeBreakdown, exitTime time.Time) error {
+	if stay.ValidationCode == "" || fee.Fee <= 0 {
+		return nil
+	}
+
+	var hours, amount sql.NullInt64
+	err := q.QueryRowContext(ctx, `
+		SELECT hours, amount FROM parking_validations WHERE code = $1 AND lot_id = $2
+	`, stay.ValidationCode, stay.ParkingLotID).Scan(&hours, &amount)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return internalError("failed to look up validation")
+	}
+
+	covered := int(amount.Int64)
+	if hours.Valid {
+		coveredUntil := stay.EntryTime.Add(time.Duration(hours.Int64) * time.Hour)
+		if coveredUntil.After(exitTime) {
+			coveredUntil = exitTime
+		}
+		coveredFee, err := s.parkingFee(ctx, q, stay.ParkingLotID, stay.FirstSlot, stay.SlotsRequired, stay.EntryTime, coveredUntil)
+		if err != nil {
+			return err
+		}
+		covered = coveredFee.Fee
+	}
+	if covered > fee.Fee {
+		covered = fee.Fee
+	}
+
+	fee.ValidationCode = stay.ValidationCode
+	fee.Validated = covered
+	fee.CustomerFee = fee.Fee - covered
+
+	return nil
+}
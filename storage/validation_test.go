@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnparkVehicleSplitsValidatedFee(t *testing.T) {
+	tests := []struct {
+		name          string
+		hours         driver.Value
+		amount        driver.Value
+		wantValidated int
+		wantCustomer  int
+	}{
+		{"amount partially covering the fee", nil, int64(10), 10, 20},
+		{"amount fully covering the fee", nil, int64(50), 30, 0},
+		{"hours partially covering the stay", int64(2), nil, 20, 10},
+		{"hours fully covering the stay", int64(4), nil, 30, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := append([]fakeResult{
+				{match: "SELECT EXISTS (SELECT 1 FROM parking_validations", rows: [][]driver.Value{{true}}},
+				{match: "SET validation_code", rowsAffected: 1},
+				{match: "SELECT hours, amount FROM parking_validations", rows: [][]driver.Value{{tt.hours, tt.amount}}},
+			}, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)
+			s, db, clock := newFakeStorage(append(results, lotResult(testLot()))...)
+			clock.Advance(150 * time.Minute)
+
+			fee, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "MALL")
+			if err != nil {
+				t.Fatalf("UnparkVehicle: %v", err)
+			}
+			if fee.Fee != 30 || fee.Validated != tt.wantValidated || fee.CustomerFee != tt.wantCustomer || fee.ValidationCode != "MALL" {
+				t.Errorf("fee = %+v, want 30 split into %d validated and %d for the customer", fee, tt.wantValidated, tt.wantCustomer)
+			}
+
+			transaction, _ := db.last("INSERT INTO parking_transactions")
+			if code, validated := transaction.args[14], transaction.args[15]; code != "MALL" || validated != int64(tt.wantValidated) {
+				t.Errorf("recorded validation %v of %v, want MALL of %d", code, validated, tt.wantValidated)
+			}
+		})
+	}
+}
+
+func TestUnparkVehicleWithUnknownValidation(t *testing.T) {
+	s, db, _ := newFakeStorage(append([]fakeResult{
+		{match: "SELECT EXISTS (SELECT 1 FROM parking_validations", rows: [][]driver.Value{{false}}},
+	}, unparkResults(parkedStayRow(1, "ABC123", 1, testTime))...)...)
+
+	if _, err := s.UnparkVehicle(context.Background(), 1, "ABC123", "NOPE"); !errors.Is(err, ErrValidationNotFound) {
+		t.Errorf("UnparkVehicle error = %v, want %v", err, ErrValidationNotFound)
+	}
+	if n := db.count("INSERT INTO parking_transactions"); n != 0 {
+		t.Errorf("%d transactions recorded with an unknown validation", n)
+	}
+}